@@ -0,0 +1,423 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/rancher/convoy-agent/health"
+)
+
+// Volume is a volume the volume agent tracks in convoy, and what it last
+// reported to Cattle about it.
+type Volume struct {
+	Name   string `json:"name"`
+	Driver string `json:"driver"`
+	// State is reported, create-failed or delete-failed after the agent
+	// sent an event for the volume, and otherwise unreported, standby while
+	// another agent reports for the pool, or quarantined.
+	State      string `json:"state"`
+	LastEvent  string `json:"lastEvent,omitempty"`
+	ReportedAt string `json:"reportedAt,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// PendingEvent is a volume event that failed to reach Cattle. The volume
+// agent retries it on its next poll.
+type PendingEvent struct {
+	Volume       string `json:"volume"`
+	Driver       string `json:"driver"`
+	Event        string `json:"event"`
+	Attempts     int    `json:"attempts"`
+	FirstFailure string `json:"firstFailure"`
+	LastFailure  string `json:"lastFailure"`
+	Error        string `json:"error"`
+}
+
+// Pool is a storage pool the storagepool agent manages, as last synced to
+// Cattle.
+type Pool struct {
+	Driver   string   `json:"driver"`
+	Selector string   `json:"selector,omitempty"`
+	Hosts    []string `json:"hosts"`
+	Leader   bool     `json:"leader"`
+	DropHeld bool     `json:"dropHeld"`
+	LastSync string   `json:"lastSync,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// Status is the agent's overall state.
+type Status struct {
+	AgentID  string          `json:"agentId,omitempty"`
+	LogLevel string          `json:"logLevel"`
+	Paused   map[string]bool `json:"paused"`
+	Health   health.Report   `json:"health"`
+}
+
+// Syncer is an agent loop that syncs state to Cattle, which the admin API can
+// pause, resume and ask to resync.
+type Syncer interface {
+	Resync()
+	SetPaused(paused bool)
+	Paused() bool
+}
+
+// VolumeTracker is implemented by the volume agent.
+type VolumeTracker interface {
+	Volumes() []Volume
+	PendingEvents() []PendingEvent
+}
+
+// PoolManager is implemented by the storagepool agent.
+type PoolManager interface {
+	Pools() []Pool
+	ApproveDrop()
+}
+
+// FormatTime formats the times the admin API reports. The zero time is left
+// empty.
+func FormatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Server serves the admin API on a unix socket. It is meant for operators on
+// the host, so it is only as accessible as the socket file.
+type Server struct {
+	socket string
+
+	mu      sync.Mutex
+	agentID string
+	syncers map[string]Syncer
+	volumes VolumeTracker
+	pools   PoolManager
+}
+
+func NewServer(socket string) *Server {
+	return &Server{
+		socket:  socket,
+		syncers: map[string]Syncer{},
+	}
+}
+
+func (s *Server) SetAgentID(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.agentID = id
+}
+
+// AddSyncer makes a sync loop controllable under name.
+func (s *Server) AddSyncer(name string, syncer Syncer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.syncers[name] = syncer
+}
+
+func (s *Server) SetVolumeTracker(volumes VolumeTracker) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.volumes = volumes
+}
+
+func (s *Server) SetPoolManager(pools PoolManager) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pools = pools
+}
+
+// Run serves the admin API until a value is sent on controlChan, then removes
+// the socket.
+func (s *Server) Run(controlChan chan bool) error {
+	l, err := listen(s.socket)
+	if err != nil {
+		return err
+	}
+	log.Infof("Serving admin API on %v", s.socket)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- http.Serve(l, s.Handler())
+	}()
+
+	select {
+	case <-controlChan:
+		l.Close()
+		<-done
+		controlChan <- true
+		return nil
+	case err := <-done:
+		l.Close()
+		return err
+	}
+}
+
+// listen listens on socket, replacing a socket left behind by an agent that
+// is gone, but not one an agent is still serving on.
+func listen(socket string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(socket), 0755); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(socket); err == nil {
+		if conn, err := net.DialTimeout("unix", socket, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("Cannot serve admin API on %v, another agent is using it", socket)
+		}
+		if err := os.Remove(socket); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Handler returns the admin API's routes.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/status", get(s.status))
+	mux.HandleFunc("/v1/volumes", get(s.listVolumes))
+	mux.HandleFunc("/v1/events/pending", get(s.pendingEvents))
+	mux.HandleFunc("/v1/pools", get(s.listPools))
+	mux.HandleFunc("/v1/pools/approve-drop", post(s.approveDrop))
+	mux.HandleFunc("/v1/resync", post(s.resync))
+	mux.HandleFunc("/v1/pause", post(s.pause(true)))
+	mux.HandleFunc("/v1/resume", post(s.pause(false)))
+	mux.HandleFunc("/v1/log-level", s.logLevel)
+	return mux
+}
+
+// apiError is an error with the HTTP status to answer it with.
+type apiError struct {
+	code    int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+func notFound(format string, args ...interface{}) error {
+	return &apiError{http.StatusNotFound, fmt.Sprintf(format, args...)}
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &apiError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+// ErrorResponse is the body of a failed request.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+type handlerFunc func(r *http.Request) (interface{}, error)
+
+func get(h handlerFunc) http.HandlerFunc {
+	return method("GET", h)
+}
+
+func post(h handlerFunc) http.HandlerFunc {
+	return method("POST", h)
+}
+
+func method(name string, h handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != name {
+			writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{fmt.Sprintf("%s %s is not supported", r.Method, r.URL.Path)})
+			return
+		}
+		serve(w, r, h)
+	}
+}
+
+func serve(w http.ResponseWriter, r *http.Request, h handlerFunc) {
+	body, err := h(r)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if apiErr, ok := err.(*apiError); ok {
+			code = apiErr.code
+		}
+		writeJSON(w, code, ErrorResponse{err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Errorf("Error writing admin API response [%v]", err)
+	}
+}
+
+func (s *Server) status(r *http.Request) (interface{}, error) {
+	s.mu.Lock()
+	status := Status{
+		AgentID:  s.agentID,
+		LogLevel: log.GetLevel().String(),
+		Paused:   map[string]bool{},
+	}
+	for name, syncer := range s.syncers {
+		status.Paused[name] = syncer.Paused()
+	}
+	s.mu.Unlock()
+
+	status.Health = health.Run()
+	return status, nil
+}
+
+func (s *Server) volumeTracker() (VolumeTracker, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.volumes == nil {
+		return nil, notFound("This agent does not track volumes")
+	}
+	return s.volumes, nil
+}
+
+func (s *Server) listVolumes(r *http.Request) (interface{}, error) {
+	volumes, err := s.volumeTracker()
+	if err != nil {
+		return nil, err
+	}
+	return volumes.Volumes(), nil
+}
+
+func (s *Server) pendingEvents(r *http.Request) (interface{}, error) {
+	volumes, err := s.volumeTracker()
+	if err != nil {
+		return nil, err
+	}
+	return volumes.PendingEvents(), nil
+}
+
+func (s *Server) poolManager() (PoolManager, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pools == nil {
+		return nil, notFound("This agent does not manage storage pools")
+	}
+	return s.pools, nil
+}
+
+func (s *Server) listPools(r *http.Request) (interface{}, error) {
+	pools, err := s.poolManager()
+	if err != nil {
+		return nil, err
+	}
+	return pools.Pools(), nil
+}
+
+func (s *Server) approveDrop(r *http.Request) (interface{}, error) {
+	pools, err := s.poolManager()
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Approving any held storage pool membership drop, as requested through the admin API")
+	pools.ApproveDrop()
+	return map[string]string{"result": "approved"}, nil
+}
+
+// selectSyncers returns the syncers named by the syncer query parameter, or
+// all of them.
+func (s *Server) selectSyncers(r *http.Request) ([]string, map[string]Syncer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.syncers) == 0 {
+		return nil, nil, notFound("This agent has nothing to sync")
+	}
+
+	selected := map[string]Syncer{}
+	if name := r.URL.Query().Get("syncer"); name != "" {
+		syncer, ok := s.syncers[name]
+		if !ok {
+			return nil, nil, notFound("Unknown syncer %q", name)
+		}
+		selected[name] = syncer
+	} else {
+		for name, syncer := range s.syncers {
+			selected[name] = syncer
+		}
+	}
+	names := []string{}
+	for name := range selected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, selected, nil
+}
+
+func (s *Server) resync(r *http.Request) (interface{}, error) {
+	names, syncers, err := s.selectSyncers(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		log.Infof("Resyncing %s, as requested through the admin API", name)
+		syncers[name].Resync()
+	}
+	return map[string][]string{"resynced": names}, nil
+}
+
+func (s *Server) pause(paused bool) handlerFunc {
+	return func(r *http.Request) (interface{}, error) {
+		names, syncers, err := s.selectSyncers(r)
+		if err != nil {
+			return nil, err
+		}
+		state := map[string]bool{}
+		for _, name := range names {
+			if paused {
+				log.Warnf("Pausing %s, as requested through the admin API", name)
+			} else {
+				log.Infof("Resuming %s, as requested through the admin API", name)
+			}
+			syncers[name].SetPaused(paused)
+			state[name] = paused
+		}
+		return map[string]map[string]bool{"paused": state}, nil
+	}
+}
+
+// LogLevel is the body of the log-level endpoint.
+type LogLevel struct {
+	Level string `json:"level"`
+}
+
+func (s *Server) logLevel(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, func(r *http.Request) (interface{}, error) {
+		switch r.Method {
+		case "GET":
+		case "PUT", "POST":
+			req := LogLevel{}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				return nil, badRequest("Invalid log level request: %v", err)
+			}
+			level, err := log.ParseLevel(req.Level)
+			if err != nil {
+				return nil, badRequest("%v", err)
+			}
+			log.SetLevel(level)
+			log.Infof("Log level set to %s through the admin API", level)
+		default:
+			return nil, &apiError{http.StatusMethodNotAllowed, fmt.Sprintf("%s %s is not supported", r.Method, r.URL.Path)}
+		}
+		return LogLevel{Level: log.GetLevel().String()}, nil
+	})
+}
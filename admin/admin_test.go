@@ -0,0 +1,137 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type AdminTestSuite struct {
+	server  *Server
+	syncer  *fakeSyncer
+	volumes *fakeVolumes
+}
+
+var _ = check.Suite(&AdminTestSuite{})
+
+type fakeSyncer struct {
+	resyncs int
+	paused  bool
+}
+
+func (f *fakeSyncer) Resync()               { f.resyncs++ }
+func (f *fakeSyncer) SetPaused(paused bool) { f.paused = paused }
+func (f *fakeSyncer) Paused() bool          { return f.paused }
+
+type fakeVolumes struct{}
+
+func (fakeVolumes) Volumes() []Volume {
+	return []Volume{{Name: "vol1", Driver: "vfs", State: "reported", LastEvent: "create"}}
+}
+
+func (fakeVolumes) PendingEvents() []PendingEvent {
+	return []PendingEvent{{Volume: "vol2", Driver: "vfs", Event: "delete", Attempts: 2, Error: "connection refused"}}
+}
+
+func (s *AdminTestSuite) SetUpTest(c *check.C) {
+	s.syncer = &fakeSyncer{}
+	s.volumes = &fakeVolumes{}
+	s.server = NewServer("")
+	s.server.SetAgentID("agent-1")
+	s.server.AddSyncer("volumes", s.syncer)
+	s.server.SetVolumeTracker(s.volumes)
+}
+
+func (s *AdminTestSuite) do(c *check.C, method, path string, body interface{}, out interface{}) int {
+	var reqBody bytes.Buffer
+	if body != nil {
+		c.Assert(json.NewEncoder(&reqBody).Encode(body), check.IsNil)
+	}
+	w := httptest.NewRecorder()
+	s.server.Handler().ServeHTTP(w, httptest.NewRequest(method, path, &reqBody))
+	if out != nil {
+		c.Assert(json.Unmarshal(w.Body.Bytes(), out), check.IsNil)
+	}
+	return w.Code
+}
+
+func (s *AdminTestSuite) TestStatus(c *check.C) {
+	status := Status{}
+	c.Assert(s.do(c, "GET", "/v1/status", nil, &status), check.Equals, http.StatusOK)
+	c.Assert(status.AgentID, check.Equals, "agent-1")
+	c.Assert(status.Paused, check.DeepEquals, map[string]bool{"volumes": false})
+}
+
+func (s *AdminTestSuite) TestVolumesAndPendingEvents(c *check.C) {
+	volumes := []Volume{}
+	c.Assert(s.do(c, "GET", "/v1/volumes", nil, &volumes), check.Equals, http.StatusOK)
+	c.Assert(volumes, check.DeepEquals, s.volumes.Volumes())
+
+	pending := []PendingEvent{}
+	c.Assert(s.do(c, "GET", "/v1/events/pending", nil, &pending), check.Equals, http.StatusOK)
+	c.Assert(pending, check.DeepEquals, s.volumes.PendingEvents())
+
+	c.Assert(s.do(c, "POST", "/v1/volumes", nil, nil), check.Equals, http.StatusMethodNotAllowed)
+}
+
+func (s *AdminTestSuite) TestNoPools(c *check.C) {
+	errResp := ErrorResponse{}
+	c.Assert(s.do(c, "GET", "/v1/pools", nil, &errResp), check.Equals, http.StatusNotFound)
+	c.Assert(errResp.Error, check.Equals, "This agent does not manage storage pools")
+}
+
+func (s *AdminTestSuite) TestResyncAndPause(c *check.C) {
+	c.Assert(s.do(c, "POST", "/v1/resync", nil, nil), check.Equals, http.StatusOK)
+	c.Assert(s.syncer.resyncs, check.Equals, 1)
+
+	c.Assert(s.do(c, "POST", "/v1/pause?syncer=volumes", nil, nil), check.Equals, http.StatusOK)
+	c.Assert(s.syncer.paused, check.Equals, true)
+	c.Assert(s.do(c, "POST", "/v1/resume", nil, nil), check.Equals, http.StatusOK)
+	c.Assert(s.syncer.paused, check.Equals, false)
+
+	c.Assert(s.do(c, "POST", "/v1/pause?syncer=pools", nil, nil), check.Equals, http.StatusNotFound)
+}
+
+func (s *AdminTestSuite) TestLogLevel(c *check.C) {
+	defer log.SetLevel(log.GetLevel())
+
+	level := LogLevel{}
+	c.Assert(s.do(c, "PUT", "/v1/log-level", LogLevel{Level: "debug"}, &level), check.Equals, http.StatusOK)
+	c.Assert(level.Level, check.Equals, "debug")
+	c.Assert(log.GetLevel(), check.Equals, log.DebugLevel)
+
+	c.Assert(s.do(c, "PUT", "/v1/log-level", LogLevel{Level: "loud"}, nil), check.Equals, http.StatusBadRequest)
+	c.Assert(s.do(c, "GET", "/v1/log-level", nil, &level), check.Equals, http.StatusOK)
+	c.Assert(level.Level, check.Equals, "debug")
+}
+
+func (s *AdminTestSuite) TestListenReplacesStaleSocket(c *check.C) {
+	socket := filepath.Join(c.MkDir(), "admin.sock")
+	f, err := os.Create(socket)
+	c.Assert(err, check.IsNil)
+	f.Close()
+
+	l, err := listen(socket)
+	c.Assert(err, check.IsNil)
+	defer l.Close()
+
+	_, err = listen(socket)
+	c.Assert(err, check.ErrorMatches, "Cannot serve admin API on .*, another agent is using it")
+
+	conn, err := net.Dial("unix", socket)
+	c.Assert(err, check.IsNil)
+	conn.Close()
+}
@@ -0,0 +1,88 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Client talks to a running agent's admin API.
+type Client struct {
+	socket string
+	client *http.Client
+}
+
+func NewClient(socket string) *Client {
+	return &Client{
+		socket: socket,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				Dial: func(_, _ string) (net.Conn, error) {
+					return net.DialTimeout("unix", socket, 5*time.Second)
+				},
+			},
+		},
+	}
+}
+
+func (c *Client) do(method, path string, body, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(content)
+	}
+	req, err := http.NewRequest(method, "http://agent"+path, reqBody)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Cannot reach the agent's admin API on %v. Is the agent running? Error: %v", c.socket, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		errResp := ErrorResponse{}
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil || errResp.Error == "" {
+			return fmt.Errorf("Admin API request %s %s failed: %s", method, path, resp.Status)
+		}
+		return fmt.Errorf("%s", errResp.Error)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *Client) Status() (Status, error) {
+	status := Status{}
+	err := c.do("GET", "/v1/status", nil, &status)
+	return status, err
+}
+
+func (c *Client) Volumes() ([]Volume, error) {
+	volumes := []Volume{}
+	err := c.do("GET", "/v1/volumes", nil, &volumes)
+	return volumes, err
+}
+
+func (c *Client) PendingEvents() ([]PendingEvent, error) {
+	pending := []PendingEvent{}
+	err := c.do("GET", "/v1/events/pending", nil, &pending)
+	return pending, err
+}
+
+func (c *Client) Pools() ([]Pool, error) {
+	pools := []Pool{}
+	err := c.do("GET", "/v1/pools", nil, &pools)
+	return pools, err
+}
@@ -0,0 +1,119 @@
+package admin
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+type ClientTestSuite struct {
+	client      *Client
+	controlChan chan bool
+}
+
+var _ = check.Suite(&ClientTestSuite{})
+
+func (s *ClientTestSuite) SetUpTest(c *check.C) {
+	socket := filepath.Join(c.MkDir(), "admin.sock")
+	server := NewServer(socket)
+	server.SetAgentID("agent-1")
+	server.AddSyncer("volumes", &fakeSyncer{})
+	server.SetVolumeTracker(fakeVolumes{})
+
+	s.controlChan = make(chan bool, 1)
+	go server.Run(s.controlChan)
+	time.Sleep(50 * time.Millisecond)
+	s.client = NewClient(socket)
+}
+
+func (s *ClientTestSuite) TearDownTest(c *check.C) {
+	s.controlChan <- true
+	<-s.controlChan
+}
+
+func (s *ClientTestSuite) TestClient(c *check.C) {
+	status, err := s.client.Status()
+	c.Assert(err, check.IsNil)
+	c.Assert(status.AgentID, check.Equals, "agent-1")
+
+	volumes, err := s.client.Volumes()
+	c.Assert(err, check.IsNil)
+	c.Assert(volumes, check.DeepEquals, fakeVolumes{}.Volumes())
+
+	pending, err := s.client.PendingEvents()
+	c.Assert(err, check.IsNil)
+	c.Assert(pending, check.DeepEquals, fakeVolumes{}.PendingEvents())
+
+	_, err = s.client.Pools()
+	c.Assert(err, check.ErrorMatches, "This agent does not manage storage pools")
+}
+
+func (s *ClientTestSuite) TestNoAgent(c *check.C) {
+	_, err := NewClient(filepath.Join(c.MkDir(), "admin.sock")).Status()
+	c.Assert(err, check.ErrorMatches, "Cannot reach the agent's admin API on .*")
+}
+
+type OutputTestSuite struct{}
+
+var _ = check.Suite(&OutputTestSuite{})
+
+func (s *OutputTestSuite) TestYAML(c *check.C) {
+	buf := &bytes.Buffer{}
+	pools := []Pool{
+		{Driver: "vfs", Selector: "storage.zone=a", Hosts: []string{"host1", "host2"}, Leader: true},
+		{Driver: "ebs", Hosts: []string{}, Error: "connection refused: \"cattle\""},
+	}
+	c.Assert(WriteOutput(buf, FormatYAML, pools, nil), check.IsNil)
+	c.Assert(buf.String(), check.Equals, `- driver: vfs
+  dropHeld: false
+  hosts:
+  - host1
+  - host2
+  leader: true
+  selector: storage.zone=a
+- driver: ebs
+  dropHeld: false
+  error: 'connection refused: "cattle"'
+  hosts: []
+  leader: false
+`)
+}
+
+func (s *OutputTestSuite) TestYAMLNestedMaps(c *check.C) {
+	buf := &bytes.Buffer{}
+	c.Assert(WriteOutput(buf, FormatYAML, map[string]interface{}{
+		"paused": map[string]bool{"pools": true},
+		"empty":  map[string]bool{},
+		"count":  3,
+		"bytes":  int64(10737418240),
+		"ratio":  0.25,
+		"flag":   "true",
+	}, nil), check.IsNil)
+	c.Assert(buf.String(), check.Equals, `bytes: 10737418240
+count: 3
+empty: {}
+flag: "true"
+paused:
+  pools: true
+ratio: 0.25
+`)
+}
+
+func (s *OutputTestSuite) TestTableAndJSON(c *check.C) {
+	buf := &bytes.Buffer{}
+	c.Assert(WriteOutput(buf, FormatJSON, LogLevel{Level: "debug"}, nil), check.IsNil)
+	c.Assert(buf.String(), check.Equals, "{\n  \"level\": \"debug\"\n}\n")
+
+	buf.Reset()
+	c.Assert(WriteOutput(buf, FormatTable, nil, func(w io.Writer) {
+		fmt.Fprintln(w, "NAME\tSTATE")
+		fmt.Fprintln(w, "vol1\treported")
+	}), check.IsNil)
+	c.Assert(buf.String(), check.Equals, "NAME  STATE\nvol1  reported\n")
+
+	c.Assert(WriteOutput(buf, "xml", nil, nil), check.ErrorMatches, "Unknown output format \"xml\".*")
+}
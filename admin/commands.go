@@ -0,0 +1,161 @@
+package admin
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+)
+
+// OutputFlag selects the format commands write their results in.
+var OutputFlag = cli.StringFlag{
+	Name:  "output, o",
+	Value: FormatTable,
+	Usage: "output format: table, json or yaml",
+}
+
+// Commands inspect a running agent through its admin API, found at the
+// admin-socket global flag.
+var Commands = []cli.Command{
+	{
+		Name:   "status",
+		Usage:  "Show the running agent's identity, sync state and health",
+		Flags:  []cli.Flag{OutputFlag},
+		Action: showStatus,
+	},
+	{
+		Name:   "volumes",
+		Usage:  "List the volumes the running volume agent tracks and what it last reported about each",
+		Flags:  []cli.Flag{OutputFlag},
+		Action: listVolumes,
+	},
+	{
+		Name:   "pool",
+		Usage:  "Show the storage pools the running storagepool agent manages and their hosts",
+		Flags:  []cli.Flag{OutputFlag},
+		Action: showPools,
+	},
+	{
+		Name:  "events",
+		Usage: "Inspect the running volume agent's volume events",
+		Subcommands: []cli.Command{
+			{
+				Name:   "pending",
+				Usage:  "List volume events that failed to reach Cattle and are being retried",
+				Flags:  []cli.Flag{OutputFlag},
+				Action: listPendingEvents,
+			},
+		},
+	},
+}
+
+func clientFromContext(c *cli.Context) *Client {
+	socket := c.GlobalString("admin-socket")
+	if socket == "" {
+		log.Fatal("required field admin-socket has not been set")
+	}
+	return NewClient(socket)
+}
+
+func output(c *cli.Context, v interface{}, table func(w io.Writer)) {
+	if err := WriteOutput(os.Stdout, c.String("output"), v, table); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func showStatus(c *cli.Context) {
+	status, err := clientFromContext(c).Status()
+	if err != nil {
+		log.Fatal(err)
+	}
+	output(c, status, func(w io.Writer) {
+		healthy := "healthy"
+		if !status.Health.Healthy {
+			healthy = "unhealthy"
+		} else if !status.Health.Ready {
+			healthy = "not ready"
+		}
+		fmt.Fprintf(w, "AGENT\t%s\n", status.AgentID)
+		fmt.Fprintf(w, "LOG LEVEL\t%s\n", status.LogLevel)
+		fmt.Fprintf(w, "HEALTH\t%s\n", healthy)
+		for _, name := range sortedNames(status.Paused) {
+			state := "syncing"
+			if status.Paused[name] {
+				state = "paused"
+			}
+			fmt.Fprintf(w, "SYNC %s\t%s\n", strings.ToUpper(name), state)
+		}
+
+		components := []string{}
+		for name := range status.Health.Components {
+			components = append(components, name)
+		}
+		sort.Strings(components)
+		fmt.Fprintln(w, "\nCOMPONENT\tHEALTHY\tREADY\tMESSAGE")
+		for _, name := range components {
+			s := status.Health.Components[name]
+			fmt.Fprintf(w, "%s\t%v\t%v\t%s\n", name, s.Healthy, s.Ready, s.Message)
+		}
+	})
+}
+
+func sortedNames(m map[string]bool) []string {
+	names := []string{}
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func listVolumes(c *cli.Context) {
+	volumes, err := clientFromContext(c).Volumes()
+	if err != nil {
+		log.Fatal(err)
+	}
+	output(c, volumes, func(w io.Writer) {
+		fmt.Fprintln(w, "NAME\tDRIVER\tSTATE\tLAST EVENT\tREPORTED\tERROR")
+		for _, v := range volumes {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", v.Name, v.Driver, v.State, v.LastEvent, v.ReportedAt, v.Error)
+		}
+	})
+}
+
+func showPools(c *cli.Context) {
+	pools, err := clientFromContext(c).Pools()
+	if err != nil {
+		log.Fatal(err)
+	}
+	output(c, pools, func(w io.Writer) {
+		fmt.Fprintln(w, "POOL\tSELECTOR\tHOSTS\tLEADER\tDROP HELD\tLAST SYNC\tERROR")
+		for _, p := range pools {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%v\t%v\t%s\t%s\n", p.Driver, p.Selector, len(p.Hosts), p.Leader, p.DropHeld, p.LastSync, p.Error)
+		}
+		for _, p := range pools {
+			if len(p.Hosts) == 0 {
+				continue
+			}
+			fmt.Fprintf(w, "\nHOSTS IN %s\n", p.Driver)
+			for _, host := range p.Hosts {
+				fmt.Fprintln(w, host)
+			}
+		}
+	})
+}
+
+func listPendingEvents(c *cli.Context) {
+	pending, err := clientFromContext(c).PendingEvents()
+	if err != nil {
+		log.Fatal(err)
+	}
+	output(c, pending, func(w io.Writer) {
+		fmt.Fprintln(w, "VOLUME\tDRIVER\tEVENT\tATTEMPTS\tFIRST FAILURE\tLAST FAILURE\tERROR")
+		for _, e := range pending {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n", e.Volume, e.Driver, e.Event, e.Attempts, e.FirstFailure, e.LastFailure, e.Error)
+		}
+	})
+}
@@ -0,0 +1,63 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Output formats for the inspect commands.
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+	FormatYAML  = "yaml"
+)
+
+// WriteOutput writes v in the given format: as JSON or YAML, or as a table
+// using table.
+func WriteOutput(w io.Writer, format string, v interface{}, table func(w io.Writer)) error {
+	switch format {
+	case FormatTable, "":
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		table(tw)
+		return tw.Flush()
+	case FormatJSON:
+		content, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", content)
+		return err
+	case FormatYAML:
+		return writeYAML(w, v)
+	}
+	return fmt.Errorf("Unknown output format %q, expected table, json or yaml", format)
+}
+
+// writeYAML writes v as YAML. Values go through their JSON encoding first, so
+// the field names match the JSON output.
+func writeYAML(w io.Writer, v interface{}) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var generic interface{}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	// Numbers stay as written, so that large integers such as byte counts
+	// are not turned into floats.
+	decoder.UseNumber()
+	if err := decoder.Decode(&generic); err != nil {
+		return err
+	}
+
+	content, err = yaml.Marshal(generic)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	return err
+}
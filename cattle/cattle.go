@@ -2,8 +2,13 @@ package cattle
 
 import (
 	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy-agent/health"
 	"github.com/rancher/convoy/api"
 	"github.com/rancher/go-rancher/client"
 )
@@ -11,11 +16,29 @@ import (
 type CattleInterface interface {
 	CreateVolume(string, api.VolumeResponse) error
 	DeleteVolume(string, api.VolumeResponse) error
-	SyncStoragePool(string, []string) error
+	SyncStoragePool(StoragePool, []string) error
+}
+
+// StoragePool is what is reported to Cattle about a storage pool, besides
+// its member hosts.
+type StoragePool struct {
+	Driver      string
+	Description string
+	Data        map[string]interface{}
 }
 
 type CattleClient struct {
 	rancherClient *client.RancherClient
+	opts          client.ClientOpts
+	namer         VolumeNamer
+	reporter      string
+
+	// findAccount looks up the account resource with a numeric id, such as
+	// the one in account scoped volume names. The API ids of the accounts
+	// found are cached in accountIds.
+	findAccount func(accountId int64) (*client.Account, error)
+	accountsMu  sync.Mutex
+	accountIds  map[int64]string
 }
 
 func NewCattleClient(cattleUrl, cattleAccessKey, cattleSecretKey string) (*CattleClient, error) {
@@ -23,63 +46,160 @@ func NewCattleClient(cattleUrl, cattleAccessKey, cattleSecretKey string) (*Cattl
 		return nil, errors.New("cattle url is empty")
 	}
 
-	apiClient, err := client.NewRancherClient(&client.ClientOpts{
+	opts := client.ClientOpts{
 		Url:       cattleUrl,
 		AccessKey: cattleAccessKey,
 		SecretKey: cattleSecretKey,
-	})
+	}
+	apiClient, err := client.NewRancherClient(&opts)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &CattleClient{
+	c := &CattleClient{
 		rancherClient: apiClient,
-	}, nil
+		opts:          opts,
+		namer:         globalNamer{},
+	}
+	c.findAccount = c.listAccount
+	return c, nil
+}
+
+// SetVolumeNamer sets how convoy volume names are mapped back to Cattle
+// volume names and accounts when reporting volume events.
+func (c *CattleClient) SetVolumeNamer(namer VolumeNamer) {
+	c.namer = namer
+}
+
+// SetReporter sets the agent UUID included in every event as the identity
+// of the agent that reported it.
+func (c *CattleClient) SetReporter(uuid string) {
+	c.reporter = uuid
+}
+
+// eventData returns the data sent with every event.
+func (c *CattleClient) eventData() map[string]interface{} {
+	if c.reporter == "" {
+		return nil
+	}
+	return map[string]interface{}{"reporterUuid": c.reporter}
 }
 
 func (c *CattleClient) CreateVolume(driver string, vol api.VolumeResponse) error {
 	log.Debugf("create event %s", vol.Name)
-	eveResource := c.processVolume("volume.create", driver, vol)
-	_, err := c.rancherClient.ExternalVolumeEvent.Create(eveResource)
+	eveResource, err := c.processVolume("volume.create", driver, vol)
+	if err != nil {
+		return err
+	}
+	_, err = c.rancherClient.ExternalVolumeEvent.Create(eveResource)
 	return err
 }
 
-func (c *CattleClient) processVolume(event, driver string, vol api.VolumeResponse) *client.ExternalVolumeEvent {
+func (c *CattleClient) processVolume(event, driver string, vol api.VolumeResponse) (*client.ExternalVolumeEvent, error) {
 	opts := map[string]interface{}{}
+	accountId, name := c.namer.CattleName(vol.Name)
 	volume := client.Volume{
-		Name:       vol.Name,
+		Name:       name,
 		Driver:     driver,
 		DriverOpts: opts,
 		ExternalId: vol.Name,
 	}
-	return &client.ExternalVolumeEvent{
+	eve := &client.ExternalVolumeEvent{
 		EventType:  event,
 		ExternalId: vol.Name,
 		Volume:     volume,
+		Data:       c.eventData(),
+	}
+	if accountId != 0 {
+		id, err := c.accountResourceId(accountId)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot report volume %v to its account. Error: %v", vol.Name, err)
+		}
+		eve.ReportedAccountId = id
+	}
+	return eve, nil
+}
+
+// accountResourceId returns the API id of the account with a numeric id.
+func (c *CattleClient) accountResourceId(accountId int64) (string, error) {
+	c.accountsMu.Lock()
+	defer c.accountsMu.Unlock()
+	if id, ok := c.accountIds[accountId]; ok {
+		return id, nil
 	}
+	account, err := c.findAccount(accountId)
+	if err != nil {
+		return "", err
+	}
+	if c.accountIds == nil {
+		c.accountIds = map[int64]string{}
+	}
+	c.accountIds[accountId] = account.Id
+	return account.Id, nil
+}
+
+func (c *CattleClient) listAccount(accountId int64) (*client.Account, error) {
+	accounts, err := c.rancherClient.Account.List(&client.ListOpts{
+		Filters: map[string]interface{}{"id": accountId},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Cannot look up account %d. Error: %v", accountId, err)
+	}
+	if len(accounts.Data) != 1 {
+		return nil, fmt.Errorf("Cannot find account %d, found %d matches", accountId, len(accounts.Data))
+	}
+	return &accounts.Data[0], nil
 }
 
 func (c *CattleClient) DeleteVolume(driver string, vol api.VolumeResponse) error {
 	log.Debugf("delete event %s", vol.Name)
-	eveResource := c.processVolume("volume.delete", driver, vol)
-	_, err := c.rancherClient.ExternalVolumeEvent.Create(eveResource)
+	eveResource, err := c.processVolume("volume.delete", driver, vol)
+	if err != nil {
+		return err
+	}
+	_, err = c.rancherClient.ExternalVolumeEvent.Create(eveResource)
 	return err
 }
 
-func (c *CattleClient) SyncStoragePool(driver string, hostUuids []string) error {
+// SyncStoragePool reports the pool's member hosts to Cattle along with its
+// description and data, such as its capabilities, capacity and usage.
+func (c *CattleClient) SyncStoragePool(pool StoragePool, hostUuids []string) error {
 	log.Debugf("storagepool event %v", hostUuids)
 	sp := client.StoragePool{
-		Name:       driver,
-		ExternalId: driver,
-		DriverName: driver,
+		Name:        pool.Driver,
+		ExternalId:  pool.Driver,
+		DriverName:  pool.Driver,
+		Description: pool.Description,
+		Data:        pool.Data,
 	}
 	espe := &client.ExternalStoragePoolEvent{
 		EventType:   "storagepool.create",
 		HostUuids:   hostUuids,
-		ExternalId:  driver,
+		ExternalId:  pool.Driver,
 		StoragePool: sp,
+		Data:        c.eventData(),
 	}
 	_, err := c.rancherClient.ExternalStoragePoolEvent.Create(espe)
 	return err
 }
+
+// Check reports whether Cattle's API answers with the agent's credentials.
+func (c *CattleClient) Check() health.Status {
+	req, err := http.NewRequest("GET", c.opts.Url, nil)
+	if err != nil {
+		return health.Failing(err)
+	}
+	req.SetBasicAuth(c.opts.AccessKey, c.opts.SecretKey)
+
+	httpClient := &http.Client{Timeout: 5 * time.Second}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return health.Failing(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return health.Failing(fmt.Errorf("Cattle API at %v answered %v", c.opts.Url, resp.Status))
+	}
+	return health.OK(c.opts.Url)
+}
@@ -0,0 +1,58 @@
+package cattle
+
+import (
+	"fmt"
+
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/go-rancher/client"
+	"gopkg.in/check.v1"
+)
+
+type CattleTestSuite struct {
+}
+
+var _ = check.Suite(&CattleTestSuite{})
+
+func (s *CattleTestSuite) TestEventsCarryReporter(c *check.C) {
+	cattle := &CattleClient{namer: globalNamer{}}
+	eve, err := cattle.processVolume("volume.create", "nfs", api.VolumeResponse{Name: "vol1"})
+	c.Assert(err, check.IsNil)
+	c.Assert(eve.Data, check.IsNil)
+
+	cattle.SetReporter("agent-1")
+	eve, err = cattle.processVolume("volume.create", "nfs", api.VolumeResponse{Name: "vol1"})
+	c.Assert(err, check.IsNil)
+	c.Assert(eve.Data, check.DeepEquals, map[string]interface{}{"reporterUuid": "agent-1"})
+}
+
+func (s *CattleTestSuite) TestReportedAccountFromAccountResource(c *check.C) {
+	lookups := 0
+	cattle := &CattleClient{namer: accountNamer{owners: map[string]int64{"old": 7}}}
+	cattle.findAccount = func(accountId int64) (*client.Account, error) {
+		lookups++
+		if accountId == 9 {
+			return nil, fmt.Errorf("Cannot find account %d", accountId)
+		}
+		return &client.Account{Resource: client.Resource{Id: fmt.Sprintf("1a%d", accountId+100)}}, nil
+	}
+
+	eve, err := cattle.processVolume("volume.create", "nfs", api.VolumeResponse{Name: "acct5--data"})
+	c.Assert(err, check.IsNil)
+	c.Assert(eve.ReportedAccountId, check.Equals, "1a105")
+	c.Assert(eve.Volume.Name, check.Equals, "data")
+	eve, err = cattle.processVolume("volume.delete", "nfs", api.VolumeResponse{Name: "acct5--data"})
+	c.Assert(err, check.IsNil)
+	c.Assert(eve.ReportedAccountId, check.Equals, "1a105")
+	c.Assert(lookups, check.Equals, 1)
+
+	// Unprefixed volumes belong to the account recorded for them, if any.
+	eve, err = cattle.processVolume("volume.create", "nfs", api.VolumeResponse{Name: "old"})
+	c.Assert(err, check.IsNil)
+	c.Assert(eve.ReportedAccountId, check.Equals, "1a107")
+	eve, err = cattle.processVolume("volume.create", "nfs", api.VolumeResponse{Name: "other"})
+	c.Assert(err, check.IsNil)
+	c.Assert(eve.ReportedAccountId, check.Equals, "")
+
+	_, err = cattle.processVolume("volume.create", "nfs", api.VolumeResponse{Name: "acct9--data"})
+	c.Assert(err, check.ErrorMatches, "Cannot report volume acct9--data to its account.*")
+}
@@ -0,0 +1,92 @@
+package cattle
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/rancher/go-rancher/client"
+)
+
+// PoolInventory is what Cattle has recorded for a storage pool.
+type PoolInventory struct {
+	// Found is false if Cattle has no active storage pool for the driver.
+	Found bool
+	// HostUuids are the pool's member hosts.
+	HostUuids []string
+	// Volumes are the external IDs, that is the convoy names, of the pool's
+	// volumes.
+	Volumes []string
+}
+
+// goneStates are the states of resources Cattle has removed or is removing.
+var goneStates = map[string]bool{
+	"removing": true,
+	"removed":  true,
+	"purging":  true,
+	"purged":   true,
+}
+
+// GetStoragePool reads the hosts and volumes Cattle has for the storage pool
+// the agent reports as driver.
+func (c *CattleClient) GetStoragePool(driver string) (PoolInventory, error) {
+	inventory := PoolInventory{HostUuids: []string{}, Volumes: []string{}}
+
+	pools, err := c.rancherClient.StoragePool.List(&client.ListOpts{
+		Filters: map[string]interface{}{"externalId": driver},
+	})
+	if err != nil {
+		return inventory, fmt.Errorf("Cannot list storage pools in Cattle. Name: %v. Error: %v", driver, err)
+	}
+	var pool *client.StoragePool
+	for i := range pools.Data {
+		if !goneStates[pools.Data[i].State] {
+			pool = &pools.Data[i]
+			break
+		}
+	}
+	if pool == nil {
+		return inventory, nil
+	}
+	inventory.Found = true
+
+	resource, link := pool.Resource, "hosts"
+	for link != "" {
+		page := &client.HostCollection{}
+		if err := c.rancherClient.GetLink(resource, link, page); err != nil {
+			return inventory, fmt.Errorf("Cannot list storage pool hosts in Cattle. Name: %v. Error: %v", driver, err)
+		}
+		for _, host := range page.Data {
+			if !goneStates[host.State] {
+				inventory.HostUuids = append(inventory.HostUuids, host.Uuid)
+			}
+		}
+		resource, link = nextPage(page.Pagination)
+	}
+
+	resource, link = pool.Resource, "volumes"
+	for link != "" {
+		page := &client.VolumeCollection{}
+		if err := c.rancherClient.GetLink(resource, link, page); err != nil {
+			return inventory, fmt.Errorf("Cannot list storage pool volumes in Cattle. Name: %v. Error: %v", driver, err)
+		}
+		for _, vol := range page.Data {
+			if !goneStates[vol.State] && vol.ExternalId != "" {
+				inventory.Volumes = append(inventory.Volumes, vol.ExternalId)
+			}
+		}
+		resource, link = nextPage(page.Pagination)
+	}
+
+	sort.Strings(inventory.HostUuids)
+	sort.Strings(inventory.Volumes)
+	return inventory, nil
+}
+
+// nextPage returns a resource and link to follow for the collection's next
+// page, or an empty link on the last page.
+func nextPage(pagination *client.Pagination) (client.Resource, string) {
+	if pagination == nil || pagination.Next == "" {
+		return client.Resource{}, ""
+	}
+	return client.Resource{Links: map[string]string{"next": pagination.Next}}, "next"
+}
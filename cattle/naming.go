@@ -0,0 +1,113 @@
+package cattle
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+)
+
+const (
+	GlobalNaming  = "global"
+	AccountNaming = "account"
+)
+
+// VolumeNamer maps Cattle (account, name) pairs to convoy volume names and
+// back. An accountId of 0 means the volume is not scoped to an account.
+type VolumeNamer interface {
+	ConvoyName(accountId int64, name string) string
+	CattleName(convoyName string) (accountId int64, name string)
+	// LegacyName returns the unprefixed name of a volume created before the
+	// naming scheme was enabled, if it is known to belong to the account,
+	// or "" otherwise.
+	LegacyName(accountId int64, name string) string
+}
+
+// NewVolumeNamer returns the namer for scheme. owners records the accounts
+// of volumes created before account naming was enabled, keyed by their
+// unprefixed names. Those are the only unprefixed volumes an account owns.
+func NewVolumeNamer(scheme string, owners map[string]int64) (VolumeNamer, error) {
+	switch scheme {
+	case "", GlobalNaming:
+		return globalNamer{}, nil
+	case AccountNaming:
+		return accountNamer{owners: owners}, nil
+	}
+	return nil, fmt.Errorf("unknown volume naming scheme %q", scheme)
+}
+
+// LoadVolumeOwners reads a JSON object mapping unprefixed volume names to
+// the ids of the accounts that own them. An empty path means there are none.
+func LoadVolumeOwners(path string) (map[string]int64, error) {
+	if path == "" {
+		return nil, nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read volume owners. Path: %v. Error: %v", path, err)
+	}
+	owners := map[string]int64{}
+	if err := json.Unmarshal(content, &owners); err != nil {
+		return nil, fmt.Errorf("Cannot parse volume owners. Path: %v. Error: %v", path, err)
+	}
+	for name, accountId := range owners {
+		if accountId <= 0 {
+			return nil, fmt.Errorf("Invalid account %d for volume %v in %v", accountId, name, path)
+		}
+		if accountNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("Volume %v in %v already has an account prefix", name, path)
+		}
+	}
+	return owners, nil
+}
+
+// globalNamer uses Cattle volume names as convoy names unchanged.
+type globalNamer struct{}
+
+func (globalNamer) ConvoyName(accountId int64, name string) string {
+	return name
+}
+
+func (globalNamer) CattleName(convoyName string) (int64, string) {
+	return 0, convoyName
+}
+
+func (globalNamer) LegacyName(accountId int64, name string) string {
+	return ""
+}
+
+var accountNamePattern = regexp.MustCompile(`^acct([1-9][0-9]*)--(.+)$`)
+
+// accountNamer prefixes convoy names with the owning account. Volumes created
+// before the scheme was enabled have no prefix and map back to unscoped names,
+// unless owners records their account.
+type accountNamer struct {
+	owners map[string]int64
+}
+
+func (accountNamer) ConvoyName(accountId int64, name string) string {
+	if accountId <= 0 {
+		return name
+	}
+	return fmt.Sprintf("acct%d--%s", accountId, name)
+}
+
+func (n accountNamer) CattleName(convoyName string) (int64, string) {
+	m := accountNamePattern.FindStringSubmatch(convoyName)
+	if m == nil {
+		return n.owners[convoyName], convoyName
+	}
+	accountId, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return 0, convoyName
+	}
+	return accountId, m[2]
+}
+
+func (n accountNamer) LegacyName(accountId int64, name string) string {
+	if accountId > 0 && n.owners[name] == accountId {
+		return name
+	}
+	return ""
+}
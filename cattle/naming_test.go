@@ -0,0 +1,94 @@
+package cattle
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type NamingTestSuite struct {
+}
+
+var _ = check.Suite(&NamingTestSuite{})
+
+func (s *NamingTestSuite) TestGlobalNaming(c *check.C) {
+	namer, err := NewVolumeNamer(GlobalNaming, nil)
+	c.Assert(err, check.IsNil)
+
+	c.Assert(namer.ConvoyName(5, "data"), check.Equals, "data")
+	accountId, name := namer.CattleName("acct5--data")
+	c.Assert(accountId, check.Equals, int64(0))
+	c.Assert(name, check.Equals, "acct5--data")
+}
+
+func (s *NamingTestSuite) TestAccountNamingRoundTrip(c *check.C) {
+	namer, err := NewVolumeNamer(AccountNaming, nil)
+	c.Assert(err, check.IsNil)
+
+	convoyName := namer.ConvoyName(5, "data--1")
+	c.Assert(convoyName, check.Equals, "acct5--data--1")
+	accountId, name := namer.CattleName(convoyName)
+	c.Assert(accountId, check.Equals, int64(5))
+	c.Assert(name, check.Equals, "data--1")
+
+	// Unscoped and pre-existing volumes keep their names.
+	c.Assert(namer.ConvoyName(0, "data"), check.Equals, "data")
+	for _, legacy := range []string{"data", "acct--data", "acct0--data", "acctx--data"} {
+		accountId, name = namer.CattleName(legacy)
+		c.Assert(accountId, check.Equals, int64(0))
+		c.Assert(name, check.Equals, legacy)
+	}
+}
+
+func (s *NamingTestSuite) TestLegacyNamesNeedRecordedOwner(c *check.C) {
+	namer, err := NewVolumeNamer(AccountNaming, map[string]int64{"data": 5})
+	c.Assert(err, check.IsNil)
+
+	c.Assert(namer.LegacyName(5, "data"), check.Equals, "data")
+	c.Assert(namer.LegacyName(6, "data"), check.Equals, "")
+	c.Assert(namer.LegacyName(5, "other"), check.Equals, "")
+	c.Assert(namer.LegacyName(0, "other"), check.Equals, "")
+	accountId, name := namer.CattleName("data")
+	c.Assert(accountId, check.Equals, int64(5))
+	c.Assert(name, check.Equals, "data")
+
+	global, err := NewVolumeNamer(GlobalNaming, map[string]int64{"data": 5})
+	c.Assert(err, check.IsNil)
+	c.Assert(global.LegacyName(5, "data"), check.Equals, "")
+}
+
+func (s *NamingTestSuite) TestLoadVolumeOwners(c *check.C) {
+	owners, err := LoadVolumeOwners("")
+	c.Assert(err, check.IsNil)
+	c.Assert(owners, check.IsNil)
+
+	dir := c.MkDir()
+	for content, expected := range map[string]string{
+		`{"data": 5, "logs": 6}`: "",
+		`{"data": 0}`:            "Invalid account 0 for volume data in .*",
+		`{"acct5--data": 5}`:     "Volume acct5--data in .* already has an account prefix",
+		`["data"]`:               "Cannot parse volume owners.*",
+	} {
+		path := filepath.Join(dir, "owners.json")
+		c.Assert(ioutil.WriteFile(path, []byte(content), 0644), check.IsNil)
+		owners, err := LoadVolumeOwners(path)
+		if expected == "" {
+			c.Assert(err, check.IsNil)
+			c.Assert(owners, check.DeepEquals, map[string]int64{"data": 5, "logs": 6})
+			continue
+		}
+		c.Assert(err, check.ErrorMatches, expected)
+	}
+}
+
+func (s *NamingTestSuite) TestUnknownNaming(c *check.C) {
+	_, err := NewVolumeNamer("bogus", nil)
+	c.Assert(err, check.NotNil)
+}
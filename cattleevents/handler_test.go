@@ -45,9 +45,10 @@ func (s *TestSuite) TestVolumeDelete(c *check.C) {
 		c.Fatal(err)
 	}
 
-	handler := volumeRemoveHandler{
+	remove := volumeRemoveHandler{
 		convoyClient: convoyClient,
 	}
+	handler := Chain(Decode(newVSPMData, remove.Handle), Reply)
 
 	name := "handlertest"
 	err = convoyClient.CreateVolume(name)
@@ -83,7 +84,7 @@ func (s *TestSuite) TestVolumeDelete(c *check.C) {
 		},
 	}
 
-	err = handler.Handler(event, s.mockRClient)
+	err = handler(event, s.mockRClient)
 	if err != nil {
 		c.Fatal(err)
 	}
@@ -93,7 +94,7 @@ func (s *TestSuite) TestVolumeDelete(c *check.C) {
 	c.Assert(len(pub.Data), check.Equals, 0)
 
 	// Assert that the event running a second time does not fail.
-	err = handler.Handler(event, s.mockRClient)
+	err = handler(event, s.mockRClient)
 	if err != nil {
 		c.Fatal(err)
 	}
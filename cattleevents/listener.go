@@ -1,14 +1,17 @@
 package cattleevents
 
 import (
+	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
-	"github.com/mitchellh/mapstructure"
 
 	revents "github.com/rancher/go-machine-service/events"
 	"github.com/rancher/go-rancher/client"
 
+	"github.com/rancher/convoy-agent/cattle"
 	"github.com/rancher/convoy-agent/volume"
 )
 
@@ -21,89 +24,149 @@ func ConnectToEventStream(conf Config) error {
 
 	vdh := volumeRemoveHandler{
 		convoyClient: convoy,
+		quarantine:   conf.Quarantine,
+		namer:        conf.VolumeNamer,
 	}
-	nh := noopHandler{}
-	ph := PingHandler{}
-
-	eventHandlers := map[string]revents.EventHandler{
-		"storage.volume.activate":   nh.Handler,
-		"storage.volume.deactivate": nh.Handler,
-		"storage.volume.remove":     vdh.Handler,
-		"ping":                      ph.Handler,
+	eventHandlers := map[string]timedHandler{
+		"storage.volume.activate":   untimed(Chain(Decode(newVSPMData, handleNoop), Reply)),
+		"storage.volume.deactivate": untimed(Chain(Decode(newVSPMData, handleNoop), Reply)),
+		"storage.volume.remove": func(timeout time.Duration) revents.EventHandler {
+			h := vdh
+			h.timeout = timeout
+			middlewares := []Middleware{ReplyBy(timeout), Reply}
+			if conf.Leader != nil {
+				middlewares = append([]Middleware{LeaderOnly(conf.Leader)}, middlewares...)
+			}
+			return Chain(Decode(newVSPMData, h.Handle), middlewares...)
+		},
+		"ping": untimed(Decode(newPingData, handlePing)),
+	}
+	for name, handler := range eventHandlers {
+		eventHandlers[name] = chainTimed(handler, defaultMiddlewares...)
 	}
 
-	router, err := revents.NewEventRouter("", 0, conf.CattleURL, conf.CattleAccessKey, conf.CattleSecretKey, nil, eventHandlers, "", conf.WorkerCount)
+	router, err := newEventRouter(conf, eventHandlers)
 	if err != nil {
+		stream.disconnect(err)
 		return err
 	}
-	err = router.StartWithoutCreate(nil)
+
+	ready := make(chan bool, 1)
+	done := make(chan struct{})
+	watched := make(chan struct{})
+	go func() {
+		defer close(watched)
+		select {
+		case <-ready:
+			stream.connect()
+		case <-done:
+		}
+	}()
+	err = router.run(ready)
+	close(done)
+	<-watched
+	stream.disconnect(err)
 	return err
 }
 
+// volumeRemoveHandler removes the convoy volume behind a Cattle volume. It
+// is copied for each event, with timeout set to how long Cattle waits for the
+// reply.
 type volumeRemoveHandler struct {
 	convoyClient *volume.ConvoyClient
+	quarantine   *volume.Quarantine
+	namer        cattle.VolumeNamer
+	timeout      time.Duration
 }
 
-func (h *volumeRemoveHandler) Handler(event *revents.Event, cli *client.RancherClient) error {
-	data := &VSPMData{}
-	err := mapstructure.Decode(event.Data, &data)
-	if err != nil {
-		return fmt.Errorf("Cannot parse event. Error: %v", err)
-	}
-	rancherVol := data.VSPM.V
-	vol, err := h.convoyClient.GetVolume(rancherVol.Name)
+func (h *volumeRemoveHandler) Handle(event *revents.Event, payload Payload, cli *client.RancherClient) error {
+	rancherVol := payload.(*VSPMData).VSPM.V
+
+	ctx, cancel := eventContext(h.timeout)
+	defer cancel()
+
+	convoyName, err := h.findVolume(ctx, rancherVol.AccountId, rancherVol.Name)
+	err = checkTimeout(ctx, err, "looking up volume")
 	if err != nil {
 		return fmt.Errorf("Cannot delete volume %v. Name: %v. Error: %v", rancherVol.Id, rancherVol.Name, err)
 	}
 
-	if vol == nil {
-		return volumeReply(event, cli)
+	if convoyName == "" {
+		return nil
 	}
 
-	err = h.convoyClient.DeleteVolume(rancherVol.Name)
-	if err != nil {
-		return fmt.Errorf("Cannot delete volume %v. Name: %v. Error: %v", rancherVol.Id, rancherVol.Name, err)
+	if h.quarantine != nil {
+		if _, err := h.quarantine.Add(ctx, h.convoyClient, convoyName); err != nil {
+			return fmt.Errorf("Cannot quarantine volume %v. Name: %v. Error: %v", rancherVol.Id, rancherVol.Name, err)
+		}
+		return nil
 	}
 
-	return volumeReply(event, cli)
-}
-
-func volumeReply(event *revents.Event, cli *client.RancherClient) error {
-	replyData := make(map[string]interface{})
-	reply := newReply(event)
-	reply.ResourceType = "volume"
-	reply.ResourceId = event.ResourceId
-	reply.Data = replyData
-	log.Infof("Reply: %+v", reply)
-	err := publishReply(reply, cli)
+	err = h.convoyClient.DeleteVolumeContext(ctx, convoyName)
+	err = checkTimeout(ctx, err, "deleting volume")
 	if err != nil {
-		return err
+		return fmt.Errorf("Cannot delete volume %v. Name: %v. Error: %v", rancherVol.Id, rancherVol.Name, err)
 	}
 	return nil
 }
 
-type noopHandler struct {
-}
+// findVolume returns the name of the convoy volume backing a Cattle volume,
+// or "" if there is none. Volumes created before account scoped naming was
+// enabled are found by their unprefixed name only if the namer records them
+// as the account's, so one account cannot remove another's volume.
+func (h *volumeRemoveHandler) findVolume(ctx context.Context, accountId int64, name string) (string, error) {
+	namer := h.namer
+	if namer == nil {
+		namer, _ = cattle.NewVolumeNamer(cattle.GlobalNaming, nil)
+	}
 
-func (h *noopHandler) Handler(event *revents.Event, cli *client.RancherClient) error {
-	log.Infof("Received and ignoring event: Name: %s, Event Id: %s, Resource Id: %s", event.Name, event.Id, event.ResourceId)
-	return volumeReply(event, cli)
+	candidates := []string{namer.ConvoyName(accountId, name)}
+	if legacy := namer.LegacyName(accountId, name); legacy != "" && legacy != candidates[0] {
+		candidates = append(candidates, legacy)
+	}
+	for _, candidate := range candidates {
+		vol, err := h.convoyClient.GetVolumeContext(ctx, candidate)
+		if err != nil {
+			return "", err
+		}
+		if vol != nil {
+			return candidate, nil
+		}
+	}
+	return "", nil
 }
 
-type PingHandler struct {
+// handleNoop acknowledges events that need nothing done.
+func handleNoop(event *revents.Event, payload Payload, cli *client.RancherClient) error {
+	return nil
 }
 
-func (h *PingHandler) Handler(event *revents.Event, cli *client.RancherClient) error {
+// handlePing records that Cattle's event stream is alive. Pings get no reply.
+func handlePing(event *revents.Event, payload Payload, cli *client.RancherClient) error {
+	stream.ping()
 	return nil
 }
 
+// newReply starts the reply to event, about the resource the event is for.
 func newReply(event *revents.Event) *client.Publish {
 	return &client.Publish{
-		Name:        event.ReplyTo,
-		PreviousIds: []string{event.Id},
+		Name:         event.ReplyTo,
+		PreviousIds:  []string{event.Id},
+		ResourceType: replyResourceType(event),
+		ResourceId:   event.ResourceId,
 	}
 }
 
+// replyResourceType is the type of the resource replies to event are about.
+// Storage volume events are about a volume's mapping to a pool, but Cattle
+// expects the replies to be about the volume.
+func replyResourceType(event *revents.Event) string {
+	if strings.HasPrefix(event.Name, "storage.volume.") {
+		return "volume"
+	}
+	return event.ResourceType
+}
+
 func publishReply(reply *client.Publish, apiClient *client.RancherClient) error {
 	_, err := apiClient.Publish.Create(reply)
 	return err
@@ -115,15 +178,10 @@ type Config struct {
 	CattleSecretKey string
 	WorkerCount     int
 	Socket          string
-}
-
-type VSPMData struct {
-	VSPM struct {
-		V struct {
-			Id         int64
-			Name       string
-			AccountId  int64
-			ExternalId string
-		} `mapstructure:"volume"`
-	} `mapstructure:"volumeStoragePoolMap"`
+	EventTimeout    time.Duration
+	Quarantine      *volume.Quarantine
+	VolumeNamer     cattle.VolumeNamer
+	// Leader, if set, restricts handling removes to the elected leader among
+	// replicas of the storagepool agent.
+	Leader Leadership
 }
@@ -0,0 +1,14 @@
+package cattleevents
+
+import (
+	"github.com/rancher/convoy-agent/metrics"
+)
+
+var (
+	handlerDuration = metrics.NewHistogram("convoy_agent_event_handler_duration_seconds",
+		"Time taken to handle Cattle events, by event name.", nil, "event")
+	handlerResults = metrics.NewCounter("convoy_agent_events_handled_total",
+		"Cattle events handled, by event name and result: ok, error or panic.", "event", "result")
+	droppedEvents = metrics.NewCounter("convoy_agent_events_dropped_total",
+		"Cattle events the event router dropped without handling them, by reason: no-worker or resource-locked.", "reason")
+)
@@ -0,0 +1,219 @@
+package cattleevents
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	revents "github.com/rancher/go-machine-service/events"
+	"github.com/rancher/go-rancher/client"
+)
+
+// Middleware wraps an event handler with behaviour common to all handlers.
+type Middleware func(revents.EventHandler) revents.EventHandler
+
+// Chain wraps handler with middlewares. The first middleware is the
+// outermost, so it sees the event first and the result last.
+func Chain(handler revents.EventHandler, middlewares ...Middleware) revents.EventHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// defaultMiddlewares is the chain every handler registered with the event
+// router runs through.
+var defaultMiddlewares = []Middleware{
+	ReplyOnError,
+	LogEvent,
+	RecordMetrics,
+	RecoverPanic,
+}
+
+func eventFields(event *revents.Event) log.Fields {
+	return log.Fields{
+		"eventId":    event.Id,
+		"eventName":  event.Name,
+		"resourceId": event.ResourceId,
+	}
+}
+
+// RecoverPanic turns a panic in a handler into an error, so that the event
+// gets an error reply and the router's worker survives.
+func RecoverPanic(next revents.EventHandler) revents.EventHandler {
+	return func(event *revents.Event, cli *client.RancherClient) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.WithFields(eventFields(event)).Errorf("Panic handling event: %v\n%s", r, debug.Stack())
+				err = &panicError{value: r}
+			}
+		}()
+		return next(event, cli)
+	}
+}
+
+type panicError struct {
+	value interface{}
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("Internal error handling event: %v", e.value)
+}
+
+// LogEvent logs each event with its ID, name and resource ID along with how
+// long it took to handle.
+func LogEvent(next revents.EventHandler) revents.EventHandler {
+	return func(event *revents.Event, cli *client.RancherClient) error {
+		entry := log.WithFields(eventFields(event))
+		if event.Name != "ping" {
+			entry.Debug("Handling event")
+		}
+
+		start := time.Now()
+		err := next(event, cli)
+		entry = entry.WithField("duration", time.Since(start).String())
+
+		if err != nil {
+			entry.WithField("err", err).Error("Error handling event")
+		} else if event.Name != "ping" {
+			entry.Info("Handled event")
+		}
+		return err
+	}
+}
+
+// HandlerStats are counters for the events handled under one event name.
+type HandlerStats struct {
+	Count         int64
+	Errors        int64
+	Panics        int64
+	TotalDuration time.Duration
+}
+
+type handlerMetrics struct {
+	mu    sync.Mutex
+	stats map[string]*HandlerStats
+}
+
+var counters = &handlerMetrics{stats: map[string]*HandlerStats{}}
+
+func (m *handlerMetrics) record(name string, duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.stats[name]
+	if !ok {
+		s = &HandlerStats{}
+		m.stats[name] = s
+	}
+	s.Count++
+	s.TotalDuration += duration
+	result := "ok"
+	if err != nil {
+		s.Errors++
+		result = "error"
+		if _, ok := err.(*panicError); ok {
+			s.Panics++
+			result = "panic"
+		}
+	}
+	handlerDuration.Observe(duration.Seconds(), name)
+	handlerResults.Inc(name, result)
+}
+
+// Metrics returns a snapshot of the handler counters keyed by event name.
+func Metrics() map[string]HandlerStats {
+	counters.mu.Lock()
+	defer counters.mu.Unlock()
+	snapshot := make(map[string]HandlerStats, len(counters.stats))
+	for name, s := range counters.stats {
+		snapshot[name] = *s
+	}
+	return snapshot
+}
+
+// RecordMetrics counts events, errors, panics and time spent per event name,
+// and exports them as metrics.
+func RecordMetrics(next revents.EventHandler) revents.EventHandler {
+	return func(event *revents.Event, cli *client.RancherClient) error {
+		start := time.Now()
+		err := next(event, cli)
+		counters.record(event.Name, time.Since(start), err)
+		return err
+	}
+}
+
+// Leadership tells whether this agent is the one that should act on events
+// that every replica receives.
+type Leadership interface {
+	IsLeader() bool
+}
+
+// LeaderOnly drops events unless this agent is the leader, leaving the leader
+// to handle and reply to them. Every replica receives the event, so a reply
+// from a standby would race the leader's.
+func LeaderOnly(leadership Leadership) Middleware {
+	return func(next revents.EventHandler) revents.EventHandler {
+		return func(event *revents.Event, cli *client.RancherClient) error {
+			if !leadership.IsLeader() {
+				log.WithFields(eventFields(event)).Debug("Ignoring event, not the leader")
+				return nil
+			}
+			return next(event, cli)
+		}
+	}
+}
+
+// PayloadHandler handles an event given its data decoded into a payload. It
+// returns nil once the event is handled, leaving replies to the middlewares.
+type PayloadHandler func(event *revents.Event, payload Payload, cli *client.RancherClient) error
+
+// Decode adapts handler into an event handler, decoding and validating each
+// event's data into a payload from newPayload first. Events whose data is not
+// valid fail with a PayloadError without reaching the handler.
+func Decode(newPayload func() Payload, handler PayloadHandler) revents.EventHandler {
+	return func(event *revents.Event, cli *client.RancherClient) error {
+		payload := newPayload()
+		if err := decodePayload(event, payload); err != nil {
+			return err
+		}
+		return handler(event, payload, cli)
+	}
+}
+
+// Reply replies to each event that was handled without error. Failed events
+// are left to ReplyOnError.
+func Reply(next revents.EventHandler) revents.EventHandler {
+	return func(event *revents.Event, cli *client.RancherClient) error {
+		if err := next(event, cli); err != nil {
+			return err
+		}
+		reply := newReply(event)
+		reply.Data = map[string]interface{}{}
+		log.Debugf("Reply: %+v", reply)
+		return publishReply(reply, cli)
+	}
+}
+
+// ReplyOnError replies to a failed event with the error as its transitioning
+// message. If the reply cannot be published the error is returned so that
+// the router can try again.
+func ReplyOnError(next revents.EventHandler) revents.EventHandler {
+	return func(event *revents.Event, cli *client.RancherClient) error {
+		err := next(event, cli)
+		if err == nil || event.ReplyTo == "" {
+			return err
+		}
+
+		reply := newReply(event)
+		reply.Transitioning = "error"
+		reply.TransitioningMessage = err.Error()
+		if perr := publishReply(reply, cli); perr != nil {
+			log.WithFields(eventFields(event)).Errorf("Error sending error reply: %v", perr)
+			return err
+		}
+		return nil
+	}
+}
@@ -0,0 +1,158 @@
+package cattleevents
+
+import (
+	"errors"
+
+	"gopkg.in/check.v1"
+
+	revents "github.com/rancher/go-machine-service/events"
+	"github.com/rancher/go-rancher/client"
+)
+
+type MiddlewareTestSuite struct {
+	publishChan chan client.Publish
+	mockRClient *client.RancherClient
+}
+
+var _ = check.Suite(&MiddlewareTestSuite{})
+
+func (s *MiddlewareTestSuite) SetUpTest(c *check.C) {
+	s.publishChan = make(chan client.Publish, 10)
+	s.mockRClient = &client.RancherClient{
+		Publish: &MockPublishOperations{publishChan: s.publishChan},
+	}
+}
+
+func (s *MiddlewareTestSuite) TestChainOrder(c *check.C) {
+	calls := []string{}
+	mw := func(name string) Middleware {
+		return func(next revents.EventHandler) revents.EventHandler {
+			return func(event *revents.Event, cli *client.RancherClient) error {
+				calls = append(calls, name)
+				return next(event, cli)
+			}
+		}
+	}
+	handler := Chain(func(event *revents.Event, cli *client.RancherClient) error {
+		calls = append(calls, "handler")
+		return nil
+	}, mw("outer"), mw("inner"))
+
+	c.Assert(handler(&revents.Event{}, s.mockRClient), check.IsNil)
+	c.Assert(calls, check.DeepEquals, []string{"outer", "inner", "handler"})
+}
+
+func (s *MiddlewareTestSuite) TestPanicBecomesErrorReply(c *check.C) {
+	handler := Chain(func(event *revents.Event, cli *client.RancherClient) error {
+		var data *VSPMData
+		_ = data.VSPM.V.Name
+		return nil
+	}, defaultMiddlewares...)
+
+	event := &revents.Event{Name: "test.panic", ReplyTo: "reply-1", Id: "event-1"}
+	c.Assert(handler(event, s.mockRClient), check.IsNil)
+
+	pub := <-s.publishChan
+	c.Assert(pub.Name, check.Equals, "reply-1")
+	c.Assert(pub.PreviousIds, check.DeepEquals, []string{"event-1"})
+	c.Assert(pub.Transitioning, check.Equals, "error")
+	c.Assert(pub.TransitioningMessage, check.Matches, "Internal error handling event: .*nil pointer.*")
+
+	stats := Metrics()["test.panic"]
+	c.Assert(stats.Count, check.Equals, int64(1))
+	c.Assert(stats.Errors, check.Equals, int64(1))
+	c.Assert(stats.Panics, check.Equals, int64(1))
+	c.Assert(handlerResults.Value("test.panic", "panic"), check.Equals, float64(1))
+	c.Assert(handlerDuration.Count("test.panic"), check.Equals, uint64(1))
+}
+
+func (s *MiddlewareTestSuite) TestErrorReply(c *check.C) {
+	handler := Chain(func(event *revents.Event, cli *client.RancherClient) error {
+		return errors.New("volume is busy")
+	}, defaultMiddlewares...)
+
+	event := &revents.Event{Name: "test.error", ReplyTo: "reply-2", Id: "event-2"}
+	c.Assert(handler(event, s.mockRClient), check.IsNil)
+
+	pub := <-s.publishChan
+	c.Assert(pub.Transitioning, check.Equals, "error")
+	c.Assert(pub.TransitioningMessage, check.Equals, "volume is busy")
+
+	stats := Metrics()["test.error"]
+	c.Assert(stats.Errors, check.Equals, int64(1))
+	c.Assert(stats.Panics, check.Equals, int64(0))
+}
+
+func (s *MiddlewareTestSuite) TestNoReplyOnSuccess(c *check.C) {
+	handler := Chain(func(event *revents.Event, cli *client.RancherClient) error {
+		return nil
+	}, defaultMiddlewares...)
+
+	event := &revents.Event{Name: "test.ok", ReplyTo: "reply-3", Id: "event-3"}
+	c.Assert(handler(event, s.mockRClient), check.IsNil)
+	c.Assert(len(s.publishChan), check.Equals, 0)
+	c.Assert(Metrics()["test.ok"].Count, check.Equals, int64(1))
+	c.Assert(handlerResults.Value("test.ok", "ok"), check.Equals, float64(1))
+}
+
+type fakeLeadership bool
+
+func (f fakeLeadership) IsLeader() bool {
+	return bool(f)
+}
+
+func (s *MiddlewareTestSuite) TestLeaderOnly(c *check.C) {
+	handled := 0
+	handler := func(event *revents.Event, cli *client.RancherClient) error {
+		handled++
+		return nil
+	}
+	event := &revents.Event{Name: "storage.volume.remove", ReplyTo: "reply-4", Id: "event-4"}
+
+	c.Assert(Chain(handler, ReplyOnError, LeaderOnly(fakeLeadership(false)))(event, s.mockRClient), check.IsNil)
+	c.Assert(handled, check.Equals, 0)
+	c.Assert(len(s.publishChan), check.Equals, 0)
+
+	c.Assert(Chain(handler, LeaderOnly(fakeLeadership(true)))(event, s.mockRClient), check.IsNil)
+	c.Assert(handled, check.Equals, 1)
+}
+
+func (s *MiddlewareTestSuite) TestDecodeAndReply(c *check.C) {
+	var names []string
+	handler := Chain(Decode(newVSPMData, func(event *revents.Event, payload Payload, cli *client.RancherClient) error {
+		names = append(names, payload.(*VSPMData).VSPM.V.Name)
+		return nil
+	}), append(append([]Middleware{}, defaultMiddlewares...), Reply)...)
+
+	event := &revents.Event{
+		Name:         "storage.volume.activate",
+		ReplyTo:      "reply-5",
+		Id:           "event-5",
+		ResourceId:   "1vspm5",
+		ResourceType: "volumeStoragePoolMap",
+		Data: map[string]interface{}{
+			"volumeStoragePoolMap": map[string]interface{}{
+				"volume": map[string]interface{}{"id": 5, "name": "data"},
+			},
+		},
+	}
+	c.Assert(handler(event, s.mockRClient), check.IsNil)
+	c.Assert(names, check.DeepEquals, []string{"data"})
+	pub := <-s.publishChan
+	c.Assert(pub.Name, check.Equals, "reply-5")
+	c.Assert(pub.ResourceType, check.Equals, "volume")
+	c.Assert(pub.ResourceId, check.Equals, "1vspm5")
+	c.Assert(pub.Transitioning, check.Equals, "")
+
+	// Invalid data gets an error reply about the same resource, without
+	// reaching the handler.
+	event.Data = map[string]interface{}{"volumeStoragePoolMap": map[string]interface{}{}}
+	c.Assert(handler(event, s.mockRClient), check.IsNil)
+	c.Assert(names, check.HasLen, 1)
+	pub = <-s.publishChan
+	c.Assert(pub.Transitioning, check.Equals, "error")
+	c.Assert(pub.TransitioningMessage, check.Matches, "invalid storage.volume.activate payload: .*")
+	c.Assert(pub.ResourceType, check.Equals, "volume")
+	c.Assert(pub.ResourceId, check.Equals, "1vspm5")
+	c.Assert(len(s.publishChan), check.Equals, 0)
+}
@@ -0,0 +1,134 @@
+package cattleevents
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+
+	revents "github.com/rancher/go-machine-service/events"
+)
+
+// PayloadError describes why an event's data could not be used. Its message is
+// meant to be returned to Cattle in the event reply.
+type PayloadError struct {
+	EventName string
+	Field     string
+	Reason    string
+}
+
+func (e *PayloadError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("invalid %s payload: %s", e.EventName, e.Reason)
+	}
+	return fmt.Sprintf("invalid %s payload: %s %s", e.EventName, e.Field, e.Reason)
+}
+
+// Payload is implemented by the typed models of event data.
+type Payload interface {
+	Validate() error
+}
+
+type Volume struct {
+	Id         int64
+	Name       string
+	AccountId  int64
+	ExternalId string
+}
+
+type StoragePool struct {
+	Id         int64
+	Name       string
+	Kind       string
+	ExternalId string
+}
+
+// VSPMData is the data of the storage.volume.* events, which all describe a
+// volume's mapping to a storage pool.
+type VSPMData struct {
+	VSPM struct {
+		V  Volume      `mapstructure:"volume"`
+		SP StoragePool `mapstructure:"storagePool"`
+	} `mapstructure:"volumeStoragePoolMap"`
+}
+
+func newVSPMData() Payload {
+	return &VSPMData{}
+}
+
+func (d *VSPMData) Validate() error {
+	v := d.VSPM.V
+	if v.Id <= 0 {
+		return &PayloadError{Field: "volumeStoragePoolMap.volume.id", Reason: "is required"}
+	}
+	if v.Name == "" {
+		return &PayloadError{Field: "volumeStoragePoolMap.volume.name", Reason: "is required"}
+	}
+	if strings.ContainsAny(v.Name, "/\x00") {
+		return &PayloadError{Field: "volumeStoragePoolMap.volume.name", Reason: fmt.Sprintf("%q is not a valid volume name", v.Name)}
+	}
+	if v.AccountId < 0 {
+		return &PayloadError{Field: "volumeStoragePoolMap.volume.accountId", Reason: "must not be negative"}
+	}
+	return nil
+}
+
+// PingData is the data of a ping event, which carries nothing this agent uses.
+type PingData struct {
+}
+
+func newPingData() Payload {
+	return &PingData{}
+}
+
+func (d *PingData) Validate() error {
+	return nil
+}
+
+// decodePayload strictly decodes the event's data into payload and validates
+// it. Any returned error is a *PayloadError naming the event.
+func decodePayload(event *revents.Event, payload Payload) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: rejectFractionalInts,
+		Result:     payload,
+	})
+	if err != nil {
+		return &PayloadError{EventName: event.Name, Reason: err.Error()}
+	}
+
+	if err := decoder.Decode(event.Data); err != nil {
+		return &PayloadError{EventName: event.Name, Reason: err.Error()}
+	}
+
+	if err := payload.Validate(); err != nil {
+		if pe, ok := err.(*PayloadError); ok {
+			pe.EventName = event.Name
+			return pe
+		}
+		return &PayloadError{EventName: event.Name, Reason: err.Error()}
+	}
+	return nil
+}
+
+// rejectFractionalInts stops mapstructure from silently truncating JSON
+// numbers such as 1.5 when decoding them into integer fields.
+func rejectFractionalInts(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if to.Kind() < reflect.Int || to.Kind() > reflect.Uint64 {
+		return data, nil
+	}
+	var f float64
+	switch v := data.(type) {
+	case float64:
+		f = v
+	case float32:
+		f = float64(v)
+	default:
+		return data, nil
+	}
+	if f != math.Trunc(f) || math.IsInf(f, 0) || math.IsNaN(f) {
+		return nil, fmt.Errorf("%v is not an integer", data)
+	}
+	return data, nil
+}
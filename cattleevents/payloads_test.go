@@ -0,0 +1,116 @@
+package cattleevents
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+
+	"gopkg.in/check.v1"
+
+	revents "github.com/rancher/go-machine-service/events"
+)
+
+const malformedPayloadDir = "testdata/payloads"
+
+type PayloadTestSuite struct {
+}
+
+var _ = check.Suite(&PayloadTestSuite{})
+
+func validVSPMData() map[string]interface{} {
+	return map[string]interface{}{
+		"volumeStoragePoolMap": map[string]interface{}{
+			"volume": map[string]interface{}{
+				"id":         float64(12),
+				"name":       "vol1",
+				"accountId":  float64(5),
+				"externalId": "vol1",
+			},
+			"storagePool": map[string]interface{}{
+				"id":   float64(3),
+				"name": "pool",
+			},
+		},
+	}
+}
+
+func (s *PayloadTestSuite) TestDecodeValid(c *check.C) {
+	event := &revents.Event{Name: "storage.volume.remove", Data: validVSPMData()}
+	data := &VSPMData{}
+	c.Assert(decodePayload(event, data), check.IsNil)
+	c.Assert(data.VSPM.V.Id, check.Equals, int64(12))
+	c.Assert(data.VSPM.V.Name, check.Equals, "vol1")
+	c.Assert(data.VSPM.V.AccountId, check.Equals, int64(5))
+	c.Assert(data.VSPM.SP.Name, check.Equals, "pool")
+}
+
+func (s *PayloadTestSuite) TestErrorNamesEventAndField(c *check.C) {
+	event := &revents.Event{
+		Name: "storage.volume.remove",
+		Data: map[string]interface{}{
+			"volumeStoragePoolMap": map[string]interface{}{
+				"volume": map[string]interface{}{"id": 1},
+			},
+		},
+	}
+	err := decodePayload(event, &VSPMData{})
+	c.Assert(err, check.ErrorMatches, "invalid storage.volume.remove payload: volumeStoragePoolMap.volume.name is required")
+}
+
+func (s *PayloadTestSuite) TestMalformedCorpus(c *check.C) {
+	files, err := filepath.Glob(filepath.Join(malformedPayloadDir, "*.json"))
+	c.Assert(err, check.IsNil)
+	c.Assert(len(files) > 0, check.Equals, true)
+
+	for _, f := range files {
+		content, err := ioutil.ReadFile(f)
+		c.Assert(err, check.IsNil)
+		data := map[string]interface{}{}
+		c.Assert(json.Unmarshal(content, &data), check.IsNil, check.Commentf(f))
+
+		event := &revents.Event{Name: "storage.volume.remove", Data: data}
+		err = decodePayload(event, &VSPMData{})
+		c.Assert(err, check.NotNil, check.Commentf(f))
+		_, ok := err.(*PayloadError)
+		c.Assert(ok, check.Equals, true, check.Commentf("%s: %v", f, err))
+	}
+}
+
+// TestRandomMutations replaces values anywhere in a valid payload with values
+// of other types and checks that decoding never panics and only ever fails
+// with a PayloadError.
+func (s *PayloadTestSuite) TestRandomMutations(c *check.C) {
+	r := rand.New(rand.NewSource(1))
+	replacements := []interface{}{nil, "", "x/y", float64(-1), float64(0), 1.5, true, []interface{}{1}, map[string]interface{}{}}
+
+	for i := 0; i < 2000; i++ {
+		data := validVSPMData()
+		mutate(r, data, replacements)
+
+		event := &revents.Event{Name: "storage.volume.remove", Data: data}
+		err := decodePayload(event, &VSPMData{})
+		if err == nil {
+			continue
+		}
+		_, ok := err.(*PayloadError)
+		c.Assert(ok, check.Equals, true, check.Commentf("%#v: %v", data, err))
+	}
+}
+
+func mutate(r *rand.Rand, m map[string]interface{}, replacements []interface{}) {
+	for k, v := range m {
+		if r.Intn(4) != 0 {
+			if nested, ok := v.(map[string]interface{}); ok {
+				mutate(r, nested, replacements)
+			}
+			continue
+		}
+		switch r.Intn(3) {
+		case 0:
+			delete(m, k)
+		default:
+			m[k] = replacements[r.Intn(len(replacements))]
+		}
+	}
+}
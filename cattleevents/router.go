@@ -0,0 +1,175 @@
+package cattleevents
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/websocket"
+
+	revents "github.com/rancher/go-machine-service/events"
+	"github.com/rancher/go-machine-service/locks"
+	"github.com/rancher/go-rancher/client"
+)
+
+// eventRouter subscribes to Cattle's events and hands each one to a worker,
+// like the go-machine-service router. That router decodes events into a
+// type that leaves out timeoutMillis and drops events without saying, so
+// this one builds each event's handler for its timeout and counts drops.
+type eventRouter struct {
+	subscribeUrl string
+	accessKey    string
+	secretKey    string
+	apiClient    *client.RancherClient
+	handlers     map[string]timedHandler
+	timeout      time.Duration
+	workerCount  int
+}
+
+// eventTimeoutFields are the fields of a raw event that revents.Event lacks.
+type eventTimeoutFields struct {
+	TimeoutMillis int64 `json:"timeoutMillis"`
+}
+
+func newEventRouter(conf Config, handlers map[string]timedHandler) (*eventRouter, error) {
+	apiClient, err := client.NewRancherClient(&client.ClientOpts{
+		Url:       conf.CattleURL,
+		AccessKey: conf.CattleAccessKey,
+		SecretKey: conf.CattleSecretKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &eventRouter{
+		subscribeUrl: strings.Replace(conf.CattleURL+"/subscribe", "http", "ws", -1),
+		accessKey:    conf.CattleAccessKey,
+		secretKey:    conf.CattleSecretKey,
+		apiClient:    apiClient,
+		handlers:     handlers,
+		timeout:      conf.EventTimeout,
+		workerCount:  conf.WorkerCount,
+	}, nil
+}
+
+// run subscribes to the handled events and handles them until the
+// connection closes. It sends on ready once subscribed.
+func (r *eventRouter) run(ready chan<- bool) error {
+	params := url.Values{}
+	for name := range r.handlers {
+		params.Add("eventNames", name)
+	}
+	conn, err := r.subscribe(params)
+	if err != nil {
+		return err
+	}
+	log.Info("Connection established")
+	defer func() {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		conn.Close()
+	}()
+	if ready != nil {
+		ready <- true
+	}
+
+	workers := make(chan struct{}, r.workerCount)
+	for i := 0; i < r.workerCount; i++ {
+		workers <- struct{}{}
+	}
+	log.WithField("workerCount", r.workerCount).Info("Initializing event router")
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			// The connection is closed, which is how the stream ends.
+			return nil
+		}
+		message = bytes.TrimSpace(message)
+		if len(message) == 0 {
+			continue
+		}
+
+		select {
+		case <-workers:
+			go func() {
+				defer func() { workers <- struct{}{} }()
+				r.handle(message)
+			}()
+		default:
+			droppedEvents.Inc("no-worker")
+			log.WithField("workerCount", r.workerCount).Info("No workers available dropping event.")
+		}
+	}
+}
+
+func (r *eventRouter) subscribe(params url.Values) (*websocket.Conn, error) {
+	headers := http.Header{}
+	headers.Add("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(r.accessKey+":"+r.secretKey)))
+	subscribeUrl := r.subscribeUrl + "?" + params.Encode()
+	conn, resp, err := (&websocket.Dialer{}).Dial(subscribeUrl, headers)
+	if err != nil {
+		fields := log.Fields{"error": err, "subscribeUrl": subscribeUrl}
+		if resp != nil {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			fields["statusCode"] = resp.StatusCode
+			fields["responseBody"] = string(body)
+		}
+		log.WithFields(fields).Error("Failed to subscribe to events.")
+		return nil, err
+	}
+	return conn, nil
+}
+
+// handle runs the handler for a raw event, one event per resource at a time,
+// and replies with the error if the handler fails without replying itself.
+// The handler is given the event's timeout, or the router's if the event
+// does not say, and each event gets only one reply.
+func (r *eventRouter) handle(raw []byte) {
+	event := &revents.Event{}
+	if err := json.Unmarshal(raw, event); err != nil {
+		log.WithField("err", err).Error("Error unmarshalling event")
+		return
+	}
+	fields := eventTimeoutFields{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		log.WithFields(eventFields(event)).Warnf("Cannot read event timeout: %v", err)
+	}
+	if event.Name != "ping" {
+		log.WithField("event", string(raw)).Debug("Processing event.")
+	}
+
+	newHandler, ok := r.handlers[event.Name]
+	if !ok {
+		log.WithField("eventName", event.Name).Warn("No event handler registered for event")
+		return
+	}
+
+	unlocker := locks.Lock(event.ResourceId)
+	if unlocker == nil {
+		droppedEvents.Inc("resource-locked")
+		log.WithField("resourceId", event.ResourceId).Debug("Resource locked. Dropping event")
+		return
+	}
+	defer unlocker.Unlock()
+
+	timeout := r.timeout
+	if fields.TimeoutMillis > 0 {
+		timeout = time.Duration(fields.TimeoutMillis) * time.Millisecond
+	}
+
+	cli := onceReplies(r.apiClient)
+	if err := newHandler(timeout)(event, cli); err != nil {
+		reply := newReply(event)
+		reply.Transitioning = "error"
+		reply.TransitioningMessage = err.Error()
+		if err := publishReply(reply, cli); err != nil {
+			log.WithFields(eventFields(event)).Errorf("Error sending error reply: %v", err)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package cattleevents
+
+import (
+	revents "github.com/rancher/go-machine-service/events"
+	"github.com/rancher/go-machine-service/locks"
+	"github.com/rancher/go-rancher/client"
+	"gopkg.in/check.v1"
+)
+
+type RouterTestSuite struct {
+}
+
+var _ = check.Suite(&RouterTestSuite{})
+
+func (s *RouterTestSuite) TestCountsEventsDroppedForLockedResource(c *check.C) {
+	handled := 0
+	router := &eventRouter{
+		apiClient: &client.RancherClient{Publish: &MockPublishOperations{publishChan: make(chan client.Publish, 10)}},
+		handlers: map[string]timedHandler{
+			"storage.volume.remove": untimed(func(event *revents.Event, cli *client.RancherClient) error {
+				handled++
+				return nil
+			}),
+		},
+	}
+	dropped := droppedEvents.Value("resource-locked")
+
+	unlocker := locks.Lock("volume-7")
+	c.Assert(unlocker, check.NotNil)
+	router.handle([]byte(`{"id":"event-1","name":"storage.volume.remove","resourceId":"volume-7"}`))
+	c.Assert(handled, check.Equals, 0)
+	c.Assert(droppedEvents.Value("resource-locked"), check.Equals, dropped+1)
+
+	unlocker.Unlock()
+	router.handle([]byte(`{"id":"event-2","name":"storage.volume.remove","resourceId":"volume-7"}`))
+	c.Assert(handled, check.Equals, 1)
+	c.Assert(droppedEvents.Value("resource-locked"), check.Equals, dropped+1)
+}
+
+func (s *RouterTestSuite) TestOneReplyPerEvent(c *check.C) {
+	publishChan := make(chan client.Publish, 10)
+	router := &eventRouter{
+		apiClient: &client.RancherClient{Publish: &MockPublishOperations{publishChan: publishChan}},
+		handlers: map[string]timedHandler{
+			"storage.volume.remove": untimed(Chain(func(event *revents.Event, cli *client.RancherClient) error {
+				reply := newReply(event)
+				reply.Transitioning = "error"
+				reply.TransitioningMessage = "first"
+				c.Assert(publishReply(reply, cli), check.IsNil)
+				return nil
+			}, ReplyOnError, Reply)),
+		},
+	}
+
+	router.handle([]byte(`{"id":"event-1","name":"storage.volume.remove","replyTo":"reply-1","resourceId":"volume-8"}`))
+	router.handle([]byte(`{"id":"event-2","name":"storage.volume.remove","replyTo":"reply-2","resourceId":"volume-8"}`))
+	c.Assert(len(publishChan), check.Equals, 2)
+	c.Assert((<-publishChan).TransitioningMessage, check.Equals, "first")
+	c.Assert((<-publishChan).PreviousIds, check.DeepEquals, []string{"event-2"})
+}
@@ -0,0 +1,73 @@
+package cattleevents
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rancher/convoy-agent/health"
+)
+
+// streamState is the state of the connection to Cattle's event stream.
+type streamState struct {
+	mu             sync.Mutex
+	connected      bool
+	connectedAt    time.Time
+	disconnectedAt time.Time
+	lastPing       time.Time
+	lastErr        error
+}
+
+var stream = &streamState{}
+
+func (s *streamState) connect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = true
+	s.connectedAt = time.Now()
+	s.lastErr = nil
+}
+
+func (s *streamState) disconnect(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = false
+	s.disconnectedAt = time.Now()
+	if err == nil {
+		err = errors.New("event stream closed")
+	}
+	s.lastErr = err
+}
+
+func (s *streamState) ping() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastPing = time.Now()
+}
+
+// StreamHealth reports whether the agent is connected to Cattle's event
+// stream. The stream is healthy but not ready while first connecting.
+func StreamHealth() health.Status {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	status := health.Status{Details: map[string]interface{}{}}
+	if !stream.lastPing.IsZero() {
+		status.Details["lastPing"] = stream.lastPing.UTC().Format(time.RFC3339)
+	}
+	switch {
+	case stream.connected:
+		status.Healthy, status.Ready = true, true
+		status.Message = "connected"
+		status.Details["connectedSince"] = stream.connectedAt.UTC().Format(time.RFC3339)
+	case stream.lastErr != nil:
+		status.Message = stream.lastErr.Error()
+		if !stream.disconnectedAt.IsZero() {
+			status.Details["disconnectedAt"] = stream.disconnectedAt.UTC().Format(time.RFC3339)
+		}
+	default:
+		status.Healthy = true
+		status.Message = "connecting"
+	}
+	return status
+}
@@ -0,0 +1,36 @@
+package cattleevents
+
+import (
+	"errors"
+
+	"gopkg.in/check.v1"
+)
+
+type StreamTestSuite struct {
+}
+
+var _ = check.Suite(&StreamTestSuite{})
+
+func (s *StreamTestSuite) SetUpTest(c *check.C) {
+	stream = &streamState{}
+}
+
+func (s *StreamTestSuite) TestStreamHealth(c *check.C) {
+	status := StreamHealth()
+	c.Assert(status.Healthy, check.Equals, true)
+	c.Assert(status.Ready, check.Equals, false)
+
+	stream.connect()
+	stream.ping()
+	status = StreamHealth()
+	c.Assert(status.Ready, check.Equals, true)
+	c.Assert(status.Details["lastPing"], check.NotNil)
+
+	stream.disconnect(errors.New("websocket: close 1006"))
+	status = StreamHealth()
+	c.Assert(status.Healthy, check.Equals, false)
+	c.Assert(status.Message, check.Equals, "websocket: close 1006")
+
+	stream.connect()
+	c.Assert(StreamHealth().Healthy, check.Equals, true)
+}
@@ -0,0 +1,134 @@
+package cattleevents
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	revents "github.com/rancher/go-machine-service/events"
+	"github.com/rancher/go-rancher/client"
+)
+
+// DefaultEventTimeout is how long Cattle waits for a reply to a storage event
+// that does not say, when Config.EventTimeout is not set either.
+const DefaultEventTimeout = 15 * time.Second
+
+// replyMargin is reserved at the end of the event timeout for publishing the
+// reply, so that a timeout error reaches Cattle before it gives up.
+const replyMargin = 2 * time.Second
+
+// timedHandler builds the handler for one event, given how long Cattle waits
+// for the reply to it, so that each handler runs to its own event's deadline.
+type timedHandler func(timeout time.Duration) revents.EventHandler
+
+// untimed adapts a handler that does not need its event's timeout.
+func untimed(handler revents.EventHandler) timedHandler {
+	return func(time.Duration) revents.EventHandler {
+		return handler
+	}
+}
+
+// chainTimed wraps the handlers built by handler with middlewares.
+func chainTimed(handler timedHandler, middlewares ...Middleware) timedHandler {
+	return func(timeout time.Duration) revents.EventHandler {
+		return Chain(handler(timeout), middlewares...)
+	}
+}
+
+// handlerBudget is how long a handler may run for an event with the given
+// timeout, leaving time to reply before the event expires.
+func handlerBudget(timeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		timeout = DefaultEventTimeout
+	}
+	budget := timeout - replyMargin
+	if budget < timeout/2 {
+		budget = timeout / 2
+	}
+	return budget
+}
+
+// eventContext returns a context whose deadline leaves time to reply before
+// an event with the given timeout expires.
+func eventContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), handlerBudget(timeout))
+}
+
+// checkTimeout turns an error caused by ctx expiring into one that says so,
+// since the transport error alone does not make the cause clear to Cattle.
+func checkTimeout(ctx context.Context, err error, action string) error {
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("Timed out %s: %v", action, err)
+	}
+	return err
+}
+
+// ReplyBy replies to an event with a timeout error if its handler is still
+// running halfway through the time left for replying, in case the handler
+// overruns its context. It still waits for the handler to return, so that
+// the resource stays locked, and the reply sent once the handler returns is
+// dropped by the router.
+func ReplyBy(timeout time.Duration) Middleware {
+	if timeout <= 0 {
+		timeout = DefaultEventTimeout
+	}
+	budget := handlerBudget(timeout)
+	return func(next revents.EventHandler) revents.EventHandler {
+		return func(event *revents.Event, cli *client.RancherClient) error {
+			done := make(chan error, 1)
+			go func() {
+				done <- next(event, cli)
+			}()
+
+			timer := time.NewTimer(budget + (timeout-budget)/2)
+			defer timer.Stop()
+			select {
+			case err := <-done:
+				return err
+			case <-timer.C:
+			}
+
+			reply := newReply(event)
+			reply.Transitioning = "error"
+			reply.TransitioningMessage = fmt.Sprintf("Timed out handling event after %v", budget)
+			if err := publishReply(reply, cli); err != nil {
+				log.WithFields(eventFields(event)).Errorf("Error sending timeout reply: %v", err)
+			}
+			return <-done
+		}
+	}
+}
+
+// replyOnce publishes only the first reply to an event, so that a reply sent
+// after a timeout error cannot contradict it. Replies that fail to publish do
+// not count, so that they can be sent again.
+type replyOnce struct {
+	client.PublishOperations
+	mu      sync.Mutex
+	replied bool
+}
+
+// onceReplies returns a copy of cli for handling a single event, which
+// publishes only the first reply to it.
+func onceReplies(cli *client.RancherClient) *client.RancherClient {
+	once := *cli
+	once.Publish = &replyOnce{PublishOperations: cli.Publish}
+	return &once
+}
+
+func (p *replyOnce) Create(reply *client.Publish) (*client.Publish, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.replied {
+		log.WithField("previousIds", reply.PreviousIds).Debugf("Dropping reply, the event has been replied to: %+v", reply)
+		return reply, nil
+	}
+	published, err := p.PublishOperations.Create(reply)
+	if err == nil {
+		p.replied = true
+	}
+	return published, err
+}
@@ -0,0 +1,91 @@
+package cattleevents
+
+import (
+	"errors"
+	"time"
+
+	revents "github.com/rancher/go-machine-service/events"
+	"github.com/rancher/go-rancher/client"
+	"gopkg.in/check.v1"
+)
+
+type TimeoutTestSuite struct {
+}
+
+var _ = check.Suite(&TimeoutTestSuite{})
+
+func (s *TimeoutTestSuite) TestEventContextLeavesTimeToReply(c *check.C) {
+	ctx, cancel := eventContext(10 * time.Second)
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	c.Assert(ok, check.Equals, true)
+	remaining := deadline.Sub(time.Now())
+	c.Assert(remaining <= 10*time.Second-replyMargin, check.Equals, true)
+	c.Assert(remaining > 7*time.Second, check.Equals, true)
+
+	// Short timeouts still leave the handler half of the time.
+	ctx, cancel = eventContext(time.Second)
+	defer cancel()
+	deadline, _ = ctx.Deadline()
+	c.Assert(deadline.Sub(time.Now()) > 400*time.Millisecond, check.Equals, true)
+}
+
+func (s *TimeoutTestSuite) TestCheckTimeout(c *check.C) {
+	err := errors.New("connection reset")
+
+	ctx, cancel := eventContext(time.Minute)
+	c.Assert(checkTimeout(ctx, err, "deleting volume"), check.Equals, err)
+	cancel()
+	c.Assert(checkTimeout(ctx, nil, "deleting volume"), check.IsNil)
+
+	ctx, cancel = eventContext(time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+	c.Assert(checkTimeout(ctx, err, "deleting volume"), check.ErrorMatches, "Timed out deleting volume: connection reset")
+}
+
+func (s *TimeoutTestSuite) TestEventTimeoutFromRawEvent(c *check.C) {
+	var seen []time.Duration
+	router := &eventRouter{
+		apiClient: &client.RancherClient{Publish: &MockPublishOperations{publishChan: make(chan client.Publish, 10)}},
+		timeout:   time.Minute,
+		handlers: map[string]timedHandler{
+			"storage.volume.remove": func(timeout time.Duration) revents.EventHandler {
+				return func(event *revents.Event, cli *client.RancherClient) error {
+					seen = append(seen, timeout)
+					return nil
+				}
+			},
+		},
+	}
+
+	router.handle([]byte(`{"id":"event-1","name":"storage.volume.remove","resourceId":"1","timeoutMillis":30000}`))
+	router.handle([]byte(`{"id":"event-1","name":"storage.volume.remove","resourceId":"1"}`))
+	c.Assert(seen, check.DeepEquals, []time.Duration{30 * time.Second, time.Minute})
+}
+
+func (s *TimeoutTestSuite) TestReplyBy(c *check.C) {
+	publishChan := make(chan client.Publish, 10)
+	router := &eventRouter{
+		apiClient: &client.RancherClient{Publish: &MockPublishOperations{publishChan: publishChan}},
+		handlers: map[string]timedHandler{
+			"storage.volume.remove": chainTimed(func(timeout time.Duration) revents.EventHandler {
+				return Chain(func(event *revents.Event, cli *client.RancherClient) error {
+					time.Sleep(timeout)
+					return nil
+				}, ReplyBy(timeout), Reply)
+			}, ReplyOnError),
+		},
+	}
+
+	start := time.Now()
+	router.handle([]byte(`{"id":"event-1","name":"storage.volume.remove","replyTo":"reply-1","resourceId":"1","timeoutMillis":200}`))
+	c.Assert(time.Since(start) >= 200*time.Millisecond, check.Equals, true)
+
+	// The timeout error is the only reply, the handler's late success is
+	// dropped.
+	c.Assert(len(publishChan), check.Equals, 1)
+	reply := <-publishChan
+	c.Assert(reply.Transitioning, check.Equals, "error")
+	c.Assert(reply.TransitioningMessage, check.Equals, "Timed out handling event after 100ms")
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+)
+
+const commandName = "config"
+
+// Commands work with config files. They read their file themselves, so Wrap
+// leaves them alone.
+var Commands = []cli.Command{
+	{
+		Name:  commandName,
+		Usage: "Work with convoy-agent config files",
+		Subcommands: []cli.Command{
+			{
+				Name:   "validate",
+				Usage:  "Check a config file for unknown options and invalid values: validate [FILE]. Defaults to the file given with --config",
+				Action: validate,
+			},
+		},
+	},
+}
+
+func validate(c *cli.Context) {
+	path := c.Args().First()
+	if path == "" {
+		path = c.GlobalString("config")
+	}
+	if path == "" {
+		log.Fatal("config file is required")
+	}
+
+	f, err := Load(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mu.Lock()
+	schema := appSchema
+	mu.Unlock()
+	if err := schema.Validate(f); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s is valid\n", path)
+}
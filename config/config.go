@@ -0,0 +1,415 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+)
+
+// GlobalSection is the section of a config file holding the global flags.
+// Every other section is named after a command and holds its flags.
+const GlobalSection = "global"
+
+// File is a parsed config file. Settings are keyed by section, then by flag
+// name without the leading dashes.
+type File struct {
+	Path     string
+	Sections map[string]map[string]interface{}
+}
+
+// Load reads a JSON config file such as
+//
+//	{
+//	  "global": {"url": "http://cattle:8080/v1", "storagepool-driver": "nfs"},
+//	  "volume": {"poll-interval": 1000, "convoy-drivers": ["vfs"]}
+//	}
+func Load(path string) (*File, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read config file. Path: %v. Error: %v", path, err)
+	}
+	return parse(path, content)
+}
+
+func parse(path string, content []byte) (*File, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	sections := map[string]interface{}{}
+	if err := decoder.Decode(&sections); err != nil {
+		return nil, fmt.Errorf("Cannot parse config file. Path: %v. Error: %v", path, err)
+	}
+
+	f := &File{Path: path, Sections: map[string]map[string]interface{}{}}
+	for name, section := range sections {
+		settings, ok := section.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Cannot parse config file. Path: %v. Error: section %s must be an object", path, name)
+		}
+		f.Sections[name] = settings
+	}
+	return f, nil
+}
+
+// Kinds of option values, as described in validation errors.
+const (
+	kindString      = "a string"
+	kindBool        = "a bool"
+	kindInt         = "an integer"
+	kindFloat       = "a number"
+	kindDuration    = "a duration"
+	kindStringSlice = "a list of strings"
+)
+
+// option is a flag that can be set from a config file.
+type option struct {
+	kind     string
+	envVars  []string
+	defaults []string
+}
+
+// envSet reports whether one of the option's environment variables is set,
+// in which case it takes precedence over the config file.
+func (o option) envSet() bool {
+	for _, name := range o.envVars {
+		if os.Getenv(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Schema lists the options each section of a config file may set.
+type Schema map[string]map[string]option
+
+// SchemaFor returns the options of the app's global flags, and of the flags
+// of each of its commands.
+func SchemaFor(app *cli.App) Schema {
+	schema := Schema{GlobalSection: options(app.Flags)}
+	for _, command := range app.Commands {
+		if len(command.Flags) > 0 {
+			schema[command.Name] = options(command.Flags)
+		}
+	}
+	return schema
+}
+
+func options(flags []cli.Flag) map[string]option {
+	opts := map[string]option{}
+	for _, f := range flags {
+		var name, env string
+		var opt option
+		switch typedF := f.(type) {
+		case cli.StringFlag:
+			name, env = typedF.Name, typedF.EnvVar
+			opt = option{kind: kindString, defaults: []string{typedF.Value}}
+		case cli.BoolFlag:
+			name, env = typedF.Name, typedF.EnvVar
+			opt = option{kind: kindBool, defaults: []string{"false"}}
+		case cli.BoolTFlag:
+			name, env = typedF.Name, typedF.EnvVar
+			opt = option{kind: kindBool, defaults: []string{"true"}}
+		case cli.IntFlag:
+			name, env = typedF.Name, typedF.EnvVar
+			opt = option{kind: kindInt, defaults: []string{strconv.Itoa(typedF.Value)}}
+		case cli.Float64Flag:
+			name, env = typedF.Name, typedF.EnvVar
+			opt = option{kind: kindFloat, defaults: []string{strconv.FormatFloat(typedF.Value, 'f', -1, 64)}}
+		case cli.DurationFlag:
+			name, env = typedF.Name, typedF.EnvVar
+			opt = option{kind: kindDuration, defaults: []string{typedF.Value.String()}}
+		case cli.StringSliceFlag:
+			name, env = typedF.Name, typedF.EnvVar
+			opt = option{kind: kindStringSlice, defaults: []string{}}
+			if typedF.Value != nil {
+				opt.defaults = append(opt.defaults, typedF.Value.Value()...)
+			}
+		default:
+			continue
+		}
+		name = strings.TrimSpace(strings.Split(name, ",")[0])
+		if name == "help" || name == "version" {
+			continue
+		}
+		if env != "" {
+			for _, e := range strings.Split(env, ",") {
+				opt.envVars = append(opt.envVars, strings.TrimSpace(e))
+			}
+		}
+		opts[name] = opt
+	}
+	return opts
+}
+
+// ValidationError lists everything wrong with a config file.
+type ValidationError struct {
+	Path     string
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("Invalid config file %v:\n  %s", e.Path, strings.Join(e.Problems, "\n  "))
+}
+
+// Validate checks that the file only sets known options, with values of the
+// right type that meet the options' constraints.
+func (s Schema) Validate(f *File) error {
+	problems := []string{}
+	for _, section := range sortedKeys(f.Sections) {
+		opts, ok := s[section]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: unknown section", section))
+			continue
+		}
+		for _, name := range sortedKeys(f.Sections[section]) {
+			key := section + "." + name
+			opt, ok := opts[name]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s: unknown option", key))
+				continue
+			}
+			values, err := opt.values(f.Sections[section][name])
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", key, err))
+				continue
+			}
+			if check, ok := constraints[name]; ok {
+				for _, value := range values {
+					if err := check(value); err != nil {
+						problems = append(problems, fmt.Sprintf("%s: %v", key, err))
+					}
+				}
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Path: f.Path, Problems: problems}
+	}
+	return nil
+}
+
+// values converts a value from a config file into the strings a flag is set
+// with, checking that it has the option's kind.
+func (o option) values(v interface{}) ([]string, error) {
+	switch o.kind {
+	case kindString:
+		if s, ok := v.(string); ok {
+			return []string{s}, nil
+		}
+	case kindBool:
+		if b, ok := v.(bool); ok {
+			return []string{strconv.FormatBool(b)}, nil
+		}
+	case kindInt:
+		if n, ok := v.(json.Number); ok {
+			if _, err := strconv.Atoi(n.String()); err == nil {
+				return []string{n.String()}, nil
+			}
+		}
+	case kindFloat:
+		if n, ok := v.(json.Number); ok {
+			if _, err := n.Float64(); err == nil {
+				return []string{n.String()}, nil
+			}
+		}
+	case kindDuration:
+		if s, ok := v.(string); ok {
+			if _, err := time.ParseDuration(s); err != nil {
+				return nil, fmt.Errorf("invalid duration %q, expected a value such as 30s or 5m", s)
+			}
+			return []string{s}, nil
+		}
+	case kindStringSlice:
+		if items, ok := v.([]interface{}); ok {
+			values := []string{}
+			for _, item := range items {
+				s, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("expected %s, got a list containing %s", o.kind, describe(item))
+				}
+				values = append(values, s)
+			}
+			return values, nil
+		}
+	}
+	return nil, fmt.Errorf("expected %s, got %s", o.kind, describe(v))
+}
+
+func describe(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return fmt.Sprintf("string %q", value)
+	case bool:
+		return fmt.Sprintf("bool %v", value)
+	case json.Number:
+		return fmt.Sprintf("number %v", value)
+	case []interface{}:
+		return "a list"
+	case map[string]interface{}:
+		return "an object"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func sortedKeys(m interface{}) []string {
+	keys := []string{}
+	switch typed := m.(type) {
+	case map[string]map[string]interface{}:
+		for k := range typed {
+			keys = append(keys, k)
+		}
+	case map[string]interface{}:
+		for k := range typed {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// loaded is the config file the running command was started with.
+type loaded struct {
+	schema  Schema
+	context *cli.Context
+	section string
+	file    *File
+	// fromFile records the command flags that were set from the file.
+	fromFile map[string]bool
+}
+
+var (
+	mu        sync.Mutex
+	appSchema Schema
+	current   *loaded
+)
+
+// Wrap makes every command of the app fill in its flags, and the global
+// flags, from the config file named by the config global flag before it runs.
+// Flags given on the command line or through their environment variables
+// take precedence over the file. Subcommands only take global flags from the
+// file.
+func Wrap(app *cli.App) {
+	mu.Lock()
+	appSchema = SchemaFor(app)
+	mu.Unlock()
+
+	for i := range app.Commands {
+		if app.Commands[i].Name == commandName {
+			continue
+		}
+		wrapCommand(&app.Commands[i], app.Commands[i].Name)
+	}
+}
+
+func wrapCommand(command *cli.Command, section string) {
+	if action := command.Action; action != nil {
+		command.Action = func(c *cli.Context) {
+			if err := Apply(c, section); err != nil {
+				log.Fatal(err)
+			}
+			action(c)
+		}
+	}
+	for i := range command.Subcommands {
+		wrapCommand(&command.Subcommands[i], "")
+	}
+}
+
+// Apply sets the flags of section, and the global flags, that were given
+// neither on the command line nor through the environment from the config
+// file, once it has been validated. It does nothing if no config file is set.
+func Apply(c *cli.Context, section string) error {
+	path := c.GlobalString("config")
+	if path == "" {
+		return nil
+	}
+	f, err := Load(path)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	schema := appSchema
+	mu.Unlock()
+	if err := schema.Validate(f); err != nil {
+		return err
+	}
+
+	for name, opt := range schema[GlobalSection] {
+		v, ok := f.Sections[GlobalSection][name]
+		if !ok || c.GlobalIsSet(name) || opt.envSet() {
+			continue
+		}
+		if err := setFlag(c.GlobalGeneric(name), opt, v); err != nil {
+			return fmt.Errorf("Cannot set %s.%s from config file. Error: %v", GlobalSection, name, err)
+		}
+	}
+
+	fromFile := map[string]bool{}
+	if _, ok := schema[section]; ok && section != GlobalSection {
+		for name, opt := range schema[section] {
+			v, ok := f.Sections[section][name]
+			if !ok || c.IsSet(name) || opt.envSet() {
+				continue
+			}
+			if err := setFlag(c.Generic(name), opt, v); err != nil {
+				return fmt.Errorf("Cannot set %s.%s from config file. Error: %v", section, name, err)
+			}
+			fromFile[name] = true
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	current = &loaded{
+		schema:   schema,
+		context:  c,
+		section:  section,
+		file:     f,
+		fromFile: fromFile,
+	}
+	log.Debugf("Loaded config file %v", path)
+	return nil
+}
+
+func setFlag(value interface{}, opt option, v interface{}) error {
+	flagValue, ok := value.(flag.Value)
+	if !ok {
+		return fmt.Errorf("flag not found")
+	}
+	values, err := opt.values(v)
+	if err != nil {
+		return err
+	}
+	if slice, ok := flagValue.(*cli.StringSlice); ok {
+		*slice = cli.StringSlice{}
+	}
+	for _, s := range values {
+		if err := flagValue.Set(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsSet reports whether a flag of the running command was given on the
+// command line or set from the config file.
+func IsSet(c *cli.Context, name string) bool {
+	if c.IsSet(name) {
+		return true
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return current != nil && current.fromFile[name]
+}
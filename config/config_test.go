@@ -0,0 +1,200 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type ConfigTestSuite struct {
+	dir string
+}
+
+var _ = check.Suite(&ConfigTestSuite{})
+
+func (s *ConfigTestSuite) SetUpTest(c *check.C) {
+	s.dir = c.MkDir()
+	mu.Lock()
+	current = nil
+	handlers = handlers[:1]
+	mu.Unlock()
+}
+
+func (s *ConfigTestSuite) TearDownTest(c *check.C) {
+	os.Unsetenv("CONFIG_TEST_URL")
+}
+
+func (s *ConfigTestSuite) write(c *check.C, content string) string {
+	path := filepath.Join(s.dir, "config.json")
+	c.Assert(ioutil.WriteFile(path, []byte(content), 0644), check.IsNil)
+	return path
+}
+
+// seen is what the test app's agent command ran with.
+type seen struct {
+	url      string
+	debug    bool
+	interval int
+	grace    time.Duration
+	drivers  []string
+	setFlags map[string]bool
+	context  *cli.Context
+}
+
+func testApp(result *seen) *cli.App {
+	app := cli.NewApp()
+	app.Flags = []cli.Flag{
+		cli.StringFlag{Name: "config, c"},
+		cli.BoolFlag{Name: "debug, d"},
+		cli.StringFlag{Name: "url", EnvVar: "CONFIG_TEST_URL"},
+		cli.IntFlag{Name: "health-port", Value: 10241},
+	}
+	app.Commands = []cli.Command{
+		{
+			Name: "agent",
+			Flags: []cli.Flag{
+				cli.IntFlag{Name: "poll-interval", Value: 1000},
+				cli.DurationFlag{Name: "grace", Value: 30 * time.Second},
+				cli.StringSliceFlag{Name: "convoy-drivers", Value: &cli.StringSlice{}},
+				cli.StringFlag{Name: "convoy-root"},
+			},
+			Action: func(c *cli.Context) {
+				result.url = c.GlobalString("url")
+				result.debug = c.GlobalBool("debug")
+				result.interval = c.Int("poll-interval")
+				result.grace = c.Duration("grace")
+				result.drivers = c.StringSlice("convoy-drivers")
+				result.setFlags = map[string]bool{}
+				for _, name := range []string{"poll-interval", "convoy-drivers", "convoy-root"} {
+					result.setFlags[name] = IsSet(c, name)
+				}
+				result.context = c
+			},
+		},
+	}
+	Wrap(app)
+	return app
+}
+
+func (s *ConfigTestSuite) TestFileSetsUnsetFlags(c *check.C) {
+	path := s.write(c, `{
+		"global": {"url": "http://file", "debug": true},
+		"agent": {"poll-interval": 500, "grace": "1m", "convoy-drivers": ["vfs"]}
+	}`)
+	result := &seen{}
+	c.Assert(testApp(result).Run([]string{"convoy-agent", "--config", path, "agent"}), check.IsNil)
+
+	c.Assert(result.url, check.Equals, "http://file")
+	c.Assert(result.debug, check.Equals, true)
+	c.Assert(result.interval, check.Equals, 500)
+	c.Assert(result.grace, check.Equals, time.Minute)
+	c.Assert(result.drivers, check.DeepEquals, []string{"vfs"})
+	c.Assert(result.setFlags, check.DeepEquals, map[string]bool{
+		"poll-interval":  true,
+		"convoy-drivers": true,
+		"convoy-root":    false,
+	})
+}
+
+func (s *ConfigTestSuite) TestPrecedence(c *check.C) {
+	path := s.write(c, `{
+		"global": {"url": "http://file"},
+		"agent": {"poll-interval": 500}
+	}`)
+
+	os.Setenv("CONFIG_TEST_URL", "http://env")
+	result := &seen{}
+	c.Assert(testApp(result).Run([]string{"convoy-agent", "-c", path, "agent", "--poll-interval", "250"}), check.IsNil)
+	c.Assert(result.url, check.Equals, "http://env")
+	c.Assert(result.interval, check.Equals, 250)
+
+	result = &seen{}
+	c.Assert(testApp(result).Run([]string{"convoy-agent", "-c", path, "--url", "http://flag", "agent"}), check.IsNil)
+	c.Assert(result.url, check.Equals, "http://flag")
+	c.Assert(result.interval, check.Equals, 500)
+}
+
+func (s *ConfigTestSuite) TestValidate(c *check.C) {
+	schema := SchemaFor(testApp(&seen{}))
+	f, err := parse("config.json", []byte(`{
+		"global": {"debug": "yes", "health-port": 70000, "colour": "blue"},
+		"agent": {"grace": "soon", "convoy-drivers": ["vfs", 1], "poll-interval": 1.5},
+		"other": {}
+	}`))
+	c.Assert(err, check.IsNil)
+
+	err = schema.Validate(f)
+	c.Assert(err, check.NotNil)
+	c.Assert(err.(*ValidationError).Problems, check.DeepEquals, []string{
+		"agent.convoy-drivers: expected a list of strings, got a list containing number 1",
+		"agent.grace: invalid duration \"soon\", expected a value such as 30s or 5m",
+		"agent.poll-interval: expected an integer, got number 1.5",
+		"global.colour: unknown option",
+		"global.debug: expected a bool, got string \"yes\"",
+		"global.health-port: must be between 1 and 65535, got 70000",
+		"other: unknown section",
+	})
+
+	_, err = parse("config.json", []byte(`{"global": []}`))
+	c.Assert(err, check.ErrorMatches, ".*section global must be an object")
+	_, err = parse("config.json", []byte(`{"global": `))
+	c.Assert(err, check.ErrorMatches, "Cannot parse config file.*")
+}
+
+func (s *ConfigTestSuite) TestInvalidFileStopsCommand(c *check.C) {
+	path := s.write(c, `{"agent": {"poll-interval": "fast"}}`)
+	app := testApp(&seen{})
+	ctx := &seen{}
+	app.Commands[0].Action = func(c *cli.Context) {
+		ctx.context = c
+	}
+	c.Assert(app.Run([]string{"convoy-agent", "-c", path, "agent"}), check.IsNil)
+	c.Assert(Apply(ctx.context, "agent"), check.ErrorMatches, "(?s)Invalid config file .*agent.poll-interval: expected an integer.*")
+}
+
+func (s *ConfigTestSuite) TestReload(c *check.C) {
+	path := s.write(c, `{"agent": {"poll-interval": 500, "grace": "1m"}}`)
+	result := &seen{}
+	c.Assert(testApp(result).Run([]string{"convoy-agent", "-c", path, "agent", "--grace", "5s"}), check.IsNil)
+
+	intervals := []int{}
+	graces := []time.Duration{}
+	OnReload("agent", []string{"poll-interval", "grace"}, func(v Values) {
+		intervals = append(intervals, v.Int("poll-interval"))
+		graces = append(graces, v.Duration("grace"))
+	})
+
+	// Unchanged options do not call handlers.
+	c.Assert(Reload(), check.IsNil)
+	c.Assert(intervals, check.HasLen, 0)
+
+	// The grace period was given as a flag, which still wins.
+	s.write(c, `{"agent": {"poll-interval": 2000, "grace": "2m"}}`)
+	c.Assert(Reload(), check.IsNil)
+	c.Assert(intervals, check.DeepEquals, []int{2000})
+	c.Assert(graces, check.DeepEquals, []time.Duration{5 * time.Second})
+
+	// Removing an option restores its default.
+	s.write(c, `{"agent": {}}`)
+	c.Assert(Reload(), check.IsNil)
+	c.Assert(intervals, check.DeepEquals, []int{2000, 1000})
+
+	// An invalid file is rejected and leaves the settings alone.
+	s.write(c, `{"agent": {"poll-interval": -1, "bogus": true}}`)
+	c.Assert(Reload(), check.ErrorMatches, "(?s)Invalid config file.*")
+	c.Assert(intervals, check.DeepEquals, []int{2000, 1000})
+}
+
+func (s *ConfigTestSuite) TestReloadWithoutFile(c *check.C) {
+	c.Assert(Reload(), check.ErrorMatches, "No config file to reload")
+}
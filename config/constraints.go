@@ -0,0 +1,66 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// constraints check the values of options beyond their type, keyed by flag
+// name. They apply to the option in whichever section it appears.
+var constraints = map[string]func(value string) error{
+	"health-port":          intRange(1, 65535),
+	"healthcheck-interval": intRange(1, -1),
+	"poll-interval":        intRange(1, -1),
+	"event-workers":        intRange(1, -1),
+	"flap-threshold":       intRange(0, -1),
+	"drop-confirmations":   intRange(0, -1),
+	"max-drop-percent":     intRange(0, 100),
+	"low-space-percent":    intRange(0, 100),
+	"healthcheck-type":     oneOf("metadata", "file"),
+	"volume-naming":        oneOf("global", "account"),
+	"components":           listOf("driver", "agent"),
+	"profile":              oneOf("nfs", "efs", "glusterfs", "longhorn"),
+}
+
+// intRange accepts integers from min to max. A max below min means there is
+// no upper bound.
+func intRange(min, max int) func(string) error {
+	return func(value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("expected an integer, got %q", value)
+		}
+		if n < min || (max >= min && n > max) {
+			if max < min {
+				return fmt.Errorf("must be at least %d, got %d", min, n)
+			}
+			return fmt.Errorf("must be between %d and %d, got %d", min, max, n)
+		}
+		return nil
+	}
+}
+
+func oneOf(allowed ...string) func(string) error {
+	return func(value string) error {
+		for _, a := range allowed {
+			if value == a {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s, got %q", strings.Join(allowed, ", "), value)
+	}
+}
+
+// listOf accepts a comma separated list of the allowed values.
+func listOf(allowed ...string) func(string) error {
+	check := oneOf(allowed...)
+	return func(value string) error {
+		for _, item := range strings.Split(value, ",") {
+			if err := check(strings.TrimSpace(item)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
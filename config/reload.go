@@ -0,0 +1,205 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Values looks up the settings of a section after a reload. Flags given on
+// the command line or through the environment keep the value they started
+// with, others take the value in the reloaded file, or else their default.
+type Values struct {
+	l       *loaded
+	section string
+}
+
+func (v Values) lookup(name string) string {
+	opt, ok := v.l.schema[v.section][name]
+	if !ok {
+		return ""
+	}
+
+	var value interface{}
+	var set bool
+	if v.section == GlobalSection {
+		value, set = v.l.context.GlobalGeneric(name), v.l.context.GlobalIsSet(name)
+	} else {
+		value, set = v.l.context.Generic(name), v.l.context.IsSet(name)
+	}
+	if set || opt.envSet() {
+		if flagValue, ok := value.(flag.Value); ok {
+			return flagValue.String()
+		}
+	}
+
+	values := opt.defaults
+	if raw, ok := v.l.file.Sections[v.section][name]; ok {
+		if fileValues, err := opt.values(raw); err == nil {
+			values = fileValues
+		}
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (v Values) String(name string) string {
+	return v.lookup(name)
+}
+
+func (v Values) Bool(name string) bool {
+	b, _ := strconv.ParseBool(v.lookup(name))
+	return b
+}
+
+func (v Values) Int(name string) int {
+	n, _ := strconv.Atoi(v.lookup(name))
+	return n
+}
+
+func (v Values) Duration(name string) time.Duration {
+	d, _ := time.ParseDuration(v.lookup(name))
+	return d
+}
+
+// reloadHandler applies the options it names when they change on reload.
+type reloadHandler struct {
+	section string
+	names   []string
+	apply   func(Values)
+}
+
+var handlers = []reloadHandler{
+	{
+		section: GlobalSection,
+		names:   []string{"debug"},
+		apply: func(v Values) {
+			if v.Bool("debug") {
+				log.SetLevel(log.DebugLevel)
+			} else {
+				log.SetLevel(log.InfoLevel)
+			}
+		},
+	},
+}
+
+// OnReload registers apply to be called when any of the named options of
+// section change on reload. Options no handler is registered for cannot
+// change while the agent runs.
+func OnReload(section string, names []string, apply func(Values)) {
+	mu.Lock()
+	defer mu.Unlock()
+	handlers = append(handlers, reloadHandler{section: section, names: names, apply: apply})
+}
+
+// Reload re-reads and validates the config file the running command started
+// with, and applies the options that can change at runtime. A file that does
+// not validate is rejected as a whole, keeping the current settings.
+func Reload() error {
+	mu.Lock()
+	l := current
+	mu.Unlock()
+	if l == nil {
+		return fmt.Errorf("No config file to reload")
+	}
+
+	f, err := Load(l.file.Path)
+	if err != nil {
+		return err
+	}
+	if err := l.schema.Validate(f); err != nil {
+		return err
+	}
+
+	mu.Lock()
+	reloaded := &loaded{
+		schema:   l.schema,
+		context:  l.context,
+		section:  l.section,
+		file:     f,
+		fromFile: l.fromFile,
+	}
+	current = reloaded
+	hs := append([]reloadHandler{}, handlers...)
+	mu.Unlock()
+
+	reloadable := map[string]bool{}
+	for _, h := range hs {
+		for _, name := range h.names {
+			reloadable[h.section+"."+name] = true
+		}
+	}
+	changed := changedOptions(l.file, f, []string{GlobalSection, l.section})
+	keys := []string{}
+	for key := range changed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !reloadable[key] {
+			log.Warnf("%s changed in config file %v, restart the agent to apply it", key, f.Path)
+		}
+	}
+
+	for _, h := range hs {
+		for _, name := range h.names {
+			if changed[h.section+"."+name] {
+				log.Infof("Applying %s.%s from config file %v", h.section, name, f.Path)
+				h.apply(Values{l: reloaded, section: h.section})
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// changedOptions returns the options of the given sections that were added,
+// removed or changed between two versions of a file, keyed by section.name.
+func changedOptions(prev, next *File, sections []string) map[string]bool {
+	changed := map[string]bool{}
+	for _, section := range sections {
+		for name, v := range prev.Sections[section] {
+			if nv, ok := next.Sections[section][name]; !ok || !reflect.DeepEqual(v, nv) {
+				changed[section+"."+name] = true
+			}
+		}
+		for name := range next.Sections[section] {
+			if _, ok := prev.Sections[section][name]; !ok {
+				changed[section+"."+name] = true
+			}
+		}
+	}
+	return changed
+}
+
+// Watch reloads the config file whenever the agent receives SIGHUP. It does
+// nothing if the agent was not started with a config file.
+func Watch() {
+	mu.Lock()
+	l := current
+	mu.Unlock()
+	if l == nil {
+		return
+	}
+
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
+	go func() {
+		for range reloads {
+			log.Infof("Received SIGHUP, reloading config file %v", l.file.Path)
+			if err := Reload(); err != nil {
+				log.Errorf("Error reloading config file, keeping the current settings [%v]", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,232 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/rancher/convoy-agent/metrics"
+)
+
+// Status is the result of one named check. A component that is not Healthy
+// fails the health endpoint, one that is not Ready fails the readiness
+// endpoint, which covers components still starting up.
+type Status struct {
+	Healthy bool                   `json:"healthy"`
+	Ready   bool                   `json:"ready"`
+	Message string                 `json:"message,omitempty"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// OK is the status of a component with nothing to report.
+func OK(message string) Status {
+	return Status{Healthy: true, Ready: true, Message: message}
+}
+
+// Failing is the status of a component that is down.
+func Failing(err error) Status {
+	return Status{Message: err.Error()}
+}
+
+// Check reports the current status of one part of the agent.
+type Check func() Status
+
+var (
+	mu     sync.Mutex
+	checks = map[string]Check{}
+)
+
+// Register adds a check to the health endpoints, replacing any check of the
+// same name.
+func Register(name string, check Check) {
+	mu.Lock()
+	defer mu.Unlock()
+	checks[name] = check
+}
+
+// Report is the body of the health endpoints.
+type Report struct {
+	Healthy    bool              `json:"healthy"`
+	Ready      bool              `json:"ready"`
+	Components map[string]Status `json:"components"`
+}
+
+// Run runs every registered check. The agent is healthy or ready if all of
+// its components are.
+func Run() Report {
+	mu.Lock()
+	registered := make(map[string]Check, len(checks))
+	for name, check := range checks {
+		registered[name] = check
+	}
+	mu.Unlock()
+
+	report := Report{Healthy: true, Ready: true, Components: map[string]Status{}}
+	for name, check := range registered {
+		status := check()
+		report.Components[name] = status
+		report.Healthy = report.Healthy && status.Healthy
+		report.Ready = report.Ready && status.Ready
+	}
+	return report
+}
+
+func writeJSON(w http.ResponseWriter, ok bool, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Errorf("Error writing health report [%v]", err)
+	}
+}
+
+// HealthHandler answers with the status of every component, failing with 503
+// if any is unhealthy. /healthcheck/<name> reports a single component.
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	report := Run()
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/healthcheck"), "/")
+	if name == "" {
+		writeJSON(w, report.Healthy, report)
+		return
+	}
+	status, ok := report.Components[name]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown component %q", name), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, status.Healthy, status)
+}
+
+// ReadinessHandler answers like HealthHandler, but fails until every
+// component is ready.
+func ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	report := Run()
+	writeJSON(w, report.Ready, report)
+}
+
+// StartHealthCheck serves /healthcheck, /readiness and the Prometheus
+// /metrics on bind:port. An empty bind address listens on all interfaces.
+func StartHealthCheck(bind string, port int) error {
+	if port <= 0 || port > 65535 {
+		return fmt.Errorf("Invalid health check port number: %v", port)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthcheck", HealthHandler)
+	mux.HandleFunc("/healthcheck/", HealthHandler)
+	mux.HandleFunc("/readiness", ReadinessHandler)
+	mux.HandleFunc("/metrics", metrics.Handler)
+	addr := net.JoinHostPort(bind, strconv.Itoa(port))
+	log.Infof("Listening for health checks and metrics on %v", addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+// Serve runs StartHealthCheck in the background for the agent commands,
+// exiting if the endpoints cannot be served.
+func Serve(bind string, port int) {
+	go func() {
+		err := StartHealthCheck(bind, port)
+		log.Fatalf("Error while running healthcheck [%v]", err)
+	}()
+}
+
+// Cached wraps a check that is expensive or hits another service, so that it
+// runs at most once per ttl however often the endpoints are polled.
+func Cached(check Check, ttl time.Duration) Check {
+	var (
+		mu     sync.Mutex
+		status Status
+		at     time.Time
+	)
+	return func() Status {
+		mu.Lock()
+		defer mu.Unlock()
+		if at.IsZero() || time.Since(at) >= ttl {
+			status = check()
+			at = time.Now()
+		}
+		return status
+	}
+}
+
+// Tracker records the outcome of a recurring operation, such as polling
+// convoy or syncing a pool, and turns it into a Status.
+type Tracker struct {
+	mu          sync.Mutex
+	staleAfter  time.Duration
+	started     time.Time
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastErr     error
+}
+
+// NewTracker returns a tracker that is unhealthy while the last attempt
+// failed. With staleAfter set, it is also unhealthy when there has been no
+// success for that long, and not ready until the first success.
+func NewTracker(staleAfter time.Duration) *Tracker {
+	return &Tracker{
+		staleAfter: staleAfter,
+		started:    time.Now(),
+	}
+}
+
+// SetStaleAfter changes how long the tracker may go without a success before
+// it is unhealthy.
+func (t *Tracker) SetStaleAfter(staleAfter time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.staleAfter = staleAfter
+}
+
+// Record records an attempt, which failed if err is not nil.
+func (t *Tracker) Record(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil {
+		t.lastFailure = time.Now()
+	} else {
+		t.lastSuccess = time.Now()
+	}
+	t.lastErr = err
+}
+
+// Status reports the last attempt as a component status.
+func (t *Tracker) Status() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	status := Status{Healthy: true, Ready: true, Details: map[string]interface{}{}}
+	if !t.lastSuccess.IsZero() {
+		status.Details["lastSuccess"] = t.lastSuccess.UTC().Format(time.RFC3339)
+	}
+	if !t.lastFailure.IsZero() {
+		status.Details["lastFailure"] = t.lastFailure.UTC().Format(time.RFC3339)
+	}
+
+	if t.lastErr != nil {
+		status.Healthy = false
+		status.Ready = false
+		status.Message = t.lastErr.Error()
+	}
+	if t.staleAfter > 0 {
+		since := t.lastSuccess
+		if since.IsZero() {
+			since = t.started
+			status.Ready = false
+		}
+		if age := time.Since(since); age > t.staleAfter {
+			status.Healthy = false
+			if status.Message == "" {
+				status.Message = fmt.Sprintf("no success for %v", age)
+			}
+		}
+	}
+	return status
+}
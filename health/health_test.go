@@ -0,0 +1,139 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type HealthTestSuite struct {
+}
+
+var _ = check.Suite(&HealthTestSuite{})
+
+func (s *HealthTestSuite) SetUpTest(c *check.C) {
+	mu.Lock()
+	checks = map[string]Check{}
+	mu.Unlock()
+}
+
+func (s *HealthTestSuite) get(c *check.C, handler http.HandlerFunc, path string) (int, Report) {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", path, nil))
+	report := Report{}
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &report), check.IsNil)
+	return w.Code, report
+}
+
+func (s *HealthTestSuite) TestHealthy(c *check.C) {
+	Register("leader", func() Status { return OK("leader") })
+	Register("convoy", func() Status { return OK("") })
+
+	code, report := s.get(c, HealthHandler, "/healthcheck")
+	c.Assert(code, check.Equals, http.StatusOK)
+	c.Assert(report.Healthy, check.Equals, true)
+	c.Assert(report.Components["leader"], check.DeepEquals, OK("leader"))
+	c.Assert(report.Components, check.HasLen, 2)
+}
+
+func (s *HealthTestSuite) TestUnhealthy(c *check.C) {
+	Register("leader", func() Status { return OK("leader") })
+	Register("convoy", func() Status { return Failing(errors.New("connection refused")) })
+
+	code, report := s.get(c, HealthHandler, "/healthcheck")
+	c.Assert(code, check.Equals, http.StatusServiceUnavailable)
+	c.Assert(report.Healthy, check.Equals, false)
+	c.Assert(report.Components["convoy"].Message, check.Equals, "connection refused")
+}
+
+func (s *HealthTestSuite) TestNoChecks(c *check.C) {
+	code, report := s.get(c, HealthHandler, "/healthcheck")
+	c.Assert(code, check.Equals, http.StatusOK)
+	c.Assert(report.Healthy, check.Equals, true)
+}
+
+func (s *HealthTestSuite) TestSingleComponent(c *check.C) {
+	Register("leader", func() Status { return OK("leader") })
+	Register("convoy", func() Status { return Failing(errors.New("connection refused")) })
+
+	w := httptest.NewRecorder()
+	HealthHandler(w, httptest.NewRequest("GET", "/healthcheck/leader", nil))
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+	status := Status{}
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &status), check.IsNil)
+	c.Assert(status, check.DeepEquals, OK("leader"))
+
+	w = httptest.NewRecorder()
+	HealthHandler(w, httptest.NewRequest("GET", "/healthcheck/convoy", nil))
+	c.Assert(w.Code, check.Equals, http.StatusServiceUnavailable)
+
+	w = httptest.NewRecorder()
+	HealthHandler(w, httptest.NewRequest("GET", "/healthcheck/cattle", nil))
+	c.Assert(w.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *HealthTestSuite) TestReadiness(c *check.C) {
+	Register("event-stream", func() Status { return Status{Healthy: true, Message: "connecting"} })
+
+	code, _ := s.get(c, HealthHandler, "/healthcheck")
+	c.Assert(code, check.Equals, http.StatusOK)
+	code, report := s.get(c, ReadinessHandler, "/readiness")
+	c.Assert(code, check.Equals, http.StatusServiceUnavailable)
+	c.Assert(report.Ready, check.Equals, false)
+}
+
+func (s *HealthTestSuite) TestCached(c *check.C) {
+	calls := 0
+	cached := Cached(func() Status {
+		calls++
+		return OK("")
+	}, time.Hour)
+
+	cached()
+	cached()
+	if calls != 1 {
+		c.Fatalf("Expected the check to run once, ran %d times", calls)
+	}
+}
+
+func (s *HealthTestSuite) TestTracker(c *check.C) {
+	t := NewTracker(0)
+	c.Assert(t.Status().Healthy, check.Equals, true)
+
+	t.Record(errors.New("connection refused"))
+	status := t.Status()
+	c.Assert(status.Healthy, check.Equals, false)
+	c.Assert(status.Message, check.Equals, "connection refused")
+	c.Assert(status.Details["lastFailure"], check.NotNil)
+
+	t.Record(nil)
+	status = t.Status()
+	c.Assert(status.Healthy, check.Equals, true)
+	c.Assert(status.Ready, check.Equals, true)
+	c.Assert(status.Details["lastSuccess"], check.NotNil)
+}
+
+func (s *HealthTestSuite) TestTrackerStale(c *check.C) {
+	t := NewTracker(time.Hour)
+	status := t.Status()
+	c.Assert(status.Healthy, check.Equals, true)
+	c.Assert(status.Ready, check.Equals, false)
+
+	t.Record(nil)
+	c.Assert(t.Status().Ready, check.Equals, true)
+
+	t.lastSuccess = time.Now().Add(-2 * time.Hour)
+	status = t.Status()
+	c.Assert(status.Healthy, check.Equals, false)
+	c.Assert(status.Ready, check.Equals, true)
+}
@@ -0,0 +1,103 @@
+package heartbeat
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// CheckType is the --healthcheck-type value that selects heartbeat files.
+const CheckType = "file"
+
+const tmpSuffix = ".tmp"
+
+// Write records a heartbeat for the host with the given UUID in dir. The file
+// is named after the UUID and holds the time of the heartbeat.
+func Write(dir, uuid string, now time.Time) error {
+	if uuid == "" || strings.ContainsAny(uuid, "/\x00") || strings.HasPrefix(uuid, ".") {
+		return fmt.Errorf("invalid host uuid %q", uuid)
+	}
+	path := filepath.Join(dir, uuid)
+	tmp := path + tmpSuffix
+	if err := ioutil.WriteFile(tmp, []byte(now.UTC().Format(time.RFC3339Nano)), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Read returns the time of the last heartbeat of every host in dir.
+func Read(dir string) (map[string]time.Time, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	beats := map[string]time.Time{}
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, tmpSuffix) {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(content)))
+		if err != nil {
+			log.Warnf("Ignoring unreadable heartbeat file %s: %v", name, err)
+			continue
+		}
+		beats[name] = t
+	}
+	return beats, nil
+}
+
+// Remove deletes the heartbeat file of a host.
+func Remove(dir, uuid string) error {
+	err := os.Remove(filepath.Join(dir, uuid))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Writer periodically writes a host's heartbeat.
+type Writer struct {
+	dir      string
+	uuid     string
+	interval time.Duration
+}
+
+func NewWriter(dir, uuid string, interval time.Duration) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Writer{
+		dir:      dir,
+		uuid:     uuid,
+		interval: interval,
+	}, nil
+}
+
+func (w *Writer) Run(controlChan chan bool) error {
+	for {
+		if err := Write(w.dir, w.uuid, time.Now()); err != nil {
+			log.Errorf("Error writing heartbeat for host %s [%v]", w.uuid, err)
+		}
+
+		select {
+		case <-controlChan:
+			controlChan <- true
+			return nil
+		case <-time.After(w.interval):
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package heartbeat
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type HeartbeatTestSuite struct {
+}
+
+var _ = check.Suite(&HeartbeatTestSuite{})
+
+func (s *HeartbeatTestSuite) TestWriteReadRemove(c *check.C) {
+	dir := c.MkDir()
+	now := time.Now()
+
+	c.Assert(Write(dir, "host1", now), check.IsNil)
+	c.Assert(Write(dir, "host2", now.Add(-time.Minute)), check.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "garbage"), []byte("not a time"), 0644), check.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "host3.tmp"), []byte(now.Format(time.RFC3339Nano)), 0644), check.IsNil)
+
+	beats, err := Read(dir)
+	c.Assert(err, check.IsNil)
+	c.Assert(len(beats), check.Equals, 2)
+	c.Assert(beats["host1"].Equal(now), check.Equals, true)
+	c.Assert(beats["host2"].Equal(now.Add(-time.Minute)), check.Equals, true)
+
+	c.Assert(Remove(dir, "host2"), check.IsNil)
+	c.Assert(Remove(dir, "host2"), check.IsNil)
+	beats, err = Read(dir)
+	c.Assert(err, check.IsNil)
+	c.Assert(len(beats), check.Equals, 1)
+}
+
+func (s *HeartbeatTestSuite) TestRejectsInvalidUUID(c *check.C) {
+	dir := c.MkDir()
+	for _, uuid := range []string{"", "../host", ".hidden"} {
+		c.Assert(Write(dir, uuid, time.Now()), check.NotNil, check.Commentf(uuid))
+	}
+}
@@ -0,0 +1,138 @@
+package identity
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// FileName is the file in an agent's root dir holding the agent's UUID.
+const FileName = "UUID"
+
+const lockFileName = FileName + ".lock"
+
+// Identity is the stable UUID an agent reports as, loaded from its root dir.
+// The root dir stays locked while the identity is held so that two agents
+// cannot share it.
+type Identity struct {
+	UUID string
+	lock *os.File
+}
+
+// Load locks rootDir and returns the UUID stored in it, creating one on first
+// start. It fails if another agent holds the root dir.
+func Load(rootDir string) (*Identity, error) {
+	if err := os.MkdirAll(rootDir, 0755); err != nil {
+		return nil, err
+	}
+
+	lock, err := acquire(filepath.Join(rootDir, lockFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	uuid, err := readOrCreate(filepath.Join(rootDir, FileName))
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return &Identity{UUID: uuid, lock: lock}, nil
+}
+
+// acquire takes an exclusive lock on path and records who holds it, so that
+// a conflicting agent can say which agent it conflicts with.
+func acquire(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		owner, _ := ioutil.ReadAll(f)
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, fmt.Errorf("Cannot use agent root dir %v, it is in use by another agent (%s)", filepath.Dir(path), strings.TrimSpace(string(owner)))
+		}
+		return nil, fmt.Errorf("Cannot lock agent root dir %v. Error: %v", filepath.Dir(path), err)
+	}
+
+	hostname, _ := os.Hostname()
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(fmt.Sprintf("pid %d on %s\n", os.Getpid(), hostname)), 0)
+	}
+	return f, nil
+}
+
+func readOrCreate(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err == nil {
+		uuid := strings.TrimSpace(string(content))
+		if uuid == "" || strings.ContainsAny(uuid, " \t\n/") {
+			return "", fmt.Errorf("Invalid agent UUID %q in %v", uuid, path)
+		}
+		return uuid, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	uuid, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(uuid+"\n"), 0644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", err
+	}
+	log.Infof("Created agent UUID %s in %v", uuid, path)
+	return uuid, nil
+}
+
+// newUUID returns a random version 4 UUID.
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// Release unlocks the root dir so that another agent can use it.
+func (i *Identity) Release() error {
+	return i.lock.Close()
+}
+
+// LogHook returns a logrus hook that adds the agent's UUID to every entry.
+func (i *Identity) LogHook() log.Hook {
+	return logHook{uuid: i.UUID}
+}
+
+type logHook struct {
+	uuid string
+}
+
+func (h logHook) Levels() []log.Level {
+	return []log.Level{
+		log.PanicLevel,
+		log.FatalLevel,
+		log.ErrorLevel,
+		log.WarnLevel,
+		log.InfoLevel,
+		log.DebugLevel,
+	}
+}
+
+func (h logHook) Fire(entry *log.Entry) error {
+	entry.Data["agentId"] = h.uuid
+	return nil
+}
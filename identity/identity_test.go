@@ -0,0 +1,87 @@
+package identity
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type IdentityTestSuite struct {
+}
+
+var _ = check.Suite(&IdentityTestSuite{})
+
+var uuidPattern = regexp.MustCompile("^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$")
+
+func (s *IdentityTestSuite) TestCreatedOnceAndReloaded(c *check.C) {
+	dir := filepath.Join(c.MkDir(), "root")
+
+	id, err := Load(dir)
+	c.Assert(err, check.IsNil)
+	c.Assert(uuidPattern.MatchString(id.UUID), check.Equals, true, check.Commentf("uuid %q", id.UUID))
+	c.Assert(id.Release(), check.IsNil)
+
+	again, err := Load(dir)
+	c.Assert(err, check.IsNil)
+	defer again.Release()
+	c.Assert(again.UUID, check.Equals, id.UUID)
+}
+
+func (s *IdentityTestSuite) TestExistingUUIDIsKept(c *check.C) {
+	dir := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, FileName), []byte("1234567890\n"), 0644), check.IsNil)
+
+	id, err := Load(dir)
+	c.Assert(err, check.IsNil)
+	defer id.Release()
+	c.Assert(id.UUID, check.Equals, "1234567890")
+}
+
+func (s *IdentityTestSuite) TestInvalidUUID(c *check.C) {
+	dir := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, FileName), []byte("\n"), 0644), check.IsNil)
+
+	_, err := Load(dir)
+	c.Assert(err, check.ErrorMatches, "Invalid agent UUID .*")
+
+	// The failed load does not keep the root dir locked.
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, FileName), []byte("abc"), 0644), check.IsNil)
+	id, err := Load(dir)
+	c.Assert(err, check.IsNil)
+	id.Release()
+}
+
+func (s *IdentityTestSuite) TestSharedRootDirConflicts(c *check.C) {
+	dir := c.MkDir()
+
+	id, err := Load(dir)
+	c.Assert(err, check.IsNil)
+
+	_, err = Load(dir)
+	c.Assert(err, check.ErrorMatches, "Cannot use agent root dir .*, it is in use by another agent \\(pid [0-9]+ on .*\\)")
+
+	c.Assert(id.Release(), check.IsNil)
+	id, err = Load(dir)
+	c.Assert(err, check.IsNil)
+	id.Release()
+}
+
+func (s *IdentityTestSuite) TestLogHook(c *check.C) {
+	buf := &bytes.Buffer{}
+	logger := log.New()
+	logger.Out = buf
+	logger.Hooks.Add((&Identity{UUID: "agent-1"}).LogHook())
+
+	logger.Info("hello")
+	c.Assert(buf.String(), check.Matches, "(?s).*agentId=agent-1.*")
+}
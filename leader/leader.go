@@ -0,0 +1,194 @@
+package leader
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// LeaseFileName is the file in the lock dir recording the current leader.
+const LeaseFileName = "leader"
+
+// record is the content of the lease file. The leader bumps Renewal on every
+// renewal, so a lease whose content stops changing has expired. Comparing
+// content rather than timestamps keeps the election independent of clock
+// skew between hosts.
+type record struct {
+	Holder  string `json:"holder"`
+	Term    int64  `json:"term"`
+	Renewal int64  `json:"renewal"`
+}
+
+// Elector elects one leader among agents sharing a lock dir on shared
+// storage. The leader renews its lease every lease/3. A standby takes over
+// once the lease has not changed for a whole lease, so a failed leader is
+// replaced within about lease + lease/3.
+type Elector struct {
+	path  string
+	id    string
+	lease time.Duration
+
+	mu         sync.Mutex
+	leader     bool
+	holder     string
+	observed   record
+	observedAt time.Time
+	lastRenew  time.Time
+	claimed    bool
+}
+
+func NewElector(lockDir, id string, lease time.Duration) (*Elector, error) {
+	if err := os.MkdirAll(lockDir, 0755); err != nil {
+		return nil, err
+	}
+	return &Elector{
+		path:  filepath.Join(lockDir, LeaseFileName),
+		id:    id,
+		lease: lease,
+	}, nil
+}
+
+// IsLeader reports whether this agent currently holds the lease.
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+// Holder returns the UUID of the agent last seen holding the lease.
+func (e *Elector) Holder() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.holder
+}
+
+func (e *Elector) renewInterval() time.Duration {
+	return e.lease / 3
+}
+
+// Run takes part in the election until a value is sent on controlChan. A
+// leader gives up its lease on the way out so that a standby can take over
+// straight away.
+func (e *Elector) Run(controlChan chan bool) error {
+	for {
+		if err := e.tick(time.Now()); err != nil {
+			log.Errorf("Error checking leader lease %v [%v]", e.path, err)
+		}
+
+		select {
+		case <-controlChan:
+			e.resign()
+			controlChan <- true
+			return nil
+		case <-time.After(e.renewInterval()):
+		}
+	}
+}
+
+func (e *Elector) tick(now time.Time) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	rec, err := e.read()
+	if err != nil {
+		if e.leader && now.Sub(e.lastRenew) >= e.lease-e.renewInterval() {
+			log.Warnf("Cannot renew leader lease for %v, stepping down", now.Sub(e.lastRenew))
+			e.leader = false
+		}
+		return err
+	}
+	if rec != e.observed || e.observedAt.IsZero() {
+		e.observed = rec
+		e.observedAt = now
+	}
+	e.holder = rec.Holder
+
+	switch {
+	case rec.Holder == e.id:
+		// Either a claim made on an earlier tick survived, or this agent
+		// restarted while holding the lease.
+		rec.Renewal++
+		if err := e.write(rec); err != nil {
+			return err
+		}
+		e.observed, e.observedAt, e.lastRenew = rec, now, now
+		e.claimed = false
+		if !e.leader {
+			log.Infof("Became leader for term %d", rec.Term)
+			e.leader = true
+		}
+	case rec.Holder == "" || now.Sub(e.observedAt) >= e.lease:
+		if e.leader {
+			log.Warnf("Lost leadership to %s", rec.Holder)
+			e.leader = false
+		}
+		if rec.Holder != "" {
+			log.Warnf("Leader %s has not renewed its lease for %v, taking over", rec.Holder, now.Sub(e.observedAt))
+		}
+		// The claim only counts if it is still in place on the next tick,
+		// which settles races between standbys claiming at once.
+		claim := record{Holder: e.id, Term: rec.Term + 1}
+		if err := e.write(claim); err != nil {
+			return err
+		}
+		e.claimed = true
+	default:
+		if e.leader {
+			log.Warnf("Lost leadership to %s", rec.Holder)
+			e.leader = false
+		}
+		e.claimed = false
+	}
+	return nil
+}
+
+// resign clears the lease if this agent holds it.
+func (e *Elector) resign() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.leader && !e.claimed {
+		return
+	}
+	rec, err := e.read()
+	if err == nil && rec.Holder == e.id {
+		err = e.write(record{Term: rec.Term})
+	}
+	if err != nil {
+		log.Errorf("Error giving up leader lease [%v]", err)
+	}
+	e.leader = false
+	e.claimed = false
+}
+
+func (e *Elector) read() (record, error) {
+	rec := record{}
+	content, err := ioutil.ReadFile(e.path)
+	if os.IsNotExist(err) {
+		return rec, nil
+	}
+	if err != nil {
+		return rec, err
+	}
+	if len(content) == 0 {
+		return rec, nil
+	}
+	err = json.Unmarshal(content, &rec)
+	return rec, err
+}
+
+func (e *Elector) write(rec record) error {
+	content, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	tmp := e.path + "." + e.id + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, e.path)
+}
@@ -0,0 +1,136 @@
+package leader
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type LeaderTestSuite struct {
+	dir string
+}
+
+var _ = check.Suite(&LeaderTestSuite{})
+
+func (s *LeaderTestSuite) SetUpTest(c *check.C) {
+	s.dir = c.MkDir()
+}
+
+func (s *LeaderTestSuite) elector(c *check.C, id string) *Elector {
+	e, err := NewElector(s.dir, id, 30*time.Second)
+	c.Assert(err, check.IsNil)
+	return e
+}
+
+func (s *LeaderTestSuite) TestFirstAgentBecomesLeader(c *check.C) {
+	a := s.elector(c, "a")
+	now := time.Now()
+
+	// The claim is confirmed on the next tick.
+	c.Assert(a.tick(now), check.IsNil)
+	c.Assert(a.IsLeader(), check.Equals, false)
+	c.Assert(a.tick(now.Add(10*time.Second)), check.IsNil)
+	c.Assert(a.IsLeader(), check.Equals, true)
+	c.Assert(a.Holder(), check.Equals, "a")
+}
+
+func (s *LeaderTestSuite) TestStandbyWaitsWhileLeaderRenews(c *check.C) {
+	a := s.elector(c, "a")
+	b := s.elector(c, "b")
+	now := time.Now()
+
+	c.Assert(a.tick(now), check.IsNil)
+	c.Assert(a.tick(now.Add(time.Second)), check.IsNil)
+	c.Assert(a.IsLeader(), check.Equals, true)
+
+	for i := 2; i < 120; i += 10 {
+		c.Assert(b.tick(now.Add(time.Duration(i)*time.Second)), check.IsNil)
+		c.Assert(a.tick(now.Add(time.Duration(i+5)*time.Second)), check.IsNil)
+		c.Assert(b.IsLeader(), check.Equals, false)
+		c.Assert(a.IsLeader(), check.Equals, true)
+	}
+	c.Assert(b.Holder(), check.Equals, "a")
+}
+
+func (s *LeaderTestSuite) TestStandbyTakesOverExpiredLease(c *check.C) {
+	a := s.elector(c, "a")
+	b := s.elector(c, "b")
+	now := time.Now()
+
+	c.Assert(a.tick(now), check.IsNil)
+	c.Assert(a.tick(now.Add(time.Second)), check.IsNil)
+	c.Assert(b.tick(now.Add(2*time.Second)), check.IsNil)
+
+	// a stops renewing. b takes over once the lease has not changed for a
+	// whole lease.
+	c.Assert(b.tick(now.Add(20*time.Second)), check.IsNil)
+	c.Assert(b.IsLeader(), check.Equals, false)
+	c.Assert(b.tick(now.Add(32*time.Second)), check.IsNil)
+	c.Assert(b.tick(now.Add(42*time.Second)), check.IsNil)
+	c.Assert(b.IsLeader(), check.Equals, true)
+
+	// a comes back and finds it has lost the lease.
+	c.Assert(a.tick(now.Add(43*time.Second)), check.IsNil)
+	c.Assert(a.IsLeader(), check.Equals, false)
+	c.Assert(a.Holder(), check.Equals, "b")
+}
+
+func (s *LeaderTestSuite) TestConcurrentClaimsSettle(c *check.C) {
+	a := s.elector(c, "a")
+	b := s.elector(c, "b")
+	now := time.Now()
+
+	// Both find the lease vacant, and b's claim lands after a's.
+	c.Assert(a.tick(now), check.IsNil)
+	c.Assert(b.write(record{Holder: "b", Term: 1}), check.IsNil)
+	b.claimed = true
+
+	c.Assert(a.tick(now.Add(10*time.Second)), check.IsNil)
+	c.Assert(b.tick(now.Add(10*time.Second)), check.IsNil)
+
+	c.Assert(a.IsLeader(), check.Equals, false)
+	c.Assert(b.IsLeader(), check.Equals, true)
+}
+
+func (s *LeaderTestSuite) TestResignHandsOver(c *check.C) {
+	a := s.elector(c, "a")
+	b := s.elector(c, "b")
+	now := time.Now()
+
+	c.Assert(a.tick(now), check.IsNil)
+	c.Assert(a.tick(now.Add(time.Second)), check.IsNil)
+	c.Assert(b.tick(now.Add(2*time.Second)), check.IsNil)
+
+	a.resign()
+	c.Assert(a.IsLeader(), check.Equals, false)
+
+	// The vacant lease is claimed without waiting for it to expire.
+	c.Assert(b.tick(now.Add(3*time.Second)), check.IsNil)
+	c.Assert(b.tick(now.Add(13*time.Second)), check.IsNil)
+	c.Assert(b.IsLeader(), check.Equals, true)
+}
+
+func (s *LeaderTestSuite) TestRunStopsOnControlChan(c *check.C) {
+	a := s.elector(c, "a")
+	controlChan := make(chan bool, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- a.Run(controlChan)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	controlChan <- true
+	select {
+	case err := <-done:
+		c.Assert(err, check.IsNil)
+	case <-time.After(time.Second):
+		c.Fatal("elector did not stop")
+	}
+	c.Assert(<-controlChan, check.Equals, true)
+}
@@ -2,17 +2,17 @@ package main
 
 import (
 	"os"
+	"time"
 
-	log "github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
+	"github.com/rancher/convoy-agent/admin"
+	"github.com/rancher/convoy-agent/config"
 	"github.com/rancher/convoy-agent/storagepool"
 	"github.com/rancher/convoy-agent/volume"
-	"github.com/rancher/kubernetes-agent/healthcheck"
 )
 
 var (
 	VERSION = "0.10.0-dev"
-	port    = 10241
 )
 
 func main() {
@@ -23,6 +23,11 @@ func main() {
 	app.Usage = "An agent that acts as an interface between rancher storage and cattle server"
 
 	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:   "config, c",
+			Usage:  "JSON file to read settings from, with global flags under \"global\" and each command's flags under its name. Flags and environment variables take precedence over it",
+			EnvVar: "CONVOY_AGENT_CONFIG",
+		},
 		cli.BoolFlag{
 			Name:  "debug, d",
 			Usage: "enable debug logging level",
@@ -47,6 +52,11 @@ func main() {
 			Value: 5000,
 			Usage: "set the frequency of performing healthchecks",
 		},
+		cli.StringFlag{
+			Name:  "healthcheck-type",
+			Value: "metadata",
+			Usage: "how the storagepool agent finds pool members: metadata, or file to use heartbeat files in healthcheck-basedir",
+		},
 		cli.StringFlag{
 			Name:  "healthcheck-basedir",
 			Value: ".healthcheck",
@@ -61,20 +71,56 @@ func main() {
 			Name:  "storagepool-driver",
 			Usage: "set the storage pool driver.",
 		},
+		cli.DurationFlag{
+			Name:  "event-timeout",
+			Value: 15 * time.Second,
+			Usage: "how long cattle waits for a reply to a storage event that does not carry its own timeout. Handlers give up in time to reply with a timeout error",
+		},
+		cli.StringFlag{
+			Name:  "volume-naming",
+			Value: "global",
+			Usage: "how Cattle volume names map to convoy volume names: global, or account to prefix names with the owning account",
+		},
+		cli.StringFlag{
+			Name:  "volume-owners",
+			Usage: "JSON file mapping the names of volumes created before account naming was enabled to the ids of their accounts. Only listed volumes are found by their unprefixed names",
+		},
+		cli.StringFlag{
+			Name:  "quarantine-dir",
+			Usage: "quarantine removed volumes by recording them in this directory instead of deleting them. Must be visible to the storagepool and volume agents",
+		},
+		cli.DurationFlag{
+			Name:  "quarantine-retention",
+			Value: 72 * time.Hour,
+			Usage: "how long a quarantined volume is kept before its data is destroyed",
+		},
 		cli.StringFlag{
 			Name:  "socket, s",
 			Value: "/var/run/convoy/convoy.sock",
 			Usage: "specify unix domain socket for communicating with convoy server",
 		},
+		cli.StringFlag{
+			Name:  "admin-socket",
+			Value: "/var/run/convoy-agent/admin.sock",
+			Usage: "unix socket to serve the local admin API on, for inspecting and controlling the agent. Empty disables the admin API",
+		},
+		cli.StringFlag{
+			Name:  "health-bind",
+			Usage: "address the health, readiness and metrics endpoints listen on. Empty listens on all interfaces",
+		},
+		cli.IntFlag{
+			Name:  "health-port",
+			Value: 10241,
+			Usage: "port the health, readiness and metrics endpoints listen on",
+		},
 	}
 
 	commands := append(volume.Commands, storagepool.Commands...)
+	commands = append(commands, admin.Commands...)
+	commands = append(commands, config.Commands...)
 	app.Commands = commands
+	config.Wrap(app)
 
-	go func() {
-		err := healthcheck.StartHealthCheck(port)
-		log.Fatalf("Error while running healthcheck [%v]", err)
-	}()
 	app.EnableBashCompletion = true
 	app.Run(os.Args)
 }
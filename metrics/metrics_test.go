@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type MetricsTestSuite struct {
+	registry *Registry
+}
+
+var _ = check.Suite(&MetricsTestSuite{})
+
+func (s *MetricsTestSuite) SetUpTest(c *check.C) {
+	s.registry = NewRegistry()
+}
+
+func (s *MetricsTestSuite) output() string {
+	buf := &bytes.Buffer{}
+	s.registry.Write(buf)
+	return buf.String()
+}
+
+func (s *MetricsTestSuite) TestCounter(c *check.C) {
+	events := newCounter("events_total", "Events sent.", "driver", "result")
+	s.registry.register(events)
+	errors := newCounter("errors_total", "Errors.")
+	s.registry.register(errors)
+
+	events.Inc("vfs", "sent")
+	events.Add(2, "vfs", "sent")
+	events.Inc("ebs", "failed")
+	c.Assert(events.Value("vfs", "sent"), check.Equals, float64(3))
+
+	c.Assert(s.output(), check.Equals, `# HELP errors_total Errors.
+# TYPE errors_total counter
+errors_total 0
+# HELP events_total Events sent.
+# TYPE events_total counter
+events_total{driver="ebs",result="failed"} 1
+events_total{driver="vfs",result="sent"} 3
+`)
+}
+
+func (s *MetricsTestSuite) TestGauge(c *check.C) {
+	hosts := newGauge("pool_hosts", "Hosts per pool.", "pool")
+	s.registry.register(hosts)
+
+	hosts.Set(3, "vfs")
+	hosts.Set(1, "ebs")
+	hosts.Set(2, "vfs")
+	hosts.Delete("ebs")
+
+	c.Assert(s.output(), check.Equals, `# HELP pool_hosts Hosts per pool.
+# TYPE pool_hosts gauge
+pool_hosts{pool="vfs"} 2
+`)
+}
+
+func (s *MetricsTestSuite) TestHistogram(c *check.C) {
+	latency := newHistogram("latency_seconds", "Latency.", []float64{0.1, 1}, "event")
+	s.registry.register(latency)
+
+	latency.Observe(0.05, "ping")
+	latency.Observe(0.5, "ping")
+	latency.Observe(2, "ping")
+	c.Assert(latency.Count("ping"), check.Equals, uint64(3))
+
+	c.Assert(s.output(), check.Equals, `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{event="ping",le="0.1"} 1
+latency_seconds_bucket{event="ping",le="1"} 2
+latency_seconds_bucket{event="ping",le="+Inf"} 3
+latency_seconds_sum{event="ping"} 2.55
+latency_seconds_count{event="ping"} 3
+`)
+}
+
+func (s *MetricsTestSuite) TestEscaping(c *check.C) {
+	events := newCounter("events_total", "Events\nby \"name\".", "name")
+	s.registry.register(events)
+	events.Inc("a\"b\\c\nd")
+
+	c.Assert(s.output(), check.Equals, `# HELP events_total Events\nby "name".
+# TYPE events_total counter
+events_total{name="a\"b\\c\nd"} 1
+`)
+}
+
+func (s *MetricsTestSuite) TestWrongLabelCountPanics(c *check.C) {
+	events := newCounter("events_total", "Events.", "driver")
+	c.Assert(func() { events.Inc() }, check.PanicMatches, "metric events_total takes labels .*")
+}
+
+func (s *MetricsTestSuite) TestDuplicateNamePanics(c *check.C) {
+	s.registry.register(newCounter("events_total", "Events."))
+	c.Assert(func() { s.registry.register(newGauge("events_total", "Events.")) }, check.PanicMatches, ".*registered twice")
+}
@@ -1,41 +1,275 @@
 package storagepool
 
 import (
+	"sort"
+	"sync"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy-agent/admin"
 	"github.com/rancher/convoy-agent/cattle"
+	"github.com/rancher/convoy-agent/health"
+	"github.com/rancher/convoy-agent/leader"
+	"github.com/rancher/convoy-agent/volume"
 )
 
-var rootUuidFileName = "UUID"
-
 type StoragepoolAgent struct {
 	healthCheckInterval int
 	storagepoolRootDir  string
-	driver              string
+	pools               []Pool
 	cattleClient        cattle.CattleInterface
+	agentService        string
+	approveDrop         chan struct{}
+	healthCheckType     string
+	healthCheckBaseDir  string
+	capacity            map[string]*capacityCollector
+	capabilities        map[string]*Capabilities
+	leader              *leader.Elector
+	syncs               *health.Tracker
+	resyncChan          chan struct{}
+
+	mu               sync.Mutex
+	membershipPolicy MembershipPolicy
+	paused           bool
+	status           []admin.Pool
 }
 
 func NewStoragepoolAgent(healthCheckInterval int, storagepoolRootDir, driver string, cattleClient cattle.CattleInterface) *StoragepoolAgent {
 	return &StoragepoolAgent{
 		healthCheckInterval: healthCheckInterval,
 		storagepoolRootDir:  storagepoolRootDir,
-		driver:              driver,
+		pools:               []Pool{{Driver: driver}},
 		cattleClient:        cattleClient,
+		approveDrop:         make(chan struct{}, 1),
+		syncs:               health.NewTracker(0),
+		resyncChan:          make(chan struct{}, 1),
+	}
+}
+
+// SyncHealth reports whether the last storage pool sync succeeded.
+func (s *StoragepoolAgent) SyncHealth() health.Status {
+	return s.syncs.Status()
+}
+
+// SetAgentService restricts pool membership to hosts running a healthy
+// container of the named service in the agent's stack. By default containers
+// of every service in the stack count.
+func (s *StoragepoolAgent) SetAgentService(name string) {
+	s.agentService = name
+}
+
+// SetHealthCheck selects how pool members are found: from rancher-metadata,
+// or from heartbeat files the volume agents write into baseDir.
+func (s *StoragepoolAgent) SetHealthCheck(checkType, baseDir string) {
+	s.healthCheckType = checkType
+	s.healthCheckBaseDir = baseDir
+}
+
+// SetCapacityReporting publishes the capacity and usage of the filesystem
+// mounted at mountRoot, and the number of volumes convoy reports, with every
+// sync of the pool named driver. A warning is logged when free space drops
+// below lowSpacePercent. The convoy client may be nil to leave out the volume
+// count.
+func (s *StoragepoolAgent) SetCapacityReporting(driver, mountRoot string, convoyClient *volume.ConvoyClient, lowSpacePercent int) {
+	c := &capacityCollector{
+		mountRoot:       mountRoot,
+		lowSpacePercent: lowSpacePercent,
+	}
+	if convoyClient != nil {
+		c.volumes = convoyClient
+	}
+	if s.capacity == nil {
+		s.capacity = map[string]*capacityCollector{}
+	}
+	s.capacity[driver] = c
+}
+
+// SetCapabilities publishes the capabilities of the pool named driver in its
+// description and data with every sync.
+func (s *StoragepoolAgent) SetCapabilities(driver string, caps Capabilities) {
+	if s.capabilities == nil {
+		s.capabilities = map[string]*Capabilities{}
+	}
+	s.capabilities[driver] = &caps
+}
+
+// SetPools replaces the single pool named after the agent's driver with the
+// given pools, each made up of the hosts matching its selector.
+func (s *StoragepoolAgent) SetPools(pools []Pool) {
+	s.pools = pools
+}
+
+// SetElector makes the agent sync pools only while it is the elected leader
+// among replicas. Standbys keep tracking membership so that they can take
+// over with an up to date view.
+func (s *StoragepoolAgent) SetElector(elector *leader.Elector) {
+	s.leader = elector
+}
+
+// SetMembershipPolicy sets the grace periods and flap damping applied to
+// hosts joining and leaving the pool. By default changes apply immediately.
+// A running agent applies a new policy from its next poll on.
+func (s *StoragepoolAgent) SetMembershipPolicy(policy MembershipPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.membershipPolicy = policy
+}
+
+func (s *StoragepoolAgent) policy() MembershipPolicy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.membershipPolicy
+}
+
+// ApproveDrop lets a large drop in pool membership that is being held back by
+// the membership policy go ahead on the next sync.
+func (s *StoragepoolAgent) ApproveDrop() {
+	select {
+	case s.approveDrop <- struct{}{}:
+	default:
+	}
+}
+
+// Resync makes the agent sync every pool to Cattle straight away, even if
+// nothing changed.
+func (s *StoragepoolAgent) Resync() {
+	select {
+	case s.resyncChan <- struct{}{}:
+	default:
+	}
+}
+
+// SetPaused stops or restarts syncing pools. Pools are resynced on resuming.
+func (s *StoragepoolAgent) SetPaused(paused bool) {
+	s.mu.Lock()
+	s.paused = paused
+	s.mu.Unlock()
+	if !paused {
+		s.Resync()
+	}
+}
+
+func (s *StoragepoolAgent) Paused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+// Pools returns the agent's pools as last synced to Cattle.
+func (s *StoragepoolAgent) Pools() []admin.Pool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pools := []admin.Pool{}
+	if s.status != nil {
+		return append(pools, s.status...)
+	}
+	for _, p := range s.pools {
+		pools = append(pools, admin.Pool{Driver: p.Driver, Selector: p.Selector.String(), Hosts: []string{}})
 	}
+	return pools
 }
 
-func (s *StoragepoolAgent) Run(metadataUrl string) error {
-	prevSent := map[string]bool{}
+// publish records the state of the pools for Pools.
+func (s *StoragepoolAgent) publish(pools []*poolSync, isLeader bool) {
+	status := []admin.Pool{}
+	for _, p := range pools {
+		hosts := []string{}
+		for host := range p.prevSent {
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+		pool := admin.Pool{
+			Driver:   p.Driver,
+			Selector: p.Selector.String(),
+			Hosts:    hosts,
+			Leader:   isLeader,
+			DropHeld: p.guard.pending != nil,
+			LastSync: admin.FormatTime(p.lastSync),
+		}
+		if p.lastErr != nil {
+			pool.Error = p.lastErr.Error()
+		}
+		status = append(status, pool)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+// poolSync is the membership state of one of the agent's pools.
+type poolSync struct {
+	Pool
+	members   *membership
+	guard     *dropGuard
+	prevSent  map[string]bool
+	prevStats *PoolStats
+	resync    bool
+	lastSync  time.Time
+	lastErr   error
+}
+
+// Run syncs each pool's hosts to Cattle whenever metadata changes, and at
+// least every healthCheckInterval so that failed syncs are retried. When
+// capacity reporting is set, changes in usage also trigger a sync. It returns
+// once a value is sent on controlChan, acknowledging by sending one back.
+func (s *StoragepoolAgent) Run(metadataUrl string, controlChan chan bool) error {
+	interval := time.Duration(s.healthCheckInterval) * time.Millisecond
+
+	pools := []*poolSync{}
+	labelHosts := false
+	policy := s.policy()
+	for _, pool := range s.pools {
+		pools = append(pools, &poolSync{
+			Pool:     pool,
+			members:  newMembership(policy),
+			guard:    newDropGuard(policy),
+			prevSent: map[string]bool{},
+		})
+		if !pool.Selector.Empty() {
+			labelHosts = true
+		}
+	}
 
-	hc, err := newHealthChecker(metadataUrl)
+	hc, err := newHealthChecker(healthCheckConfig{
+		checkType:    s.healthCheckType,
+		metadataUrl:  metadataUrl,
+		interval:     interval,
+		agentService: s.agentService,
+		baseDir:      s.healthCheckBaseDir,
+		labelHosts:   labelHosts,
+	})
 	if err != nil {
 		log.Errorf("Error initializing health checker, err = [%v]", err)
 		return err
 	}
 
+	stop := make(chan struct{})
+	defer close(stop)
+	changed := hc.watch(stop)
+
+	// Without an elector the agent always leads, and starts out as if it
+	// had done so all along.
+	wasLeader := s.leader == nil
+
 	for {
-		time.Sleep(time.Duration(s.healthCheckInterval) * time.Millisecond)
+		approved := false
+		select {
+		case <-controlChan:
+			controlChan <- true
+			return nil
+		case <-changed:
+		case <-time.After(interval):
+		case <-s.approveDrop:
+			approved = true
+		case <-s.resyncChan:
+			for _, p := range pools {
+				p.resync = true
+			}
+		}
+		if s.Paused() {
+			continue
+		}
 
 		currHosts, err := hc.populateHostMap()
 		if err != nil {
@@ -43,36 +277,111 @@ func (s *StoragepoolAgent) Run(metadataUrl string) error {
 			continue
 		}
 
-		toSend := map[string]bool{}
-		for uuid := range currHosts {
-			toSend[uuid] = true
+		var labels map[string]map[string]string
+		if l, ok := hc.(hostLabeler); ok {
+			labels = l.hostLabels()
 		}
 
-		shouldSend := false
-		for key := range toSend {
-			if _, ok := prevSent[key]; !ok {
-				shouldSend = true
+		isLeader := s.leader == nil || s.leader.IsLeader()
+		if isLeader && !wasLeader {
+			// The pools were last synced by another agent, so sync them
+			// even if nothing changed here.
+			for _, p := range pools {
+				p.resync = true
 			}
 		}
+		wasLeader = isLeader
 
-		for key := range prevSent {
-			if _, ok := toSend[key]; !ok {
-				shouldSend = true
+		if newPolicy := s.policy(); newPolicy != policy {
+			policy = newPolicy
+			for _, p := range pools {
+				p.members.policy = policy
+				p.guard.maxDropPercent = policy.MaxDropPercent
+				p.guard.confirmations = policy.DropConfirmations
 			}
 		}
 
-		if shouldSend {
-			toSendList := []string{}
-			for k := range toSend {
-				toSendList = append(toSendList, k)
+		now := time.Now()
+		for _, p := range pools {
+			observed := map[string]bool{}
+			for uuid := range currHosts {
+				if p.Selector.Empty() || p.Selector.Matches(labels[uuid]) {
+					observed[uuid] = true
+				}
 			}
-			err := s.cattleClient.SyncStoragePool(s.driver, toSendList)
-			if err != nil {
-				log.Errorf("Error syncing storage pool events [%v]", err)
-				continue
-			}
-			prevSent = toSend
+			s.sync(p, observed, s.stats(p.Driver), now, approved, isLeader)
 		}
+		s.publish(pools, isLeader)
+	}
+}
+
+// sync reports a pool's members to Cattle if they or its stats changed since
+// the last successful sync. Standbys only track membership.
+func (s *StoragepoolAgent) sync(p *poolSync, observed map[string]bool, stats *PoolStats, now time.Time, approved, isLeader bool) {
+	members := p.members.update(observed, now)
+	if !isLeader {
+		p.prevSent = members
+		poolHosts.Set(float64(len(members)), p.Driver)
+		return
+	}
+	toSend := p.guard.check(p.prevSent, members, approved)
+	if !p.resync && sameHosts(toSend, p.prevSent) && !statsChanged(p.prevStats, stats) {
+		return
+	}
+
+	toSendList := []string{}
+	for k := range toSend {
+		toSendList = append(toSendList, k)
+	}
+	if stats == nil {
+		stats = p.prevStats
+	}
+	err := s.cattleClient.SyncStoragePool(s.pool(p.Driver, stats), toSendList)
+	s.syncs.Record(err)
+	p.lastErr = err
+	if err != nil {
+		poolSyncs.Inc(p.Driver, "failed")
+		log.Errorf("Error syncing storage pool %s events [%v]", p.Driver, err)
+		return
+	}
+	poolSyncs.Inc(p.Driver, "ok")
+	p.lastSync = now
+	poolHosts.Set(float64(len(toSend)), p.Driver)
+	p.prevSent = toSend
+	p.prevStats = stats
+	p.resync = false
+}
+
+// stats returns the capacity and usage of the pool named driver, or nil if
+// they are not reported or cannot be collected.
+func (s *StoragepoolAgent) stats(driver string) *PoolStats {
+	c := s.capacity[driver]
+	if c == nil {
+		return nil
+	}
+	stats, err := c.collect()
+	if err != nil {
+		log.Errorf("Error collecting capacity of storage pool %s [%v]", driver, err)
+	}
+	return stats
+}
+
+// pool returns what is reported to Cattle about a pool besides its hosts.
+func (s *StoragepoolAgent) pool(driver string, stats *PoolStats) cattle.StoragePool {
+	pool := cattle.StoragePool{Driver: driver}
+	caps := s.capabilities[driver]
+	if stats == nil && caps == nil {
+		return pool
+	}
+	pool.Data = map[string]interface{}{}
+	if stats != nil {
+		for k, v := range stats.data() {
+			pool.Data[k] = v
+		}
+	}
+	if caps != nil {
+		pool.Description = caps.Description()
+		pool.Data["capabilities"] = caps.data()
 	}
-	return nil
+	return pool
 }
@@ -0,0 +1,168 @@
+package storagepool
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/convoy-agent/volume"
+)
+
+const (
+	ReadWriteOnce = "ReadWriteOnce"
+	ReadWriteMany = "ReadWriteMany"
+)
+
+// Capabilities describe what a storage pool's volumes support, so that Cattle
+// and tooling can choose between pools.
+type Capabilities struct {
+	// Driver is the convoy driver the capabilities were derived from.
+	Driver string
+	// Shared is true when a volume can be used from any host in the pool,
+	// and false when it lives on a single host.
+	Shared    bool
+	Snapshots bool
+	Backups   bool
+	// MinSizeBytes and MaxSizeBytes bound the size of a volume. 0 means no
+	// bound.
+	MinSizeBytes int64
+	MaxSizeBytes int64
+	AccessModes  []string
+}
+
+const (
+	gib = int64(1) << 30
+	tib = int64(1) << 40
+)
+
+// driverCapabilities are the capabilities of the convoy drivers the agent is
+// deployed with. Whether a driver is shared is up to volume.IsSharedDriver.
+var driverCapabilities = map[string]Capabilities{
+	"devicemapper": {Snapshots: true, Backups: true, AccessModes: []string{ReadWriteOnce}},
+	"ebs":          {Snapshots: true, Backups: true, MinSizeBytes: gib, MaxSizeBytes: 16 * tib, AccessModes: []string{ReadWriteOnce}},
+	"glusterfs":    {AccessModes: []string{ReadWriteMany}},
+	"longhorn":     {Snapshots: true, Backups: true, AccessModes: []string{ReadWriteOnce}},
+	"vfs":          {Snapshots: true, Backups: true, AccessModes: []string{ReadWriteMany}},
+}
+
+// CapabilitiesFor returns the capabilities of a pool backed by the given
+// convoy drivers. Volumes are created with convoy's default driver, the first
+// one listed, so it alone decides the capabilities. For an unknown driver an
+// error is returned along with empty capabilities naming the driver, for the
+// caller to fill in with Override.
+func CapabilitiesFor(drivers []string) (Capabilities, error) {
+	if len(drivers) == 0 {
+		return Capabilities{}, nil
+	}
+	caps, ok := driverCapabilities[drivers[0]]
+	if !ok {
+		return Capabilities{Driver: drivers[0]}, fmt.Errorf("Unknown convoy driver %v. Set the pool's capabilities explicitly", drivers[0])
+	}
+	caps.Driver = drivers[0]
+	caps.Shared = volume.IsSharedDriver(drivers[0])
+	caps.AccessModes = append([]string{}, caps.AccessModes...)
+	return caps, nil
+}
+
+// Override applies explicit key=value settings on top of the capabilities.
+// The keys are shared, snapshots, backups, min-size, max-size and
+// access-modes, a comma separated list.
+func (c *Capabilities) Override(settings []string) error {
+	for _, setting := range settings {
+		parts := strings.SplitN(setting, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("Invalid pool capability %q, expected key=value", setting)
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+
+		var err error
+		switch key {
+		case "shared":
+			c.Shared, err = strconv.ParseBool(value)
+		case "snapshots":
+			c.Snapshots, err = strconv.ParseBool(value)
+		case "backups":
+			c.Backups, err = strconv.ParseBool(value)
+		case "min-size":
+			c.MinSizeBytes, err = volume.ParseSize(value)
+		case "max-size":
+			c.MaxSizeBytes, err = volume.ParseSize(value)
+		case "access-modes":
+			c.AccessModes, err = parseAccessModes(value)
+		default:
+			return fmt.Errorf("Unknown pool capability %q", key)
+		}
+		if err != nil {
+			return fmt.Errorf("Invalid value for pool capability %v: %v", key, err)
+		}
+	}
+	if c.MaxSizeBytes > 0 && c.MinSizeBytes > c.MaxSizeBytes {
+		return fmt.Errorf("Pool min-size %d is larger than max-size %d", c.MinSizeBytes, c.MaxSizeBytes)
+	}
+	return nil
+}
+
+func parseAccessModes(value string) ([]string, error) {
+	modes := []string{}
+	for _, mode := range strings.Split(value, ",") {
+		mode = strings.TrimSpace(mode)
+		switch mode {
+		case "":
+		case ReadWriteOnce, ReadWriteMany:
+			modes = append(modes, mode)
+		default:
+			return nil, fmt.Errorf("unknown access mode %q", mode)
+		}
+	}
+	return modes, nil
+}
+
+// data returns the capabilities in the form they are published in
+// StoragePool.Data.
+func (c Capabilities) data() map[string]interface{} {
+	d := map[string]interface{}{
+		"shared":      c.Shared,
+		"snapshots":   c.Snapshots,
+		"backups":     c.Backups,
+		"accessModes": c.AccessModes,
+	}
+	if c.Driver != "" {
+		d["convoyDriver"] = c.Driver
+	}
+	if c.MinSizeBytes > 0 {
+		d["minSizeBytes"] = c.MinSizeBytes
+	}
+	if c.MaxSizeBytes > 0 {
+		d["maxSizeBytes"] = c.MaxSizeBytes
+	}
+	return d
+}
+
+// Description summarises the capabilities for the pool's description.
+func (c Capabilities) Description() string {
+	parts := []string{}
+	locality := "Host-local"
+	if c.Shared {
+		locality = "Shared"
+	}
+	if c.Driver != "" {
+		parts = append(parts, fmt.Sprintf("%s %s pool", locality, c.Driver))
+	} else {
+		parts = append(parts, fmt.Sprintf("%s pool", locality))
+	}
+
+	features := []string{}
+	if c.Snapshots {
+		features = append(features, "snapshots")
+	}
+	if c.Backups {
+		features = append(features, "backups")
+	}
+	if len(features) > 0 {
+		parts = append(parts, "supports "+strings.Join(features, " and "))
+	}
+	if len(c.AccessModes) > 0 {
+		parts = append(parts, "access modes "+strings.Join(c.AccessModes, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
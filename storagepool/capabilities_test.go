@@ -0,0 +1,65 @@
+package storagepool
+
+import (
+	"gopkg.in/check.v1"
+)
+
+type CapabilitiesTestSuite struct {
+}
+
+var _ = check.Suite(&CapabilitiesTestSuite{})
+
+func (s *CapabilitiesTestSuite) TestDerivedFromDefaultDriver(c *check.C) {
+	caps, err := CapabilitiesFor([]string{"glusterfs", "vfs"})
+	c.Assert(err, check.IsNil)
+	c.Assert(caps.Driver, check.Equals, "glusterfs")
+	c.Assert(caps.Shared, check.Equals, true)
+	c.Assert(caps.Snapshots, check.Equals, false)
+	c.Assert(caps.AccessModes, check.DeepEquals, []string{ReadWriteMany})
+	c.Assert(caps.Description(), check.Equals, "Shared glusterfs pool; access modes ReadWriteMany")
+
+	caps, err = CapabilitiesFor([]string{"ebs"})
+	c.Assert(err, check.IsNil)
+	c.Assert(caps.Description(), check.Equals, "Host-local ebs pool; supports snapshots and backups; access modes ReadWriteOnce")
+	c.Assert(caps.data()["maxSizeBytes"], check.Equals, 16*tib)
+}
+
+func (s *CapabilitiesTestSuite) TestUnknownDriver(c *check.C) {
+	caps, err := CapabilitiesFor([]string{"mystery"})
+	c.Assert(err, check.NotNil)
+	c.Assert(caps.Driver, check.Equals, "mystery")
+
+	c.Assert(caps.Override([]string{"shared=true", "access-modes=ReadWriteMany"}), check.IsNil)
+	c.Assert(caps.Shared, check.Equals, true)
+	c.Assert(caps.AccessModes, check.DeepEquals, []string{ReadWriteMany})
+}
+
+func (s *CapabilitiesTestSuite) TestOverride(c *check.C) {
+	caps, err := CapabilitiesFor([]string{"longhorn"})
+	c.Assert(err, check.IsNil)
+
+	c.Assert(caps.Override([]string{"backups=false", "min-size=1Gi", "max-size=2T"}), check.IsNil)
+	c.Assert(caps.Backups, check.Equals, false)
+	c.Assert(caps.Snapshots, check.Equals, true)
+	c.Assert(caps.MinSizeBytes, check.Equals, gib)
+	c.Assert(caps.MaxSizeBytes, check.Equals, 2*tib)
+
+	c.Assert(caps.Override([]string{"shared"}), check.ErrorMatches, "Invalid pool capability .*")
+	c.Assert(caps.Override([]string{"colour=blue"}), check.ErrorMatches, "Unknown pool capability .*")
+	c.Assert(caps.Override([]string{"snapshots=maybe"}), check.ErrorMatches, "Invalid value for pool capability snapshots.*")
+	c.Assert(caps.Override([]string{"access-modes=ReadOnlyMany"}), check.ErrorMatches, ".*unknown access mode.*")
+	c.Assert(caps.Override([]string{"min-size=4T"}), check.ErrorMatches, "Pool min-size .* is larger than max-size .*")
+}
+
+func (s *CapabilitiesTestSuite) TestReportedPerPool(c *check.C) {
+	agent := NewStoragepoolAgent(0, "", "nfs", nil)
+	caps, err := CapabilitiesFor([]string{"glusterfs"})
+	c.Assert(err, check.IsNil)
+	agent.SetCapabilities("nfs", caps)
+
+	pool := agent.pool("nfs", nil)
+	c.Assert(pool.Description, check.Equals, caps.Description())
+	other := agent.pool("local", nil)
+	c.Assert(other.Description, check.Equals, "")
+	c.Assert(other.Data, check.IsNil)
+}
@@ -0,0 +1,119 @@
+package storagepool
+
+import (
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/rancher/convoy-agent/volume"
+)
+
+// PoolStats is the capacity and usage of a storage pool's backing filesystem.
+// VolumeCount is -1 when convoy's volumes could not be listed.
+type PoolStats struct {
+	TotalBytes  uint64
+	UsedBytes   uint64
+	FreeBytes   uint64
+	VolumeCount int
+}
+
+// data returns the stats in the form they are published in StoragePool.Data.
+// An unknown volume count is left out.
+func (p *PoolStats) data() map[string]interface{} {
+	data := map[string]interface{}{
+		"capacityBytes": p.TotalBytes,
+		"usedBytes":     p.UsedBytes,
+		"freeBytes":     p.FreeBytes,
+	}
+	if p.VolumeCount >= 0 {
+		data["volumeCount"] = p.VolumeCount
+	}
+	return data
+}
+
+// freePercent is the share of the pool still available, rounded down.
+func (p *PoolStats) freePercent() int {
+	if p.TotalBytes == 0 {
+		return 100
+	}
+	return int(p.FreeBytes * 100 / p.TotalBytes)
+}
+
+type volumeLister interface {
+	GetCurrVolumes() (volume.Volume, error)
+}
+
+// capacityCollector gathers PoolStats for the filesystem mounted at mountRoot
+// and warns when free space drops below lowSpacePercent.
+type capacityCollector struct {
+	mountRoot       string
+	volumes         volumeLister
+	lowSpacePercent int
+	lowSpace        bool
+}
+
+func (c *capacityCollector) collect() (*PoolStats, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(c.mountRoot, &fs); err != nil {
+		return nil, err
+	}
+
+	bsize := uint64(fs.Bsize)
+	stats := &PoolStats{
+		TotalBytes: fs.Blocks * bsize,
+		UsedBytes:  (fs.Blocks - fs.Bfree) * bsize,
+		FreeBytes:  fs.Bavail * bsize,
+	}
+
+	if c.volumes != nil {
+		// The filesystem stats are still worth reporting when convoy
+		// cannot be reached, so only the count is given up.
+		vols, err := c.volumes.GetCurrVolumes()
+		if err != nil {
+			log.Errorf("Error counting volumes of storage pool at %s [%v]", c.mountRoot, err)
+			stats.VolumeCount = -1
+		} else {
+			stats.VolumeCount = len(vols)
+		}
+	}
+
+	c.checkLowSpace(stats)
+	return stats, nil
+}
+
+// checkLowSpace warns when the pool first drops below the low space threshold
+// and logs when it recovers, rather than on every poll.
+func (c *capacityCollector) checkLowSpace(stats *PoolStats) {
+	if c.lowSpacePercent <= 0 {
+		return
+	}
+	free := stats.freePercent()
+	switch {
+	case free < c.lowSpacePercent && !c.lowSpace:
+		c.lowSpace = true
+		log.Warnf("Storage pool at %s is low on space: %d%% free (%d of %d bytes), below the %d%% threshold", c.mountRoot, free, stats.FreeBytes, stats.TotalBytes, c.lowSpacePercent)
+	case free >= c.lowSpacePercent && c.lowSpace:
+		c.lowSpace = false
+		log.Infof("Storage pool at %s is no longer low on space: %d%% free", c.mountRoot, free)
+	}
+}
+
+// statsChanged reports whether curr differs enough from the last stats sent
+// to be worth a sync: a different volume count, or usage moving by at least
+// one percent of capacity.
+func statsChanged(prev, curr *PoolStats) bool {
+	if curr == nil {
+		return false
+	}
+	if prev == nil {
+		return true
+	}
+	if prev.VolumeCount != curr.VolumeCount || prev.TotalBytes != curr.TotalBytes {
+		return true
+	}
+	delta := curr.UsedBytes - prev.UsedBytes
+	if prev.UsedBytes > curr.UsedBytes {
+		delta = prev.UsedBytes - curr.UsedBytes
+	}
+	return delta*100 >= curr.TotalBytes && delta > 0
+}
@@ -0,0 +1,75 @@
+package storagepool
+
+import (
+	"errors"
+
+	"gopkg.in/check.v1"
+
+	"github.com/rancher/convoy-agent/volume"
+	"github.com/rancher/convoy/api"
+)
+
+type CapacityTestSuite struct {
+}
+
+var _ = check.Suite(&CapacityTestSuite{})
+
+type fakeLister struct {
+	volumes volume.Volume
+	err     error
+}
+
+func (f *fakeLister) GetCurrVolumes() (volume.Volume, error) {
+	return f.volumes, f.err
+}
+
+func (s *CapacityTestSuite) TestCollect(c *check.C) {
+	lister := &fakeLister{volumes: volume.Volume{
+		"vol1": api.VolumeResponse{Name: "vol1"},
+		"vol2": api.VolumeResponse{Name: "vol2"},
+	}}
+	collector := &capacityCollector{mountRoot: c.MkDir(), volumes: lister}
+
+	stats, err := collector.collect()
+	c.Assert(err, check.IsNil)
+	c.Assert(stats.TotalBytes > 0, check.Equals, true)
+	c.Assert(stats.UsedBytes+stats.FreeBytes <= stats.TotalBytes, check.Equals, true)
+	c.Assert(stats.VolumeCount, check.Equals, 2)
+	c.Assert(stats.data()["volumeCount"], check.Equals, 2)
+
+	lister.err = errors.New("convoy is down")
+	stats, err = collector.collect()
+	c.Assert(err, check.IsNil)
+	c.Assert(stats.TotalBytes > 0, check.Equals, true)
+	c.Assert(stats.VolumeCount, check.Equals, -1)
+	_, ok := stats.data()["volumeCount"]
+	c.Assert(ok, check.Equals, false)
+	c.Assert(stats.data()["capacityBytes"], check.Equals, stats.TotalBytes)
+}
+
+func (s *CapacityTestSuite) TestCollectMissingMountRoot(c *check.C) {
+	collector := &capacityCollector{mountRoot: c.MkDir() + "/missing"}
+	_, err := collector.collect()
+	c.Assert(err, check.NotNil)
+}
+
+func (s *CapacityTestSuite) TestLowSpace(c *check.C) {
+	collector := &capacityCollector{mountRoot: "/pool", lowSpacePercent: 10}
+
+	collector.checkLowSpace(&PoolStats{TotalBytes: 100, FreeBytes: 50})
+	c.Assert(collector.lowSpace, check.Equals, false)
+	collector.checkLowSpace(&PoolStats{TotalBytes: 100, FreeBytes: 9})
+	c.Assert(collector.lowSpace, check.Equals, true)
+	collector.checkLowSpace(&PoolStats{TotalBytes: 100, FreeBytes: 10})
+	c.Assert(collector.lowSpace, check.Equals, false)
+}
+
+func (s *CapacityTestSuite) TestStatsChanged(c *check.C) {
+	prev := &PoolStats{TotalBytes: 1000, UsedBytes: 500, FreeBytes: 500, VolumeCount: 3}
+
+	c.Assert(statsChanged(nil, prev), check.Equals, true)
+	c.Assert(statsChanged(prev, nil), check.Equals, false)
+	c.Assert(statsChanged(prev, &PoolStats{TotalBytes: 1000, UsedBytes: 505, VolumeCount: 3}), check.Equals, false)
+	c.Assert(statsChanged(prev, &PoolStats{TotalBytes: 1000, UsedBytes: 490, VolumeCount: 3}), check.Equals, true)
+	c.Assert(statsChanged(prev, &PoolStats{TotalBytes: 1000, UsedBytes: 500, VolumeCount: 4}), check.Equals, true)
+}
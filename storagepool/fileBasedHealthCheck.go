@@ -0,0 +1,92 @@
+package storagepool
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/rancher/convoy-agent/heartbeat"
+)
+
+// heartbeatMisses is how many heartbeat intervals a host may miss before it
+// is no longer counted as a pool member.
+const heartbeatMisses = 3
+
+// fileBasedHealthCheck counts the hosts that have recently written a heartbeat
+// file into a directory shared between them, for drivers such as NFS and
+// gluster where every host mounts the same filesystem.
+//
+// A heartbeat is fresh while its content keeps changing, as seen by this
+// host's clock. The times the hosts write are not compared with this host's
+// clock, so that a host whose clock is off is not dropped from the pool.
+type fileBasedHealthCheck struct {
+	baseDir  string
+	interval time.Duration
+	// observed is the heartbeat of each host as last read, and observedAt
+	// when it was first read with that content.
+	observed   map[string]time.Time
+	observedAt map[string]time.Time
+}
+
+func (f *fileBasedHealthCheck) populateHostMap() (map[string]string, error) {
+	return f.activeHosts(time.Now())
+}
+
+func (f *fileBasedHealthCheck) activeHosts(now time.Time) (map[string]string, error) {
+	beats, err := heartbeat.Read(f.baseDir)
+	if err != nil {
+		return nil, err
+	}
+	if f.observed == nil {
+		f.observed = map[string]time.Time{}
+		f.observedAt = map[string]time.Time{}
+	}
+	for uuid := range f.observed {
+		if _, ok := beats[uuid]; !ok {
+			delete(f.observed, uuid)
+			delete(f.observedAt, uuid)
+		}
+	}
+
+	activeHosts := map[string]string{}
+	for uuid, beat := range beats {
+		if prev, ok := f.observed[uuid]; !ok || !prev.Equal(beat) {
+			f.observed[uuid] = beat
+			f.observedAt[uuid] = now
+		}
+		if unchanged := now.Sub(f.observedAt[uuid]); unchanged > f.staleAfter() {
+			log.Debugf("Heartbeat of host %s is stale, unchanged for %v", uuid, unchanged)
+			continue
+		}
+		activeHosts[uuid] = beat.Format(time.RFC1123Z)
+	}
+	return activeHosts, nil
+}
+
+// staleAfter is how long a heartbeat may go unchanged before its host is no
+// longer counted.
+func (f *fileBasedHealthCheck) staleAfter() time.Duration {
+	return heartbeatMisses * f.interval
+}
+
+// settle watches the heartbeats for long enough to tell which are stale, since
+// every heartbeat counts as fresh when it is first read.
+func (f *fileBasedHealthCheck) settle() error {
+	if _, err := f.populateHostMap(); err != nil {
+		return err
+	}
+	log.Infof("Watching heartbeats in %s for %v to find stale hosts", f.baseDir, f.staleAfter()+f.interval)
+	time.Sleep(f.staleAfter() + f.interval)
+	return nil
+}
+
+// deleteHost leaves the heartbeat file alone, since it belongs to the host
+// that writes it. A stale heartbeat is skipped until it changes again.
+func (f *fileBasedHealthCheck) deleteHost(uuid string) error {
+	return nil
+}
+
+// watch never signals: heartbeats are only picked up on the regular poll.
+func (f *fileBasedHealthCheck) watch(stop <-chan struct{}) <-chan struct{} {
+	return make(chan struct{})
+}
@@ -0,0 +1,55 @@
+package storagepool
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/check.v1"
+
+	"github.com/rancher/convoy-agent/heartbeat"
+)
+
+type FileHealthCheckTestSuite struct {
+}
+
+var _ = check.Suite(&FileHealthCheckTestSuite{})
+
+func (s *FileHealthCheckTestSuite) TestStalenessIgnoresWriterClock(c *check.C) {
+	dir := c.MkDir()
+	hc := &fileBasedHealthCheck{baseDir: dir, interval: time.Second}
+	now := time.Now()
+	// host2's clock is an hour behind, which does not matter while its
+	// heartbeat keeps changing.
+	skewed := now.Add(-time.Hour)
+
+	c.Assert(heartbeat.Write(dir, "host1", now), check.IsNil)
+	c.Assert(heartbeat.Write(dir, "host2", skewed), check.IsNil)
+	hosts, err := hc.activeHosts(now)
+	c.Assert(err, check.IsNil)
+	c.Assert(hosts, check.HasLen, 2)
+
+	// host1 stops beating, host2 keeps going.
+	for i := 1; i <= 4; i++ {
+		c.Assert(heartbeat.Write(dir, "host2", skewed.Add(time.Duration(i)*time.Second)), check.IsNil)
+		hosts, err = hc.activeHosts(now.Add(time.Duration(i) * time.Second))
+		c.Assert(err, check.IsNil)
+	}
+	_, ok := hosts["host1"]
+	c.Assert(ok, check.Equals, false)
+	_, ok = hosts["host2"]
+	c.Assert(ok, check.Equals, true)
+
+	// The stale heartbeat is left for its host to remove.
+	_, err = ioutil.ReadFile(filepath.Join(dir, "host1"))
+	c.Assert(err, check.IsNil)
+	c.Assert(hc.deleteHost("host1"), check.IsNil)
+	_, err = ioutil.ReadFile(filepath.Join(dir, "host1"))
+	c.Assert(err, check.IsNil)
+
+	// A host that beats again is counted again.
+	c.Assert(heartbeat.Write(dir, "host1", now.Add(5*time.Second)), check.IsNil)
+	hosts, err = hc.activeHosts(now.Add(5 * time.Second))
+	c.Assert(err, check.IsNil)
+	c.Assert(hosts, check.HasLen, 2)
+}
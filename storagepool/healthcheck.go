@@ -1,12 +1,71 @@
 package storagepool
 
+import (
+	"fmt"
+	"time"
+
+	"github.com/rancher/go-rancher-metadata/metadata"
+
+	"github.com/rancher/convoy-agent/heartbeat"
+)
+
 type healthChecker interface {
 	populateHostMap() (map[string]string, error)
 	deleteHost(string) error
+	// watch signals on the returned channel whenever the host information
+	// may have changed, until stop is closed.
+	watch(stop <-chan struct{}) <-chan struct{}
+}
+
+// hostLabeler is implemented by health checkers that can look up the labels
+// of the hosts they find, which pools with host selectors need.
+type hostLabeler interface {
+	hostLabels() map[string]map[string]string
+}
+
+// settler is implemented by health checkers that tell live hosts from dead
+// ones by watching them over time. settle returns once they have watched for
+// long enough that a single populateHostMap is right.
+type settler interface {
+	settle() error
+}
+
+const (
+	MetadataHealthCheck = "metadata"
+	FileHealthCheck     = heartbeat.CheckType
+)
+
+// healthCheckConfig selects and configures a healthChecker.
+type healthCheckConfig struct {
+	checkType    string
+	metadataUrl  string
+	interval     time.Duration
+	agentService string
+	baseDir      string
+	// labelHosts is set when host labels are needed to select pool members.
+	labelHosts bool
 }
 
-func newHealthChecker(metadataUrl string) (healthChecker, error) {
-	return &metadataBasedHealthCheck{
-		metadataUrl: metadataUrl,
-	}, nil
+func newHealthChecker(conf healthCheckConfig) (healthChecker, error) {
+	switch conf.checkType {
+	case "", MetadataHealthCheck:
+		return &metadataBasedHealthCheck{
+			client:       metadata.NewClient(conf.metadataUrl),
+			pollInterval: conf.interval,
+			agentService: conf.agentService,
+			labelHosts:   conf.labelHosts,
+		}, nil
+	case FileHealthCheck:
+		if conf.baseDir == "" {
+			return nil, fmt.Errorf("healthcheck-basedir is required for the %s health check", FileHealthCheck)
+		}
+		if conf.labelHosts {
+			return nil, fmt.Errorf("host selectors require the %s health check", MetadataHealthCheck)
+		}
+		return &fileBasedHealthCheck{
+			baseDir:  conf.baseDir,
+			interval: conf.interval,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown health check type %q", conf.checkType)
 }
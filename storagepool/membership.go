@@ -0,0 +1,228 @@
+package storagepool
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// MembershipPolicy controls how quickly hosts join and leave the storage pool
+// once they appear in or disappear from metadata.
+type MembershipPolicy struct {
+	// AddGracePeriod is how long a new host must be seen before it joins.
+	AddGracePeriod time.Duration
+	// RemoveGracePeriod is how long a member must be missing before it leaves.
+	RemoveGracePeriod time.Duration
+	// A host that appears or disappears FlapThreshold times within FlapWindow
+	// is flapping, and must then be seen for FlapHoldDown before rejoining.
+	FlapWindow    time.Duration
+	FlapThreshold int
+	FlapHoldDown  time.Duration
+	// A sync that would remove more than MaxDropPercent of the pool is held
+	// until the same membership is seen DropConfirmations times in a row or
+	// an operator approves it. 0 disables the check.
+	MaxDropPercent    int
+	DropConfirmations int
+}
+
+// membership turns the hosts observed on each poll into the set of hosts to
+// report to Cattle, applying the grace periods and flap damping of a policy.
+type membership struct {
+	policy      MembershipPolicy
+	initialized bool
+	members     map[string]bool
+	// present and since record whether each known host was in the last
+	// observation, and when that last changed.
+	present     map[string]bool
+	since       map[string]time.Time
+	transitions map[string][]time.Time
+}
+
+func newMembership(policy MembershipPolicy) *membership {
+	return &membership{
+		policy:      policy,
+		members:     map[string]bool{},
+		present:     map[string]bool{},
+		since:       map[string]time.Time{},
+		transitions: map[string][]time.Time{},
+	}
+}
+
+// update records the hosts observed at now and returns the current members.
+// The first observation is taken as is, since there is nothing to damp yet.
+func (m *membership) update(observed map[string]bool, now time.Time) map[string]bool {
+	if !m.initialized {
+		m.initialized = true
+		for host := range observed {
+			m.members[host] = true
+			m.present[host] = true
+			m.since[host] = now
+		}
+		return m.snapshot()
+	}
+
+	hosts := map[string]bool{}
+	for host := range m.present {
+		hosts[host] = true
+	}
+	for host := range observed {
+		hosts[host] = true
+	}
+
+	for host := range hosts {
+		seen := observed[host]
+		changed := seen != m.present[host]
+		if changed {
+			m.present[host] = seen
+			m.since[host] = now
+			m.recordTransition(host, now)
+		}
+		m.pruneTransitions(host, now)
+		elapsed := now.Sub(m.since[host])
+
+		switch {
+		case seen && m.members[host]:
+			if changed {
+				log.Infof("Host %s returned before its removal from the storage pool", host)
+			}
+		case seen:
+			grace := m.policy.AddGracePeriod
+			if m.isFlapping(host) && m.policy.FlapHoldDown > grace {
+				grace = m.policy.FlapHoldDown
+				if changed {
+					log.Warnf("Host %s is flapping (%d changes in %v), holding it out of the storage pool for %v", host, len(m.transitions[host]), m.policy.FlapWindow, grace)
+				}
+			}
+			if elapsed >= grace {
+				log.Infof("Adding host %s to the storage pool", host)
+				m.members[host] = true
+			}
+		case m.members[host]:
+			if elapsed >= m.policy.RemoveGracePeriod {
+				log.Infof("Removing host %s from the storage pool", host)
+				delete(m.members, host)
+			} else if changed {
+				log.Warnf("Host %s is missing from metadata, removing it from the storage pool in %v unless it returns", host, m.policy.RemoveGracePeriod)
+			} else {
+				log.Infof("Host %s pending removal from the storage pool in %v", host, m.policy.RemoveGracePeriod-elapsed)
+			}
+		default:
+			if len(m.transitions[host]) == 0 {
+				m.forget(host)
+			}
+		}
+	}
+	return m.snapshot()
+}
+
+func (m *membership) recordTransition(host string, now time.Time) {
+	if m.policy.FlapThreshold <= 0 {
+		return
+	}
+	m.transitions[host] = append(m.transitions[host], now)
+}
+
+func (m *membership) pruneTransitions(host string, now time.Time) {
+	ts := m.transitions[host]
+	i := 0
+	for i < len(ts) && now.Sub(ts[i]) > m.policy.FlapWindow {
+		i++
+	}
+	if i == len(ts) {
+		delete(m.transitions, host)
+		return
+	}
+	m.transitions[host] = ts[i:]
+}
+
+func (m *membership) isFlapping(host string) bool {
+	return m.policy.FlapThreshold > 0 && len(m.transitions[host]) >= m.policy.FlapThreshold
+}
+
+func (m *membership) forget(host string) {
+	delete(m.present, host)
+	delete(m.since, host)
+	delete(m.transitions, host)
+}
+
+func (m *membership) snapshot() map[string]bool {
+	members := make(map[string]bool, len(m.members))
+	for host := range m.members {
+		members[host] = true
+	}
+	return members
+}
+
+// dropGuard holds back syncs that would remove a large share of the pool at
+// once, as happens when metadata briefly returns a partial stack.
+type dropGuard struct {
+	maxDropPercent int
+	confirmations  int
+	pending        map[string]bool
+	seen           int
+}
+
+func newDropGuard(policy MembershipPolicy) *dropGuard {
+	return &dropGuard{
+		maxDropPercent: policy.MaxDropPercent,
+		confirmations:  policy.DropConfirmations,
+	}
+}
+
+// check returns the members to sync: proposed, or prev while a large drop
+// from prev to proposed waits for confirmation or approval.
+func (g *dropGuard) check(prev, proposed map[string]bool, approved bool) map[string]bool {
+	dropped := 0
+	for host := range prev {
+		if !proposed[host] {
+			dropped++
+		}
+	}
+	if g.maxDropPercent <= 0 || len(prev) == 0 || dropped*100 <= g.maxDropPercent*len(prev) {
+		g.reset()
+		return proposed
+	}
+
+	if approved {
+		log.Warnf("Operator approved dropping %d of %d hosts from the storage pool", dropped, len(prev))
+		g.reset()
+		return proposed
+	}
+
+	if g.pending != nil && sameHosts(g.pending, proposed) {
+		g.seen++
+	} else {
+		g.pending = proposed
+		g.seen = 1
+	}
+	if g.confirmations > 0 && g.seen >= g.confirmations {
+		log.Warnf("Dropping %d of %d hosts from the storage pool after seeing the drop %d times", dropped, len(prev), g.seen)
+		g.reset()
+		return proposed
+	}
+
+	wait := "it is approved"
+	if g.confirmations > 0 {
+		wait = fmt.Sprintf("the drop is seen %d more times or approved", g.confirmations-g.seen)
+	}
+	log.Warnf("Storage pool sync would drop %d of %d hosts, more than the %d%% allowed. Holding current members until %s", dropped, len(prev), g.maxDropPercent, wait)
+	return prev
+}
+
+func (g *dropGuard) reset() {
+	g.pending = nil
+	g.seen = 0
+}
+
+func sameHosts(a, b map[string]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for host := range a {
+		if !b[host] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,114 @@
+package storagepool
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type MembershipTestSuite struct {
+}
+
+var _ = check.Suite(&MembershipTestSuite{})
+
+func hosts(uuids ...string) map[string]bool {
+	m := map[string]bool{}
+	for _, uuid := range uuids {
+		m[uuid] = true
+	}
+	return m
+}
+
+func (s *MembershipTestSuite) TestNoPolicyAppliesChangesImmediately(c *check.C) {
+	m := newMembership(MembershipPolicy{})
+	now := time.Now()
+
+	c.Assert(m.update(hosts("a", "b"), now), check.DeepEquals, hosts("a", "b"))
+	c.Assert(m.update(hosts("a", "c"), now.Add(time.Second)), check.DeepEquals, hosts("a", "c"))
+}
+
+func (s *MembershipTestSuite) TestRemoveGracePeriod(c *check.C) {
+	m := newMembership(MembershipPolicy{RemoveGracePeriod: 30 * time.Second})
+	now := time.Now()
+
+	c.Assert(m.update(hosts("a", "b"), now), check.DeepEquals, hosts("a", "b"))
+
+	// A short glitch does not remove the host.
+	c.Assert(m.update(hosts("a"), now.Add(5*time.Second)), check.DeepEquals, hosts("a", "b"))
+	c.Assert(m.update(hosts("a", "b"), now.Add(10*time.Second)), check.DeepEquals, hosts("a", "b"))
+
+	// A host missing for the whole grace period is removed.
+	c.Assert(m.update(hosts("a"), now.Add(20*time.Second)), check.DeepEquals, hosts("a", "b"))
+	c.Assert(m.update(hosts("a"), now.Add(49*time.Second)), check.DeepEquals, hosts("a", "b"))
+	c.Assert(m.update(hosts("a"), now.Add(50*time.Second)), check.DeepEquals, hosts("a"))
+}
+
+func (s *MembershipTestSuite) TestAddGracePeriod(c *check.C) {
+	m := newMembership(MembershipPolicy{AddGracePeriod: 10 * time.Second})
+	now := time.Now()
+
+	// Hosts seen at startup join straight away.
+	c.Assert(m.update(hosts("a"), now), check.DeepEquals, hosts("a"))
+
+	c.Assert(m.update(hosts("a", "b"), now.Add(time.Second)), check.DeepEquals, hosts("a"))
+	c.Assert(m.update(hosts("a", "b"), now.Add(5*time.Second)), check.DeepEquals, hosts("a"))
+	c.Assert(m.update(hosts("a", "b"), now.Add(11*time.Second)), check.DeepEquals, hosts("a", "b"))
+}
+
+func (s *MembershipTestSuite) TestFlappingHostIsHeldDown(c *check.C) {
+	m := newMembership(MembershipPolicy{
+		FlapWindow:    time.Minute,
+		FlapThreshold: 3,
+		FlapHoldDown:  time.Minute,
+	})
+	now := time.Now()
+
+	c.Assert(m.update(hosts("a", "b"), now), check.DeepEquals, hosts("a", "b"))
+	c.Assert(m.update(hosts("a"), now.Add(1*time.Second)), check.DeepEquals, hosts("a"))
+	c.Assert(m.update(hosts("a", "b"), now.Add(2*time.Second)), check.DeepEquals, hosts("a", "b"))
+	c.Assert(m.update(hosts("a"), now.Add(3*time.Second)), check.DeepEquals, hosts("a"))
+
+	// The third change makes b flapping, so it is held out of the pool.
+	c.Assert(m.update(hosts("a", "b"), now.Add(4*time.Second)), check.DeepEquals, hosts("a"))
+	c.Assert(m.update(hosts("a", "b"), now.Add(30*time.Second)), check.DeepEquals, hosts("a"))
+	c.Assert(m.update(hosts("a", "b"), now.Add(64*time.Second)), check.DeepEquals, hosts("a", "b"))
+}
+
+func (s *MembershipTestSuite) TestDropGuardWaitsForConfirmation(c *check.C) {
+	g := newDropGuard(MembershipPolicy{MaxDropPercent: 50, DropConfirmations: 3})
+	prev := hosts("a", "b", "c", "d")
+
+	// Small drops go straight through.
+	c.Assert(g.check(prev, hosts("a", "b", "c"), false), check.DeepEquals, hosts("a", "b", "c"))
+	c.Assert(g.check(prev, hosts("a", "b"), false), check.DeepEquals, hosts("a", "b"))
+
+	c.Assert(g.check(prev, hosts("a"), false), check.DeepEquals, prev)
+	c.Assert(g.check(prev, hosts("a"), false), check.DeepEquals, prev)
+	c.Assert(g.check(prev, hosts("a"), false), check.DeepEquals, hosts("a"))
+}
+
+func (s *MembershipTestSuite) TestDropGuardResetsWhenMembershipChanges(c *check.C) {
+	g := newDropGuard(MembershipPolicy{MaxDropPercent: 50, DropConfirmations: 2})
+	prev := hosts("a", "b", "c", "d")
+
+	c.Assert(g.check(prev, hosts("a"), false), check.DeepEquals, prev)
+	c.Assert(g.check(prev, hosts("b"), false), check.DeepEquals, prev)
+	c.Assert(g.check(prev, prev, false), check.DeepEquals, prev)
+	c.Assert(g.check(prev, hosts("b"), false), check.DeepEquals, prev)
+	c.Assert(g.check(prev, hosts("b"), false), check.DeepEquals, hosts("b"))
+}
+
+func (s *MembershipTestSuite) TestDropGuardApproval(c *check.C) {
+	g := newDropGuard(MembershipPolicy{MaxDropPercent: 10})
+	prev := hosts("a", "b")
+
+	c.Assert(g.check(prev, map[string]bool{}, false), check.DeepEquals, prev)
+	c.Assert(g.check(prev, map[string]bool{}, false), check.DeepEquals, prev)
+	c.Assert(g.check(prev, map[string]bool{}, true), check.DeepEquals, map[string]bool{})
+}
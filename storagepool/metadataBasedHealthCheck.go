@@ -1,21 +1,66 @@
 package storagepool
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/url"
 	"time"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/rancher/go-rancher-metadata/metadata"
 )
 
+// metadataWaitSeconds is how long the metadata server may hold a version
+// request open waiting for a change.
+const metadataWaitSeconds = 60
+
 type metadataBasedHealthCheck struct {
-	version     string
-	prevHosts   map[string]string
-	metadataUrl string
+	version      string
+	prevHosts    map[string]string
+	client       *metadata.Client
+	pollInterval time.Duration
+	agentService string
+	labelHosts   bool
+	labels       map[string]map[string]string
 }
 
-func (mt *metadataBasedHealthCheck) populateHostMap() (map[string]string, error) {
-	m := metadata.NewClient(mt.metadataUrl)
+// The vendored metadata types do not carry container state, so the self stack
+// is decoded into these instead.
+type agentStack struct {
+	Name     string         `json:"name"`
+	Services []agentService `json:"services"`
+}
+
+type agentService struct {
+	Name       string           `json:"name"`
+	Containers []agentContainer `json:"containers"`
+}
+
+type agentContainer struct {
+	Name        string `json:"name"`
+	HostUUID    string `json:"host_uuid"`
+	State       string `json:"state"`
+	HealthState string `json:"health_state"`
+}
+
+// isHealthy reports whether a container should make its host a pool member.
+// Metadata that does not report state or health is trusted, as are containers
+// without a health check.
+func (c agentContainer) isHealthy() bool {
+	switch c.State {
+	case "", "running":
+	default:
+		return false
+	}
+	switch c.HealthState {
+	case "", "healthy", "updating-healthy":
+		return true
+	}
+	return false
+}
 
-	version, err := m.GetVersion()
+func (mt *metadataBasedHealthCheck) populateHostMap() (map[string]string, error) {
+	version, err := mt.client.GetVersion()
 	if err != nil {
 		return nil, err
 	}
@@ -25,23 +70,102 @@ func (mt *metadataBasedHealthCheck) populateHostMap() (map[string]string, error)
 
 	activeHosts := map[string]string{}
 	timeStamp := time.Now().Format(time.RFC1123Z)
-	stack, err := m.GetSelfStack()
+	resp, err := mt.client.SendRequest("/self/stack")
 	if err != nil {
 		return nil, err
 	}
+	stack := agentStack{}
+	if err := json.Unmarshal(resp, &stack); err != nil {
+		return nil, err
+	}
 
 	for _, svc := range stack.Services {
+		if mt.agentService != "" && svc.Name != mt.agentService {
+			continue
+		}
 		for _, c := range svc.Containers {
+			if c.HostUUID == "" {
+				continue
+			}
+			if !c.isHealthy() {
+				log.Debugf("Not counting host %s: container %s is %s/%s", c.HostUUID, c.Name, c.State, c.HealthState)
+				continue
+			}
 			activeHosts[c.HostUUID] = timeStamp
 		}
 	}
 
+	if mt.labelHosts {
+		hosts, err := mt.client.GetHosts()
+		if err != nil {
+			return nil, err
+		}
+		labels := map[string]map[string]string{}
+		for _, host := range hosts {
+			labels[host.UUID] = host.Labels
+		}
+		mt.labels = labels
+	}
+
 	mt.prevHosts = activeHosts
 	mt.version = version
 	return activeHosts, nil
 }
 
+// hostLabels returns the labels of every host in metadata as of the last
+// populateHostMap, keyed by host UUID.
+func (mt *metadataBasedHealthCheck) hostLabels() map[string]map[string]string {
+	return mt.labels
+}
+
 func (mt *metadataBasedHealthCheck) deleteHost(uuid string) error {
 	//NoOp
 	return nil
 }
+
+// watch long-polls the metadata version and signals when it changes. Metadata
+// servers that do not support waiting answer immediately, in which case the
+// version is polled every pollInterval instead.
+func (mt *metadataBasedHealthCheck) watch(stop <-chan struct{}) <-chan struct{} {
+	changed := make(chan struct{}, 1)
+
+	go func() {
+		version := ""
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			newVersion, err := mt.waitForVersionChange(version)
+			if err != nil {
+				log.Errorf("Error waiting for metadata changes [%v]", err)
+			} else if newVersion != version {
+				version = newVersion
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+				continue
+			}
+
+			select {
+			case <-stop:
+				return
+			case <-time.After(mt.pollInterval):
+			}
+		}
+	}()
+
+	return changed
+}
+
+func (mt *metadataBasedHealthCheck) waitForVersionChange(version string) (string, error) {
+	path := fmt.Sprintf("/version?wait=true&value=%s&maxWait=%d", url.QueryEscape(version), metadataWaitSeconds)
+	resp, err := mt.client.SendRequest(path)
+	if err != nil {
+		return "", err
+	}
+	return string(resp), nil
+}
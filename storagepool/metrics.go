@@ -0,0 +1,12 @@
+package storagepool
+
+import (
+	"github.com/rancher/convoy-agent/metrics"
+)
+
+var (
+	poolHosts = metrics.NewGauge("convoy_agent_pool_hosts",
+		"Hosts in each storage pool, as last reported to Cattle.", "pool")
+	poolSyncs = metrics.NewCounter("convoy_agent_pool_syncs_total",
+		"Storage pool syncs to Cattle, by pool and result (ok or failed).", "pool", "result")
+)
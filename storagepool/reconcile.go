@@ -0,0 +1,321 @@
+package storagepool
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/rancher/convoy/api"
+
+	"github.com/rancher/convoy-agent/cattle"
+	"github.com/rancher/convoy-agent/volume"
+)
+
+// Exit codes of the reconcile command. Drift is reported like diff reports
+// differences, so that the command can run as a periodic check.
+const (
+	reconcileInSync = 0
+	reconcileDrift  = 1
+	reconcileFailed = 2
+)
+
+// Drift is how a storage pool in Cattle differs from what convoy and metadata
+// show.
+type Drift struct {
+	Driver string
+	// PoolMissing is set if Cattle has no storage pool for the driver.
+	PoolMissing bool
+	// MissingVolumes are in convoy but not in Cattle, and StaleVolumes are
+	// in Cattle but no longer in convoy.
+	MissingVolumes []string
+	StaleVolumes   []string
+	// MissingHosts are pool members Cattle does not list, and StaleHosts are
+	// listed by Cattle but are not members.
+	MissingHosts []string
+	StaleHosts   []string
+	// Members are the hosts the pool should have.
+	Members []string
+}
+
+// Empty reports whether the pool is in sync.
+func (d Drift) Empty() bool {
+	return !d.PoolMissing && len(d.MissingVolumes) == 0 && len(d.StaleVolumes) == 0 &&
+		len(d.MissingHosts) == 0 && len(d.StaleHosts) == 0
+}
+
+func (d Drift) hostsDrifted() bool {
+	return d.PoolMissing || len(d.MissingHosts) > 0 || len(d.StaleHosts) > 0
+}
+
+// recordedHosts returns the hosts Cattle lists for the pool.
+func (d Drift) recordedHosts() map[string]bool {
+	hosts := map[string]bool{}
+	for _, uuid := range d.Members {
+		hosts[uuid] = true
+	}
+	for _, uuid := range d.MissingHosts {
+		delete(hosts, uuid)
+	}
+	for _, uuid := range d.StaleHosts {
+		hosts[uuid] = true
+	}
+	return hosts
+}
+
+// comparePool compares what Cattle has for a pool with its members and, for
+// the pool the volume agents report to, the volumes in convoy. Volumes are not
+// compared if volumes is nil.
+func comparePool(driver string, inventory cattle.PoolInventory, members, volumes []string) Drift {
+	drift := Drift{
+		Driver:       driver,
+		PoolMissing:  !inventory.Found,
+		MissingHosts: difference(members, inventory.HostUuids),
+		StaleHosts:   difference(inventory.HostUuids, members),
+		Members:      sortedCopy(members),
+	}
+	if volumes != nil {
+		drift.MissingVolumes = difference(volumes, inventory.Volumes)
+		drift.StaleVolumes = difference(inventory.Volumes, volumes)
+	}
+	return drift
+}
+
+// difference returns the sorted items of a that are not in b.
+func difference(a, b []string) []string {
+	inB := map[string]bool{}
+	for _, s := range b {
+		inB[s] = true
+	}
+	diff := []string{}
+	for _, s := range a {
+		if !inB[s] {
+			diff = append(diff, s)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+func sortedCopy(s []string) []string {
+	c := append([]string{}, s...)
+	sort.Strings(c)
+	return c
+}
+
+// convoyVolumes returns the names of the volumes in convoy that the volume
+// agents report to Cattle, leaving out quarantined ones.
+func convoyVolumes(vols volume.Volume) []string {
+	names := []string{}
+	for _, vol := range vols {
+		if volume.IsQuarantineName(vol.Name) {
+			continue
+		}
+		names = append(names, vol.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func writeDrift(w io.Writer, drifts []Drift) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "POOL\tKIND\tNAME\tDRIFT")
+	for _, d := range drifts {
+		if d.PoolMissing {
+			fmt.Fprintf(tw, "%s\tpool\t%s\tmissing in cattle\n", d.Driver, d.Driver)
+		}
+		for _, name := range d.MissingVolumes {
+			fmt.Fprintf(tw, "%s\tvolume\t%s\tmissing in cattle\n", d.Driver, name)
+		}
+		for _, name := range d.StaleVolumes {
+			fmt.Fprintf(tw, "%s\tvolume\t%s\tnot in convoy\n", d.Driver, name)
+		}
+		for _, uuid := range d.MissingHosts {
+			fmt.Fprintf(tw, "%s\thost\t%s\tmissing in cattle\n", d.Driver, uuid)
+		}
+		for _, uuid := range d.StaleHosts {
+			fmt.Fprintf(tw, "%s\thost\t%s\tnot a member\n", d.Driver, uuid)
+		}
+	}
+	tw.Flush()
+}
+
+// repair sends the events that bring Cattle in line with a pool's drift. The
+// pool is synced first, so that it exists before volume events name it, with
+// the description and data the storagepool agent would report. Like the
+// agent, it does not sync hosts if that would drop more than maxDropPercent
+// of the hosts Cattle lists. It returns the number of events that failed or
+// were held back.
+func repair(cattleClient cattle.CattleInterface, d Drift, pool cattle.StoragePool, vols volume.Volume, maxDropPercent int) int {
+	failed := 0
+	if d.hostsDrifted() {
+		members := map[string]bool{}
+		for _, uuid := range d.Members {
+			members[uuid] = true
+		}
+		guard := newDropGuard(MembershipPolicy{MaxDropPercent: maxDropPercent})
+		if !sameHosts(guard.check(d.recordedHosts(), members, false), members) {
+			log.Errorf("Not syncing storage pool %s, run with a higher max-drop-percent to drop %d hosts", d.Driver, len(d.StaleHosts))
+			failed++
+		} else if err := cattleClient.SyncStoragePool(pool, d.Members); err != nil {
+			log.Errorf("Error syncing storage pool %s [%v]", d.Driver, err)
+			failed++
+		} else {
+			log.Infof("Synced storage pool %s with %d hosts", d.Driver, len(d.Members))
+		}
+	}
+	for _, name := range d.MissingVolumes {
+		if err := cattleClient.CreateVolume(d.Driver, vols[name]); err != nil {
+			log.Errorf("Error sending create event for volume %s [%v]", name, err)
+			failed++
+		} else {
+			log.Infof("Sent create event for volume %s", name)
+		}
+	}
+	for _, name := range d.StaleVolumes {
+		if err := cattleClient.DeleteVolume(d.Driver, api.VolumeResponse{Name: name}); err != nil {
+			log.Errorf("Error sending delete event for volume %s [%v]", name, err)
+			failed++
+		} else {
+			log.Infof("Sent delete event for volume %s", name)
+		}
+	}
+	return failed
+}
+
+func reconcileFail(err error) {
+	log.Error(err)
+	os.Exit(reconcileFailed)
+}
+
+func reconcile(c *cli.Context) {
+	if c.GlobalBool("debug") {
+		log.SetLevel(log.DebugLevel)
+	}
+	if c.Bool("dry-run") && c.Bool("apply") {
+		reconcileFail(fmt.Errorf("dry-run and apply cannot be combined"))
+	}
+	apply := c.Bool("apply")
+
+	driver := c.GlobalString("storagepool-driver")
+	if driver == "" && len(c.StringSlice("pool")) == 0 {
+		reconcileFail(fmt.Errorf("required field storagepool-driver has not been set"))
+	}
+	pools, err := poolsFromContext(c, driver)
+	if err != nil {
+		reconcileFail(err)
+	}
+
+	namer, err := volume.VolumeNamerFromContext(c)
+	if err != nil {
+		reconcileFail(err)
+	}
+	cattleClient, err := cattle.NewCattleClient(c.GlobalString("url"), c.GlobalString("access-key"), c.GlobalString("secret-key"))
+	if err != nil {
+		reconcileFail(err)
+	}
+	cattleClient.SetVolumeNamer(namer)
+
+	// The pools are described by an agent configured like the storagepool
+	// agent, so that repairs report the same capabilities and capacity.
+	agent := NewStoragepoolAgent(0, "", driver, cattleClient)
+	if err := setPoolReporting(c, agent, driver, c.GlobalString("socket")); err != nil {
+		reconcileFail(err)
+	}
+
+	labelHosts := false
+	for _, pool := range pools {
+		if !pool.Selector.Empty() {
+			labelHosts = true
+		}
+	}
+	hc, err := newHealthChecker(healthCheckConfig{
+		checkType:    c.GlobalString("healthcheck-type"),
+		metadataUrl:  c.String("storagepool-metadata-url"),
+		interval:     time.Duration(c.GlobalInt("healthcheck-interval")) * time.Millisecond,
+		agentService: c.String("agent-service"),
+		baseDir:      c.GlobalString("healthcheck-basedir"),
+		labelHosts:   labelHosts,
+	})
+	if err != nil {
+		reconcileFail(err)
+	}
+	if s, ok := hc.(settler); ok {
+		if err := s.settle(); err != nil {
+			reconcileFail(fmt.Errorf("Cannot read pool members. Error: %v", err))
+		}
+	}
+	currHosts, err := hc.populateHostMap()
+	if err != nil {
+		reconcileFail(fmt.Errorf("Cannot read pool members. Error: %v", err))
+	}
+	var labels map[string]map[string]string
+	if l, ok := hc.(hostLabeler); ok {
+		labels = l.hostLabels()
+	}
+
+	// Volume agents report every convoy volume to the pool named by
+	// storagepool-driver, so only that pool's volumes are compared. Each host
+	// has its own volumes with a host-local driver, and the ones this host's
+	// convoy lists are not the pool's, so they are only compared if the pool
+	// is known to be shared.
+	var vols volume.Volume
+	if caps := agent.capabilities[driver]; driver != "" && (caps == nil || !caps.Shared) {
+		log.Warnf("Not comparing volumes of pool %s, its convoy driver is not known to be shared. Set convoy-drivers or pool-capability shared=true to compare them", driver)
+	} else if driver != "" {
+		convoyClient, err := volume.NewConvoyClient(c.GlobalString("socket"))
+		if err != nil {
+			reconcileFail(err)
+		}
+		vols, err = convoyClient.GetCurrVolumes()
+		if err != nil {
+			reconcileFail(fmt.Errorf("Cannot list convoy volumes. Error: %v", err))
+		}
+	}
+
+	drifts := []Drift{}
+	for _, pool := range pools {
+		members := []string{}
+		for uuid := range currHosts {
+			if pool.Selector.Empty() || pool.Selector.Matches(labels[uuid]) {
+				members = append(members, uuid)
+			}
+		}
+		inventory, err := cattleClient.GetStoragePool(pool.Driver)
+		if err != nil {
+			reconcileFail(err)
+		}
+		var volumes []string
+		if vols != nil && pool.Driver == driver {
+			volumes = convoyVolumes(vols)
+		}
+		drift := comparePool(pool.Driver, inventory, members, volumes)
+		if !drift.Empty() {
+			drifts = append(drifts, drift)
+		}
+	}
+
+	if len(drifts) == 0 {
+		fmt.Println("No drift found")
+		os.Exit(reconcileInSync)
+	}
+	writeDrift(os.Stdout, drifts)
+	if !apply {
+		os.Exit(reconcileDrift)
+	}
+
+	failed := 0
+	for _, d := range drifts {
+		failed += repair(cattleClient, d, agent.pool(d.Driver, agent.stats(d.Driver)), vols, c.Int("max-drop-percent"))
+	}
+	if failed > 0 {
+		log.Errorf("%d corrective events failed", failed)
+		os.Exit(reconcileDrift)
+	}
+	log.Info("All drift repaired")
+	os.Exit(reconcileInSync)
+}
@@ -0,0 +1,132 @@
+package storagepool
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/rancher/convoy/api"
+	"gopkg.in/check.v1"
+
+	"github.com/rancher/convoy-agent/cattle"
+	"github.com/rancher/convoy-agent/volume"
+)
+
+type ReconcileTestSuite struct {
+}
+
+var _ = check.Suite(&ReconcileTestSuite{})
+
+func (s *ReconcileTestSuite) TestInSync(c *check.C) {
+	inventory := cattle.PoolInventory{
+		Found:     true,
+		HostUuids: []string{"h1", "h2"},
+		Volumes:   []string{"v1"},
+	}
+	drift := comparePool("gluster", inventory, []string{"h2", "h1"}, []string{"v1"})
+	c.Assert(drift.Empty(), check.Equals, true)
+	c.Assert(drift.Members, check.DeepEquals, []string{"h1", "h2"})
+}
+
+func (s *ReconcileTestSuite) TestDrift(c *check.C) {
+	inventory := cattle.PoolInventory{
+		Found:     true,
+		HostUuids: []string{"h1", "h3"},
+		Volumes:   []string{"v1", "v3"},
+	}
+	drift := comparePool("gluster", inventory, []string{"h2", "h1"}, []string{"v2", "v1"})
+	c.Assert(drift.Empty(), check.Equals, false)
+	c.Assert(drift.PoolMissing, check.Equals, false)
+	c.Assert(drift.MissingVolumes, check.DeepEquals, []string{"v2"})
+	c.Assert(drift.StaleVolumes, check.DeepEquals, []string{"v3"})
+	c.Assert(drift.MissingHosts, check.DeepEquals, []string{"h2"})
+	c.Assert(drift.StaleHosts, check.DeepEquals, []string{"h3"})
+}
+
+func (s *ReconcileTestSuite) TestMissingPool(c *check.C) {
+	inventory := cattle.PoolInventory{HostUuids: []string{}, Volumes: []string{}}
+	drift := comparePool("gluster", inventory, []string{}, []string{})
+	c.Assert(drift.PoolMissing, check.Equals, true)
+	c.Assert(drift.Empty(), check.Equals, false)
+	c.Assert(drift.hostsDrifted(), check.Equals, true)
+}
+
+func (s *ReconcileTestSuite) TestVolumesNotCompared(c *check.C) {
+	inventory := cattle.PoolInventory{Found: true, HostUuids: []string{"h1"}, Volumes: []string{"v1"}}
+	drift := comparePool("gluster-a", inventory, []string{"h1"}, nil)
+	c.Assert(drift.Empty(), check.Equals, true)
+}
+
+func (s *ReconcileTestSuite) TestQuarantinedVolumesSkipped(c *check.C) {
+	vols := volume.Volume{
+		"quarantined--v2--1": api.VolumeResponse{Name: "quarantined--v2--1"},
+		"v2":                 api.VolumeResponse{Name: "v2"},
+		"v1":                 api.VolumeResponse{Name: "v1"},
+	}
+	c.Assert(convoyVolumes(vols), check.DeepEquals, []string{"v1", "v2"})
+}
+
+func (s *ReconcileTestSuite) TestWriteDrift(c *check.C) {
+	buf := &bytes.Buffer{}
+	writeDrift(buf, []Drift{{
+		Driver:         "gluster",
+		MissingVolumes: []string{"v2"},
+		StaleHosts:     []string{"h3"},
+	}})
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	c.Assert(lines, check.HasLen, 3)
+	c.Assert(strings.Fields(lines[1]), check.DeepEquals, []string{"gluster", "volume", "v2", "missing", "in", "cattle"})
+	c.Assert(strings.Fields(lines[2]), check.DeepEquals, []string{"gluster", "host", "h3", "not", "a", "member"})
+}
+
+// recordingCattle records the events sent to Cattle.
+type recordingCattle struct {
+	events []string
+	pools  []cattle.StoragePool
+}
+
+func (r *recordingCattle) CreateVolume(driver string, vol api.VolumeResponse) error {
+	r.events = append(r.events, "create "+vol.Name)
+	return nil
+}
+
+func (r *recordingCattle) DeleteVolume(driver string, vol api.VolumeResponse) error {
+	r.events = append(r.events, "delete "+vol.Name)
+	return nil
+}
+
+func (r *recordingCattle) SyncStoragePool(pool cattle.StoragePool, hostUuids []string) error {
+	r.events = append(r.events, "sync "+strings.Join(hostUuids, ","))
+	r.pools = append(r.pools, pool)
+	return nil
+}
+
+func (s *ReconcileTestSuite) TestRepairReportsPoolLikeAgent(c *check.C) {
+	agent := NewStoragepoolAgent(0, "", "nfs", nil)
+	caps, err := CapabilitiesFor([]string{"vfs"})
+	c.Assert(err, check.IsNil)
+	agent.SetCapabilities("nfs", caps)
+	pool := agent.pool("nfs", &PoolStats{TotalBytes: 100, UsedBytes: 40, FreeBytes: 60})
+
+	inventory := cattle.PoolInventory{Found: true, HostUuids: []string{"h1"}, Volumes: []string{"old"}}
+	drift := comparePool("nfs", inventory, []string{"h1", "h2"}, []string{"new"})
+	vols := volume.Volume{"new": api.VolumeResponse{Name: "new"}}
+
+	rc := &recordingCattle{}
+	c.Assert(repair(rc, drift, pool, vols, 50), check.Equals, 0)
+	c.Assert(rc.events, check.DeepEquals, []string{"sync h1,h2", "create new", "delete old"})
+	c.Assert(rc.pools, check.DeepEquals, []cattle.StoragePool{pool})
+	c.Assert(rc.pools[0].Description, check.Not(check.Equals), "")
+	c.Assert(rc.pools[0].Data["capabilities"], check.NotNil)
+}
+
+func (s *ReconcileTestSuite) TestRepairHonoursMaxDropPercent(c *check.C) {
+	inventory := cattle.PoolInventory{Found: true, HostUuids: []string{"h1", "h2", "h3", "h4"}}
+	drift := comparePool("nfs", inventory, []string{"h1"}, nil)
+
+	rc := &recordingCattle{}
+	c.Assert(repair(rc, drift, cattle.StoragePool{Driver: "nfs"}, nil, 50), check.Equals, 1)
+	c.Assert(rc.events, check.HasLen, 0)
+
+	c.Assert(repair(rc, drift, cattle.StoragePool{Driver: "nfs"}, nil, 0), check.Equals, 0)
+	c.Assert(rc.events, check.DeepEquals, []string{"sync h1"})
+}
@@ -0,0 +1,111 @@
+package storagepool
+
+import (
+	"fmt"
+	"strings"
+)
+
+// HostSelector picks hosts by their labels. It is a comma separated list of
+// terms that must all hold: key=value, key!=value, or key alone for a label
+// that must be present with any value. The empty selector matches every host.
+type HostSelector struct {
+	terms []selectorTerm
+}
+
+type selectorTerm struct {
+	key    string
+	value  string
+	negate bool
+	exists bool
+}
+
+func ParseHostSelector(s string) (HostSelector, error) {
+	selector := HostSelector{}
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		t := selectorTerm{}
+		if i := strings.Index(term, "!="); i >= 0 {
+			t.key, t.value, t.negate = term[:i], term[i+2:], true
+		} else if i := strings.Index(term, "="); i >= 0 {
+			t.key, t.value = term[:i], term[i+1:]
+		} else {
+			t.key, t.exists = term, true
+		}
+		t.key = strings.TrimSpace(t.key)
+		t.value = strings.TrimSpace(t.value)
+		if t.key == "" {
+			return HostSelector{}, fmt.Errorf("Invalid host selector %q: missing label key in %q", s, term)
+		}
+		selector.terms = append(selector.terms, t)
+	}
+	return selector, nil
+}
+
+// Empty reports whether the selector matches every host.
+func (h HostSelector) Empty() bool {
+	return len(h.terms) == 0
+}
+
+func (h HostSelector) Matches(labels map[string]string) bool {
+	for _, t := range h.terms {
+		value, ok := labels[t.key]
+		switch {
+		case t.exists:
+			if !ok {
+				return false
+			}
+		case t.negate:
+			if ok && value == t.value {
+				return false
+			}
+		default:
+			if !ok || value != t.value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (h HostSelector) String() string {
+	terms := []string{}
+	for _, t := range h.terms {
+		switch {
+		case t.exists:
+			terms = append(terms, t.key)
+		case t.negate:
+			terms = append(terms, t.key+"!="+t.value)
+		default:
+			terms = append(terms, t.key+"="+t.value)
+		}
+	}
+	return strings.Join(terms, ",")
+}
+
+// Pool is a storage pool managed by the agent: the hosts matching Selector
+// are reported to Cattle as members of the pool named Driver.
+type Pool struct {
+	Driver   string
+	Selector HostSelector
+}
+
+// ParsePool parses a pool given as driver[:selector].
+func ParsePool(s string) (Pool, error) {
+	parts := strings.SplitN(s, ":", 2)
+	pool := Pool{Driver: strings.TrimSpace(parts[0])}
+	if pool.Driver == "" {
+		return Pool{}, fmt.Errorf("Invalid pool %q: missing driver name", s)
+	}
+	if len(parts) == 2 {
+		selector, err := ParseHostSelector(parts[1])
+		if err != nil {
+			return Pool{}, err
+		}
+		pool.Selector = selector
+	}
+	return pool, nil
+}
@@ -0,0 +1,49 @@
+package storagepool
+
+import (
+	"gopkg.in/check.v1"
+)
+
+type SelectorTestSuite struct {
+}
+
+var _ = check.Suite(&SelectorTestSuite{})
+
+func (s *SelectorTestSuite) TestMatches(c *check.C) {
+	selector, err := ParseHostSelector("storage.zone=a, ssd, tier!=cold")
+	c.Assert(err, check.IsNil)
+	c.Assert(selector.String(), check.Equals, "storage.zone=a,ssd,tier!=cold")
+
+	c.Assert(selector.Matches(map[string]string{"storage.zone": "a", "ssd": ""}), check.Equals, true)
+	c.Assert(selector.Matches(map[string]string{"storage.zone": "a", "ssd": "", "tier": "hot"}), check.Equals, true)
+	c.Assert(selector.Matches(map[string]string{"storage.zone": "a", "ssd": "", "tier": "cold"}), check.Equals, false)
+	c.Assert(selector.Matches(map[string]string{"storage.zone": "b", "ssd": ""}), check.Equals, false)
+	c.Assert(selector.Matches(map[string]string{"storage.zone": "a"}), check.Equals, false)
+	c.Assert(selector.Matches(nil), check.Equals, false)
+}
+
+func (s *SelectorTestSuite) TestEmptySelectorMatchesAll(c *check.C) {
+	selector, err := ParseHostSelector(" ")
+	c.Assert(err, check.IsNil)
+	c.Assert(selector.Empty(), check.Equals, true)
+	c.Assert(selector.Matches(nil), check.Equals, true)
+}
+
+func (s *SelectorTestSuite) TestInvalidSelector(c *check.C) {
+	_, err := ParseHostSelector("zone=a,=b")
+	c.Assert(err, check.ErrorMatches, "Invalid host selector .*")
+}
+
+func (s *SelectorTestSuite) TestParsePool(c *check.C) {
+	pool, err := ParsePool("gluster-a:storage.zone=a")
+	c.Assert(err, check.IsNil)
+	c.Assert(pool.Driver, check.Equals, "gluster-a")
+	c.Assert(pool.Selector.String(), check.Equals, "storage.zone=a")
+
+	pool, err = ParsePool("gluster")
+	c.Assert(err, check.IsNil)
+	c.Assert(pool.Selector.Empty(), check.Equals, true)
+
+	_, err = ParsePool(":zone=a")
+	c.Assert(err, check.ErrorMatches, "Invalid pool .*")
+}
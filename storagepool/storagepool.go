@@ -1,27 +1,185 @@
 package storagepool
 
 import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	log "github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
 
+	"github.com/rancher/convoy-agent/admin"
 	"github.com/rancher/convoy-agent/cattle"
 	"github.com/rancher/convoy-agent/cattleevents"
+	"github.com/rancher/convoy-agent/config"
+	"github.com/rancher/convoy-agent/health"
+	"github.com/rancher/convoy-agent/identity"
+	"github.com/rancher/convoy-agent/leader"
+	"github.com/rancher/convoy-agent/volume"
+)
+
+const quarantinePurgeInterval = time.Minute
+
+// cattleCheckTTL is how often the health endpoints may call Cattle's API.
+const cattleCheckTTL = 10 * time.Second
+
+// Flags that select a pool's members, shared by the storagepool and reconcile
+// commands.
+var (
+	metadataUrlFlag = cli.StringFlag{
+		Name:  "storagepool-metadata-url",
+		Usage: "set the metadata url",
+		Value: "http://rancher-metadata/2015-12-19",
+	}
+
+	agentServiceFlag = cli.StringFlag{
+		Name:  "agent-service",
+		Usage: "only count hosts running a healthy container of this service in the stack as pool members. Defaults to all services in the stack",
+	}
+
+	hostSelectorFlag = cli.StringFlag{
+		Name:  "host-selector",
+		Usage: "only count hosts whose labels match this selector as pool members, e.g. storage.zone=a. Terms are key=value, key!=value or key, separated by commas",
+	}
+
+	poolFlag = cli.StringSliceFlag{
+		Name:  "pool",
+		Value: &cli.StringSlice{},
+		Usage: "manage a pool given as driver[:selector] instead of the one named by storagepool-driver. Can be repeated",
+	}
+
+	maxDropPercentFlag = cli.IntFlag{
+		Name:  "max-drop-percent",
+		Value: 50,
+		Usage: "hold syncs that would remove more than this percentage of the pool's hosts at once. 0 disables the check",
+	}
+)
+
+// Flags that describe what is reported about a pool besides its members,
+// shared by the storagepool and reconcile commands.
+var (
+	convoyDriversFlag = cli.StringSliceFlag{
+		Name:  "convoy-drivers",
+		Value: &cli.StringSlice{},
+		Usage: "the convoy drivers the volume agents run with, used to derive the capabilities of the pool named by storagepool-driver",
+	}
+
+	poolCapabilityFlag = cli.StringSliceFlag{
+		Name:  "pool-capability",
+		Value: &cli.StringSlice{},
+		Usage: "set a capability of the pool named by storagepool-driver explicitly as key=value. Keys are shared, snapshots, backups, min-size, max-size and access-modes",
+	}
+
+	poolMountRootFlag = cli.StringFlag{
+		Name:  "pool-mount-root",
+		Usage: "mount point of the shared storage of the pool named by storagepool-driver, used to report its capacity and usage to Cattle. Capacity is not reported if unset",
+	}
+
+	lowSpacePercentFlag = cli.IntFlag{
+		Name:  "low-space-percent",
+		Value: 10,
+		Usage: "log a warning when the pool's free space drops below this percentage of its capacity. 0 disables the warning",
+	}
 )
 
+// membershipPolicyFlags can be changed by reloading the config file.
+var membershipPolicyFlags = []string{
+	"add-grace-period",
+	"remove-grace-period",
+	"flap-window",
+	"flap-threshold",
+	"flap-hold-down",
+	"max-drop-percent",
+	"drop-confirmations",
+}
+
 var Commands = []cli.Command{
 	{
 		Name:  "storagepool",
 		Usage: "Start convoy-agent as a storagepool agent",
 		Flags: []cli.Flag{
+			metadataUrlFlag,
+			cli.DurationFlag{
+				Name:  "add-grace-period",
+				Usage: "how long a new host must be seen before it joins the pool",
+			},
+			cli.DurationFlag{
+				Name:  "remove-grace-period",
+				Value: 30 * time.Second,
+				Usage: "how long a host must be missing before it leaves the pool",
+			},
+			cli.IntFlag{
+				Name:  "flap-threshold",
+				Value: 4,
+				Usage: "number of joins and leaves within flap-window after which a host is considered flapping. 0 disables flap damping",
+			},
+			cli.DurationFlag{
+				Name:  "flap-window",
+				Value: 10 * time.Minute,
+				Usage: "the window in which a host's joins and leaves are counted",
+			},
+			cli.DurationFlag{
+				Name:  "flap-hold-down",
+				Value: 5 * time.Minute,
+				Usage: "how long a flapping host must be seen before it rejoins the pool",
+			},
+			maxDropPercentFlag,
+			cli.IntFlag{
+				Name:  "drop-confirmations",
+				Value: 3,
+				Usage: "number of consecutive polls a held drop must be seen in before it is synced. Send SIGUSR1 to approve it sooner",
+			},
+			agentServiceFlag,
 			cli.StringFlag{
-				Name:  "storagepool-metadata-url",
-				Usage: "set the metadata url",
-				Value: "http://rancher-metadata/2015-12-19",
+				Name:  "leader-lock-dir",
+				Usage: "elect a leader among storagepool agent replicas using a lease file in this directory, which must be on storage shared by the replicas. Only the leader syncs pools and handles removes",
+			},
+			cli.DurationFlag{
+				Name:  "leader-lease",
+				Value: 30 * time.Second,
+				Usage: "how long a leader may go without renewing its lease before a standby takes over",
 			},
+			hostSelectorFlag,
+			poolFlag,
+			convoyDriversFlag,
+			poolCapabilityFlag,
+			cli.IntFlag{
+				Name:  "event-workers",
+				Value: 10,
+				Usage: "number of Cattle storage events handled at once",
+			},
+			poolMountRootFlag,
+			lowSpacePercentFlag,
 		},
 		Action:    start,
 		ShortName: "sp",
 	},
+	{
+		Name:  "reconcile",
+		Usage: "Compare convoy volumes and pool members with what Cattle has recorded, and print the drift. Exits 1 if there is drift and 2 if the comparison cannot be made",
+		Flags: []cli.Flag{
+			metadataUrlFlag,
+			agentServiceFlag,
+			hostSelectorFlag,
+			poolFlag,
+			convoyDriversFlag,
+			poolCapabilityFlag,
+			poolMountRootFlag,
+			lowSpacePercentFlag,
+			maxDropPercentFlag,
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "only print the drift. This is the default",
+			},
+			cli.BoolFlag{
+				Name:  "apply",
+				Usage: "send the volume and storage pool events that repair the drift. Exits 0 only if every event is sent. Host changes that would drop more than max-drop-percent of a pool's hosts are not sent",
+			},
+		},
+		Action: reconcile,
+	},
 }
 
 func start(c *cli.Context) {
@@ -38,21 +196,132 @@ func start(c *cli.Context) {
 
 	storagepoolRootDir := c.GlobalString("storagepool-rootdir")
 	driver := c.GlobalString("storagepool-driver")
-	if driver == "" {
+	if driver == "" && len(c.StringSlice("pool")) == 0 {
 		log.Fatal("required field storagepool-driver has not been set")
 	}
 
+	id, err := identity.Load(storagepoolRootDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer id.Release()
+	log.AddHook(id.LogHook())
+	log.Infof("Starting storagepool agent %s", id.UUID)
+
+	namer, err := volume.VolumeNamerFromContext(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	cattleClient, err := cattle.NewCattleClient(cattleUrl, cattleAccessKey, cattleSecretKey)
 	if err != nil {
 		log.Fatal(err)
 	}
+	cattleClient.SetVolumeNamer(namer)
+	cattleClient.SetReporter(id.UUID)
+	health.Register("cattle", health.Cached(cattleClient.Check, cattleCheckTTL))
+	health.Register("convoy", volume.SocketCheck(socket))
+	health.Register("event-stream", cattleevents.StreamHealth)
 
+	quarantine, err := volume.QuarantineFromContext(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	health.Serve(c.GlobalString("health-bind"), c.GlobalInt("health-port"))
+	config.Watch()
 	resultChan := make(chan error)
 
+	if quarantine != nil {
+		go func(rc chan error) {
+			convoyClient, err := volume.NewConvoyClient(socket)
+			if err != nil {
+				rc <- err
+				return
+			}
+			err = quarantine.Run(convoyClient, quarantinePurgeInterval, make(chan bool, 1))
+			log.Errorf("Quarantine purger exited with error: %v", err)
+			rc <- err
+		}(resultChan)
+	}
+
+	storagepoolAgent := NewStoragepoolAgent(healthCheckInterval, storagepoolRootDir, driver, cattleClient)
+	health.Register("storagepool-sync", storagepoolAgent.SyncHealth)
+	if socket := c.GlobalString("admin-socket"); socket != "" {
+		adminServer := admin.NewServer(socket)
+		adminServer.SetAgentID(id.UUID)
+		adminServer.SetPoolManager(storagepoolAgent)
+		adminServer.AddSyncer("pools", storagepoolAgent)
+		go func() {
+			err := adminServer.Run(make(chan bool, 1))
+			log.Errorf("Admin API exited with error: %v", err)
+		}()
+	}
+	storagepoolAgent.SetAgentService(c.String("agent-service"))
+	pools, err := poolsFromContext(c, driver)
+	if err != nil {
+		log.Fatal(err)
+	}
+	storagepoolAgent.SetPools(pools)
+	storagepoolAgent.SetHealthCheck(c.GlobalString("healthcheck-type"), c.GlobalString("healthcheck-basedir"))
+	storagepoolAgent.SetMembershipPolicy(MembershipPolicy{
+		AddGracePeriod:    c.Duration("add-grace-period"),
+		RemoveGracePeriod: c.Duration("remove-grace-period"),
+		FlapWindow:        c.Duration("flap-window"),
+		FlapThreshold:     c.Int("flap-threshold"),
+		FlapHoldDown:      c.Duration("flap-hold-down"),
+		MaxDropPercent:    c.Int("max-drop-percent"),
+		DropConfirmations: c.Int("drop-confirmations"),
+	})
+	config.OnReload("storagepool", membershipPolicyFlags, func(v config.Values) {
+		storagepoolAgent.SetMembershipPolicy(MembershipPolicy{
+			AddGracePeriod:    v.Duration("add-grace-period"),
+			RemoveGracePeriod: v.Duration("remove-grace-period"),
+			FlapWindow:        v.Duration("flap-window"),
+			FlapThreshold:     v.Int("flap-threshold"),
+			FlapHoldDown:      v.Duration("flap-hold-down"),
+			MaxDropPercent:    v.Int("max-drop-percent"),
+			DropConfirmations: v.Int("drop-confirmations"),
+		})
+	})
+
+	if err := setPoolReporting(c, storagepoolAgent, driver, socket); err != nil {
+		log.Fatal(err)
+	}
+
+	var elector *leader.Elector
+	if lockDir := c.String("leader-lock-dir"); lockDir != "" {
+		elector, err = leader.NewElector(lockDir, id.UUID, c.Duration("leader-lease"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		storagepoolAgent.SetElector(elector)
+		health.Register("leader", func() health.Status {
+			if elector.IsLeader() {
+				return health.OK("leader")
+			}
+			return health.OK(fmt.Sprintf("standby, leader is %s", elector.Holder()))
+		})
+
+		go func(rc chan error) {
+			err := elector.Run(make(chan bool, 1))
+			log.Errorf("Leader election exited with error: %v", err)
+			rc <- err
+		}(resultChan)
+	}
+
+	go func() {
+		approvals := make(chan os.Signal, 1)
+		signal.Notify(approvals, syscall.SIGUSR1)
+		for range approvals {
+			log.Info("Received SIGUSR1, approving any held storage pool membership drop")
+			storagepoolAgent.ApproveDrop()
+		}
+	}()
+
 	go func(rc chan error) {
-		storagepoolAgent := NewStoragepoolAgent(healthCheckInterval, storagepoolRootDir, driver, cattleClient)
 		metadataUrl := c.String("storagepool-metadata-url")
-		err := storagepoolAgent.Run(metadataUrl)
+		err := storagepoolAgent.Run(metadataUrl, make(chan bool, 1))
 		log.Errorf("Error while running storage pool agent [%v]", err)
 		rc <- err
 	}(resultChan)
@@ -62,8 +331,14 @@ func start(c *cli.Context) {
 			CattleURL:       cattleUrl,
 			CattleAccessKey: cattleAccessKey,
 			CattleSecretKey: cattleSecretKey,
-			WorkerCount:     10,
+			WorkerCount:     c.Int("event-workers"),
 			Socket:          socket,
+			EventTimeout:    c.GlobalDuration("event-timeout"),
+			Quarantine:      quarantine,
+			VolumeNamer:     namer,
+		}
+		if elector != nil {
+			conf.Leader = elector
 		}
 		err := cattleevents.ConnectToEventStream(conf)
 		log.Errorf("Cattle event listener exited with error: %s", err)
@@ -73,3 +348,69 @@ func start(c *cli.Context) {
 	<-resultChan
 	log.Info("Exiting.")
 }
+
+// poolsFromContext returns the pools given with --pool, or else the single
+// pool named driver made up of the hosts matching --host-selector.
+func poolsFromContext(c *cli.Context, driver string) ([]Pool, error) {
+	specs := c.StringSlice("pool")
+	if len(specs) == 0 {
+		selector, err := ParseHostSelector(c.String("host-selector"))
+		if err != nil {
+			return nil, err
+		}
+		return []Pool{{Driver: driver, Selector: selector}}, nil
+	}
+	if c.String("host-selector") != "" {
+		return nil, fmt.Errorf("host-selector cannot be combined with pool, give each pool its own selector")
+	}
+
+	pools := []Pool{}
+	seen := map[string]bool{}
+	for _, spec := range specs {
+		pool, err := ParsePool(spec)
+		if err != nil {
+			return nil, err
+		}
+		if seen[pool.Driver] {
+			return nil, fmt.Errorf("Pool %v is given more than once", pool.Driver)
+		}
+		seen[pool.Driver] = true
+		pools = append(pools, pool)
+	}
+	return pools, nil
+}
+
+// setPoolReporting sets the capabilities and capacity the agent reports with
+// the pool named driver, as configured by the flags shared with the reconcile
+// command. They describe the storage of convoy at socket, which is only that
+// pool's, so other pools are reported without them.
+func setPoolReporting(c *cli.Context, agent *StoragepoolAgent, driver, socket string) error {
+	convoyDrivers := c.StringSlice("convoy-drivers")
+	capabilityOverrides := c.StringSlice("pool-capability")
+	mountRoot := c.String("pool-mount-root")
+	if driver == "" {
+		if len(convoyDrivers) > 0 || len(capabilityOverrides) > 0 || mountRoot != "" {
+			return fmt.Errorf("convoy-drivers, pool-capability and pool-mount-root describe the pool named by storagepool-driver, which has not been set")
+		}
+		return nil
+	}
+	if len(convoyDrivers) > 0 || len(capabilityOverrides) > 0 {
+		caps, err := CapabilitiesFor(convoyDrivers)
+		if err != nil && len(capabilityOverrides) == 0 {
+			return err
+		}
+		if err := caps.Override(capabilityOverrides); err != nil {
+			return err
+		}
+		agent.SetCapabilities(driver, caps)
+	}
+
+	if mountRoot != "" {
+		convoyClient, err := volume.NewConvoyClient(socket)
+		if err != nil {
+			return err
+		}
+		agent.SetCapacityReporting(driver, mountRoot, convoyClient, c.Int("low-space-percent"))
+	}
+	return nil
+}
@@ -11,10 +11,11 @@ import (
 )
 
 var (
-	version    = 0
-	selfStack  = metadata.Stack{}
-	services   = []metadata.Service{}
-	containers = []metadata.Container{}
+	version                = 0
+	selfStack  interface{} = metadata.Stack{}
+	services               = []metadata.Service{}
+	containers             = []metadata.Container{}
+	hosts                  = []metadata.Host{}
 
 	metadataUrl = ":12345"
 )
@@ -30,7 +31,7 @@ func startMetadataServer() {
 			http.Error(w, "Could not marshall self/stack", 500)
 			return
 		}
-		fmt.Fprintf(w, string(stackString))
+		w.Write(stackString)
 	})
 	mux.HandleFunc("/mock-12-19-2015/services", func(w http.ResponseWriter, req *http.Request) {
 		servicesString, err := json.Marshal(services)
@@ -38,7 +39,7 @@ func startMetadataServer() {
 			http.Error(w, "Could not marshall services", 500)
 			return
 		}
-		fmt.Fprintf(w, string(servicesString))
+		w.Write(servicesString)
 	})
 	mux.HandleFunc("/mock-12-19-2015/containers", func(w http.ResponseWriter, req *http.Request) {
 		containerString, err := json.Marshal(containers)
@@ -46,14 +47,22 @@ func startMetadataServer() {
 			http.Error(w, "Could not marshall containers", 500)
 			return
 		}
-		fmt.Fprintf(w, string(containerString))
+		w.Write(containerString)
+	})
+	mux.HandleFunc("/mock-12-19-2015/hosts", func(w http.ResponseWriter, req *http.Request) {
+		hostsString, err := json.Marshal(hosts)
+		if err != nil {
+			http.Error(w, "Could not marshall hosts", 500)
+			return
+		}
+		w.Write(hostsString)
 	})
 	if err := http.ListenAndServe(metadataUrl, mux); err != nil {
 		log.Fatalf("error starting server err = [%v]", err)
 	}
 }
 
-func setSelfStack(stack metadata.Stack) {
+func setSelfStack(stack interface{}) {
 	updateVersion()
 	selfStack = stack
 }
@@ -63,6 +72,11 @@ func setContainers(conts []metadata.Container) {
 	containers = conts
 }
 
+func setHosts(hs []metadata.Host) {
+	updateVersion()
+	hosts = hs
+}
+
 func setServices(servs []metadata.Service) {
 	updateVersion()
 	services = servs
@@ -0,0 +1,106 @@
+package tests
+
+import (
+	"time"
+
+	"gopkg.in/check.v1"
+
+	"github.com/rancher/convoy-agent/heartbeat"
+	"github.com/rancher/convoy-agent/leader"
+	"github.com/rancher/convoy-agent/storagepool"
+)
+
+type FileHealthCheckTestSuite struct {
+}
+
+var _ = check.Suite(&FileHealthCheckTestSuite{})
+
+func (s *FileHealthCheckTestSuite) TestCountsFreshHeartbeats(c *check.C) {
+	tc := &testCattleClient{}
+	dir := c.MkDir()
+
+	writer, err := heartbeat.NewWriter(dir, "hostUuid1", 50*time.Millisecond)
+	c.Assert(err, check.IsNil)
+	writerControl := make(chan bool, 1)
+	defer stopAgent(writerControl)
+	go writer.Run(writerControl)
+
+	// hostUuid2's clock is an hour behind, which does not matter while its
+	// heartbeat keeps changing. hostUuid3 has stopped beating.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			heartbeat.Write(dir, "hostUuid2", time.Now().Add(-time.Hour))
+			select {
+			case <-stop:
+				return
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+	}()
+	c.Assert(heartbeat.Write(dir, "hostUuid3", time.Now()), check.IsNil)
+
+	controlChan := make(chan bool, 1)
+	defer stopAgent(controlChan)
+	go func() {
+		spAgent := storagepool.NewStoragepoolAgent(100, ".root", "1234567890", tc)
+		spAgent.SetHealthCheck(storagepool.FileHealthCheck, dir)
+		err := spAgent.Run("", controlChan)
+		if err != nil {
+			c.Fatalf("Error starting storagepool agent [%v]", err)
+		}
+	}()
+	time.Sleep(700 * time.Millisecond)
+
+	uuids := tc.getLastSync()
+	actual := map[string]bool{}
+	for _, uuid := range uuids {
+		actual[uuid] = true
+	}
+	c.Assert(actual, check.DeepEquals, map[string]bool{
+		"hostUuid1": true,
+		"hostUuid2": true,
+	})
+
+	// The stale heartbeat is left for its host to remove.
+	beats, err := heartbeat.Read(dir)
+	c.Assert(err, check.IsNil)
+	_, ok := beats["hostUuid3"]
+	c.Assert(ok, check.Equals, true)
+}
+
+func (s *FileHealthCheckTestSuite) TestOnlyLeaderSyncs(c *check.C) {
+	tc := &testCattleClient{}
+	dir := c.MkDir()
+	writer, err := heartbeat.NewWriter(dir, "hostUuid1", 50*time.Millisecond)
+	c.Assert(err, check.IsNil)
+	writerControl := make(chan bool, 1)
+	defer stopAgent(writerControl)
+	go writer.Run(writerControl)
+
+	elector, err := leader.NewElector(c.MkDir(), "agent1", 300*time.Millisecond)
+	c.Assert(err, check.IsNil)
+
+	controlChan := make(chan bool, 1)
+	defer stopAgent(controlChan)
+	go func() {
+		spAgent := storagepool.NewStoragepoolAgent(50, ".root", "1234567890", tc)
+		spAgent.SetHealthCheck(storagepool.FileHealthCheck, dir)
+		spAgent.SetElector(elector)
+		err := spAgent.Run("", controlChan)
+		if err != nil {
+			c.Fatalf("Error starting storagepool agent [%v]", err)
+		}
+	}()
+	time.Sleep(200 * time.Millisecond)
+	c.Assert(tc.getLastSync(), check.DeepEquals, []string{})
+
+	electorControl := make(chan bool, 1)
+	defer stopAgent(electorControl)
+	go elector.Run(electorControl)
+	time.Sleep(400 * time.Millisecond)
+
+	c.Assert(elector.IsLeader(), check.Equals, true)
+	c.Assert(tc.getLastSync(), check.DeepEquals, []string{"hostUuid1"})
+}
@@ -29,6 +29,10 @@ func (s *MetadataTestSuite) SetUpSuite(c *check.C) {
 	time.Sleep(time.Millisecond * 200)
 }
 
+func stopAgent(controlChan chan bool) {
+	controlChan <- true
+}
+
 var service1 = metadata.Service{
 	Name: "service1",
 	Containers: []metadata.Container{
@@ -62,9 +66,11 @@ func (s *MetadataTestSuite) TestReadsHostsCorrectly(c *check.C) {
 	}
 	setSelfStack(stack)
 
+	controlChan := make(chan bool, 1)
+	defer stopAgent(controlChan)
 	go func() {
 		spAgent := storagepool.NewStoragepoolAgent(100, ".root", "1234567890", tc)
-		err := spAgent.Run("http://localhost" + metadataUrl + "/mock-12-19-2015")
+		err := spAgent.Run("http://localhost"+metadataUrl+"/mock-12-19-2015", controlChan)
 		if err != nil {
 			c.Fatalf("Error starting storagepool agent [%v]", err)
 		}
@@ -92,9 +98,11 @@ func (s *MetadataTestSuite) TestDetectsVersionChange(c *check.C) {
 	}
 	setSelfStack(stack)
 
+	controlChan := make(chan bool, 1)
+	defer stopAgent(controlChan)
 	go func() {
 		spAgent := storagepool.NewStoragepoolAgent(100, ".root", "1234567890", tc)
-		err := spAgent.Run("http://localhost" + metadataUrl + "/mock-12-19-2015")
+		err := spAgent.Run("http://localhost"+metadataUrl+"/mock-12-19-2015", controlChan)
 		if err != nil {
 			c.Fatalf("Error starting storagepool agent [%v]", err)
 		}
@@ -164,9 +172,11 @@ func (s *MetadataTestSuite) TestNoVersionChange(c *check.C) {
 	}
 	setSelfStack(stack)
 
+	controlChan := make(chan bool, 1)
+	defer stopAgent(controlChan)
 	go func() {
 		spAgent := storagepool.NewStoragepoolAgent(100, ".root", "1234567890", tc)
-		err := spAgent.Run("http://localhost" + metadataUrl + "/mock-12-19-2015")
+		err := spAgent.Run("http://localhost"+metadataUrl+"/mock-12-19-2015", controlChan)
 		if err != nil {
 			c.Fatalf("Error starting storagepool agent [%v]", err)
 		}
@@ -207,9 +217,11 @@ func (s *MetadataTestSuite) TestVersionChangeAndDeletion(c *check.C) {
 	}
 	setSelfStack(stack)
 
+	controlChan := make(chan bool, 1)
+	defer stopAgent(controlChan)
 	go func() {
 		spAgent := storagepool.NewStoragepoolAgent(100, ".root", "1234567890", tc)
-		err := spAgent.Run("http://localhost" + metadataUrl + "/mock-12-19-2015")
+		err := spAgent.Run("http://localhost"+metadataUrl+"/mock-12-19-2015", controlChan)
 		if err != nil {
 			c.Fatalf("Error starting storagepool agent [%v]", err)
 		}
@@ -260,3 +272,173 @@ func (s *MetadataTestSuite) TestVersionChangeAndDeletion(c *check.C) {
 		"hostUuid1": true,
 	})
 }
+
+func (s *MetadataTestSuite) TestStopsOnControlChan(c *check.C) {
+	tc := &testCattleClient{}
+	setSelfStack(metadata.Stack{
+		Name:     "test_stack1",
+		Services: []metadata.Service{service1},
+	})
+
+	controlChan := make(chan bool, 1)
+	done := make(chan error, 1)
+	go func() {
+		spAgent := storagepool.NewStoragepoolAgent(100, ".root", "1234567890", tc)
+		done <- spAgent.Run("http://localhost"+metadataUrl+"/mock-12-19-2015", controlChan)
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	stopAgent(controlChan)
+	select {
+	case err := <-done:
+		c.Assert(err, check.IsNil)
+	case <-time.After(time.Second):
+		c.Fatal("storagepool agent did not stop")
+	}
+	c.Assert(<-controlChan, check.Equals, true)
+}
+
+func (s *MetadataTestSuite) TestCountsOnlyHealthyAgentContainers(c *check.C) {
+	tc := &testCattleClient{}
+	container := func(name, service, host, state, health string) map[string]string {
+		return map[string]string{
+			"name":         name,
+			"service_name": service,
+			"host_uuid":    host,
+			"state":        state,
+			"health_state": health,
+		}
+	}
+	setSelfStack(map[string]interface{}{
+		"name": "test_stack1",
+		"services": []interface{}{
+			map[string]interface{}{
+				"name": "volume-agent",
+				"containers": []interface{}{
+					container("agent1", "volume-agent", "hostUuid1", "running", "healthy"),
+					container("agent2", "volume-agent", "hostUuid2", "running", "unhealthy"),
+					container("agent3", "volume-agent", "hostUuid3", "stopped", "healthy"),
+					container("agent4", "volume-agent", "hostUuid4", "running", ""),
+				},
+			},
+			map[string]interface{}{
+				"name": "storagepool-agent",
+				"containers": []interface{}{
+					container("sp1", "storagepool-agent", "hostUuid5", "running", "healthy"),
+				},
+			},
+		},
+	})
+
+	controlChan := make(chan bool, 1)
+	defer stopAgent(controlChan)
+	go func() {
+		spAgent := storagepool.NewStoragepoolAgent(100, ".root", "1234567890", tc)
+		spAgent.SetAgentService("volume-agent")
+		err := spAgent.Run("http://localhost"+metadataUrl+"/mock-12-19-2015", controlChan)
+		if err != nil {
+			c.Fatalf("Error starting storagepool agent [%v]", err)
+		}
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	uuids := tc.getLastSync()
+	actual := map[string]bool{}
+	for _, uuid := range uuids {
+		actual[uuid] = true
+	}
+	c.Assert(actual, check.DeepEquals, map[string]bool{
+		"hostUuid1": true,
+		"hostUuid4": true,
+	})
+}
+
+func (s *MetadataTestSuite) TestPausesAndResyncsPools(c *check.C) {
+	tc := &testCattleClient{}
+	setSelfStack(metadata.Stack{
+		Name:     "test_stack1",
+		Services: []metadata.Service{service1},
+	})
+
+	controlChan := make(chan bool, 1)
+	defer stopAgent(controlChan)
+	spAgent := storagepool.NewStoragepoolAgent(100, ".root", "1234567890", tc)
+	go func() {
+		err := spAgent.Run("http://localhost"+metadataUrl+"/mock-12-19-2015", controlChan)
+		if err != nil {
+			c.Fatalf("Error starting storagepool agent [%v]", err)
+		}
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	c.Assert(tc.pools, check.HasLen, 1)
+	pools := spAgent.Pools()
+	c.Assert(pools, check.HasLen, 1)
+	c.Assert(pools[0].Driver, check.Equals, "1234567890")
+	c.Assert(pools[0].Hosts, check.DeepEquals, []string{"hostUuid1", "hostUuid2"})
+	c.Assert(pools[0].Leader, check.Equals, true)
+	c.Assert(pools[0].LastSync, check.Not(check.Equals), "")
+
+	// A resync waits while the agent is paused.
+	spAgent.SetPaused(true)
+	spAgent.Resync()
+	time.Sleep(200 * time.Millisecond)
+	c.Assert(tc.pools, check.HasLen, 1)
+
+	spAgent.SetPaused(false)
+	time.Sleep(200 * time.Millisecond)
+	c.Assert(tc.pools, check.HasLen, 2)
+}
+
+func (s *MetadataTestSuite) TestSyncsPoolsByHostLabels(c *check.C) {
+	tc := &testCattleClient{}
+	setHosts([]metadata.Host{
+		{UUID: "hostUuid1", Labels: map[string]string{"storage.zone": "a"}},
+		{UUID: "hostUuid2", Labels: map[string]string{"storage.zone": "b"}},
+		{UUID: "hostUuid3"},
+	})
+	setSelfStack(metadata.Stack{
+		Name: "test_stack1",
+		Services: []metadata.Service{
+			service1,
+			{
+				Name: "service2",
+				Containers: []metadata.Container{
+					{Name: "container3", HostUUID: "hostUuid3"},
+				},
+			},
+		},
+	})
+
+	zoneA, err := storagepool.ParsePool("gluster-a:storage.zone=a")
+	c.Assert(err, check.IsNil)
+	notA, err := storagepool.ParsePool("gluster-rest:storage.zone!=a")
+	c.Assert(err, check.IsNil)
+
+	controlChan := make(chan bool, 1)
+	defer stopAgent(controlChan)
+	go func() {
+		spAgent := storagepool.NewStoragepoolAgent(100, ".root", "1234567890", tc)
+		spAgent.SetPools([]storagepool.Pool{zoneA, notA})
+		err := spAgent.Run("http://localhost"+metadataUrl+"/mock-12-19-2015", controlChan)
+		if err != nil {
+			c.Fatalf("Error starting storagepool agent [%v]", err)
+		}
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	synced := map[string]map[string]bool{}
+	for i, pool := range tc.pools {
+		synced[pool.Driver] = map[string]bool{}
+		for _, uuid := range tc.hosts[i] {
+			synced[pool.Driver][uuid] = true
+		}
+	}
+	c.Assert(synced, check.DeepEquals, map[string]map[string]bool{
+		"gluster-a": {"hostUuid1": true},
+		"gluster-rest": {
+			"hostUuid2": true,
+			"hostUuid3": true,
+		},
+	})
+}
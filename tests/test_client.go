@@ -3,12 +3,14 @@ package tests
 import (
 	"fmt"
 
+	"github.com/rancher/convoy-agent/cattle"
 	"github.com/rancher/convoy/api"
 )
 
 type testCattleClient struct {
 	lastEvents []string
 	hosts      [][]string
+	pools      []cattle.StoragePool
 }
 
 func (t *testCattleClient) CreateVolume(driver string, vol api.VolumeResponse) error {
@@ -21,9 +23,10 @@ func (t *testCattleClient) DeleteVolume(driver string, vol api.VolumeResponse) e
 	return nil
 }
 
-func (t *testCattleClient) SyncStoragePool(driver string, hostUuids []string) error {
-	t.lastEvents = append(t.lastEvents, fmt.Sprintf("SYNC_%s", driver))
+func (t *testCattleClient) SyncStoragePool(pool cattle.StoragePool, hostUuids []string) error {
+	t.lastEvents = append(t.lastEvents, fmt.Sprintf("SYNC_%s", pool.Driver))
 	t.hosts = append(t.hosts, hostUuids)
+	t.pools = append(t.pools, pool)
 	return nil
 }
 
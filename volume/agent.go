@@ -1,18 +1,46 @@
 package volume
 
 import (
+	"sort"
+	"sync"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy-agent/admin"
 	"github.com/rancher/convoy-agent/cattle"
+	"github.com/rancher/convoy-agent/health"
+	"github.com/rancher/convoy/api"
 )
 
 type VolumeAgent struct {
 	socketFile          string
 	healthCheckInterval int
-	volumeQueryInterval int
 	cattleClient        cattle.CattleInterface
 	driver              string
+	reporter            Leadership
+	takeoverWindow      time.Duration
+	polls               *health.Tracker
+	resyncChan          chan struct{}
+
+	mu                  sync.Mutex
+	volumeQueryInterval int
+	tracked             []string
+	reports             map[string]*volumeReport
+	paused              bool
+}
+
+// volumeReport is the last event the agent sent for a volume.
+type volumeReport struct {
+	event        string
+	at           time.Time
+	err          error
+	attempts     int
+	firstFailure time.Time
+}
+
+// Leadership tells whether this agent is the elected reporter for its pool.
+type Leadership interface {
+	IsLeader() bool
 }
 
 func NewVolumeAgent(socketFile string, volumeQueryInterval int, cattleClient cattle.CattleInterface, driver string) *VolumeAgent {
@@ -21,7 +49,167 @@ func NewVolumeAgent(socketFile string, volumeQueryInterval int, cattleClient cat
 		volumeQueryInterval: volumeQueryInterval,
 		cattleClient:        cattleClient,
 		driver:              driver,
+		polls:               health.NewTracker(pollStaleAfter(volumeQueryInterval)),
+		resyncChan:          make(chan struct{}, 1),
+		reports:             map[string]*volumeReport{},
+	}
+}
+
+// pollStaleAfter is how long the agent can go without listing convoy's
+// volumes before it is unhealthy.
+func pollStaleAfter(volumeQueryInterval int) time.Duration {
+	stale := 3 * time.Duration(volumeQueryInterval) * time.Millisecond
+	if stale < 30*time.Second {
+		stale = 30 * time.Second
+	}
+	return stale
+}
+
+// PollHealth reports when convoy's volumes were last listed and how many are
+// tracked.
+func (v *VolumeAgent) PollHealth() health.Status {
+	status := v.polls.Status()
+	v.mu.Lock()
+	status.Details["trackedVolumes"] = len(v.tracked)
+	v.mu.Unlock()
+	return status
+}
+
+// SetPollInterval changes how often convoy is polled, in milliseconds, from
+// the next poll on.
+func (v *VolumeAgent) SetPollInterval(volumeQueryInterval int) {
+	v.mu.Lock()
+	v.volumeQueryInterval = volumeQueryInterval
+	v.mu.Unlock()
+	v.polls.SetStaleAfter(pollStaleAfter(volumeQueryInterval))
+}
+
+func (v *VolumeAgent) pollInterval() time.Duration {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return time.Duration(v.volumeQueryInterval) * time.Millisecond
+}
+
+// SetReporterElection makes the agent send volume events only while it is the
+// elected reporter, for drivers where every host sees the same volumes.
+// Standbys keep track of volumes without reporting them. On taking over, an
+// agent reports every current volume, and the deletes it saw within window,
+// which may not have been reported by the previous reporter.
+func (v *VolumeAgent) SetReporterElection(reporter Leadership, window time.Duration) {
+	v.reporter = reporter
+	v.takeoverWindow = window
+}
+
+func (v *VolumeAgent) isReporter() bool {
+	return v.reporter == nil || v.reporter.IsLeader()
+}
+
+// Resync makes the agent poll convoy straight away and send a create event for
+// every volume, as on taking over as the reporter.
+func (v *VolumeAgent) Resync() {
+	select {
+	case v.resyncChan <- struct{}{}:
+	default:
+	}
+}
+
+// SetPaused stops or restarts polling convoy. Changes made while paused are
+// reported once the agent resumes.
+func (v *VolumeAgent) SetPaused(paused bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.paused = paused
+}
+
+func (v *VolumeAgent) Paused() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.paused
+}
+
+// Volumes returns the volumes the agent tracks and what it last reported
+// about each.
+func (v *VolumeAgent) Volumes() []admin.Volume {
+	reporter := v.isReporter()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	volumes := []admin.Volume{}
+	for _, name := range v.tracked {
+		vol := admin.Volume{Name: name, Driver: v.driver, State: "unreported"}
+		if r, ok := v.reports[name]; ok {
+			vol.LastEvent = r.event
+			vol.ReportedAt = admin.FormatTime(r.at)
+			vol.State = "reported"
+			if r.err != nil {
+				vol.State = r.event + "-failed"
+				vol.Error = r.err.Error()
+			}
+		} else if IsQuarantineName(name) {
+			vol.State = "quarantined"
+		} else if !reporter {
+			vol.State = "standby"
+		}
+		volumes = append(volumes, vol)
 	}
+	return volumes
+}
+
+// PendingEvents returns the volume events that failed to reach Cattle and are
+// retried on the next poll.
+func (v *VolumeAgent) PendingEvents() []admin.PendingEvent {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	names := []string{}
+	for name, r := range v.reports {
+		if r.err != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	pending := []admin.PendingEvent{}
+	for _, name := range names {
+		r := v.reports[name]
+		pending = append(pending, admin.PendingEvent{
+			Volume:       name,
+			Driver:       v.driver,
+			Event:        r.event,
+			Attempts:     r.attempts,
+			FirstFailure: admin.FormatTime(r.firstFailure),
+			LastFailure:  admin.FormatTime(r.at),
+			Error:        r.err.Error(),
+		})
+	}
+	return pending
+}
+
+// report records the outcome of sending event for a volume.
+func (v *VolumeAgent) report(name, event string, err error) {
+	recordVolumeEvent(v.driver, event, err)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	r, ok := v.reports[name]
+	if !ok || r.event != event {
+		r = &volumeReport{event: event}
+		v.reports[name] = r
+	}
+	r.at = time.Now()
+	r.err = err
+	if err == nil {
+		r.attempts = 0
+		r.firstFailure = time.Time{}
+		if event == "delete" {
+			delete(v.reports, name)
+		}
+		return
+	}
+	if r.attempts == 0 {
+		r.firstFailure = r.at
+	}
+	r.attempts++
 }
 
 func (v *VolumeAgent) Run(controlChan chan bool) error {
@@ -31,43 +219,147 @@ func (v *VolumeAgent) Run(controlChan chan bool) error {
 	}
 
 	vols := Volume{}
+	reporting := false
+	// unreachable is set when convoy refuses connections after a successful
+	// poll, so that an agent started before convoy does not count a restart.
+	polled := false
+	unreachable := false
+	resync := false
+	standbyDeletes := map[string]standbyDelete{}
 
 	for {
 		select {
 		case <-controlChan:
 			controlChan <- true
 			return nil
-		case <-time.After(time.Duration(v.volumeQueryInterval) * time.Millisecond):
+		case <-v.resyncChan:
+			resync = true
+		case <-time.After(v.pollInterval()):
+		}
+		if v.Paused() {
+			continue
 		}
 
+		start := time.Now()
 		currVols, err := convoy.GetCurrVolumes()
+		pollDuration.ObserveSince(start)
+		v.polls.Record(err)
 		if err != nil {
+			pollErrors.Inc()
+			unreachable = unreachable || (polled && isConnectError(err))
 			log.Error(err)
 			continue
 		}
+		if unreachable {
+			log.Info("Convoy is accepting connections again")
+			convoyRestarts.Inc()
+			unreachable = false
+		}
+		polled = true
 		deletedVols := findDeletedVolumes(currVols, vols)
 		createdVols := findCreatedVolumes(currVols, vols)
 
+		now := time.Now()
+		if !v.isReporter() {
+			if reporting {
+				log.Info("No longer the volume event reporter")
+				reporting = false
+			}
+			for name, vol := range deletedVols {
+				standbyDeletes[name] = standbyDelete{vol: vol, at: now}
+			}
+			for name, d := range standbyDeletes {
+				if _, ok := currVols[name]; ok || now.Sub(d.at) > v.takeoverWindow {
+					delete(standbyDeletes, name)
+				}
+			}
+			vols = currVols
+			v.setTracked(vols)
+			continue
+		}
+		if !reporting {
+			reporting = true
+			if v.reporter != nil {
+				log.Infof("Became the volume event reporter, resyncing %d volumes", len(currVols))
+				createdVols = findCreatedVolumes(currVols, Volume{})
+				for name, d := range standbyDeletes {
+					if _, ok := currVols[name]; !ok {
+						deletedVols[name] = d.vol
+					}
+				}
+				standbyDeletes = map[string]standbyDelete{}
+			}
+		}
+		if resync {
+			log.Infof("Resyncing %d volumes", len(currVols))
+			createdVols = findCreatedVolumes(currVols, Volume{})
+			resync = false
+		}
+
 		for _, vol := range deletedVols {
+			if IsQuarantineName(vol.Name) {
+				log.Debugf("Not sending delete event for quarantined volume %s", vol.Name)
+				continue
+			}
 			err := v.cattleClient.DeleteVolume(v.driver, vol)
+			v.report(vol.Name, "delete", err)
 			if err != nil {
 				log.Errorf("Error sending delete event for volume name=[%s] err=[%v]", vol.Name, err)
-				currVols[vol.Name] = vols[vol.Name]
+				currVols[vol.Name] = vol
 			}
 		}
 
 		for _, vol := range createdVols {
+			if IsQuarantineName(vol.Name) {
+				log.Debugf("Not sending create event for quarantined volume %s", vol.Name)
+				continue
+			}
 			err := v.cattleClient.CreateVolume(v.driver, vol)
+			v.report(vol.Name, "create", err)
 			if err != nil {
 				log.Errorf("Error sending create event for volume name=[%s] err=[%v]", vol.Name, err)
 				delete(currVols, vol.Name)
 			}
 		}
+		v.forgetVanished(currVols, createdVols)
 		vols = currVols
+		v.setTracked(vols)
 	}
 	return nil
 }
 
+// forgetVanished drops failed creates for volumes that are gone from convoy,
+// which will not be retried.
+func (v *VolumeAgent) forgetVanished(currVols, createdVols Volume) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for name, r := range v.reports {
+		_, current := currVols[name]
+		_, created := createdVols[name]
+		if r.event == "create" && r.err != nil && !current && !created {
+			delete(v.reports, name)
+		}
+	}
+}
+
+func (v *VolumeAgent) setTracked(vols Volume) {
+	names := []string{}
+	for name := range vols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.tracked = names
+	trackedVolumes.Set(float64(len(names)), v.driver)
+}
+
+type standbyDelete struct {
+	vol api.VolumeResponse
+	at  time.Time
+}
+
 func findDeletedVolumes(curr, prev Volume) Volume {
 	deleted := Volume{}
 	for key, vol := range prev {
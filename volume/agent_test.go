@@ -0,0 +1,328 @@
+package volume
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/rancher/convoy/api"
+	"gopkg.in/check.v1"
+
+	"github.com/rancher/convoy-agent/cattle"
+	"github.com/rancher/convoy-agent/config"
+)
+
+type AgentTestSuite struct {
+	mu       sync.Mutex
+	volumes  Volume
+	listener net.Listener
+	socket   string
+}
+
+var _ = check.Suite(&AgentTestSuite{})
+
+// SetUpTest serves a fake convoy volume list on a unix socket.
+func (s *AgentTestSuite) SetUpTest(c *check.C) {
+	s.volumes = Volume{}
+	s.socket = filepath.Join(c.MkDir(), "convoy.sock")
+	s.serve(c)
+}
+
+func (s *AgentTestSuite) serve(c *check.C) {
+	l, err := net.Listen("unix", s.socket)
+	c.Assert(err, check.IsNil)
+	s.listener = l
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/volumes/list", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		// Like convoy restarting, closing the listener must cut the agent off.
+		w.Header().Set("Connection", "close")
+		json.NewEncoder(w).Encode(s.volumes)
+	})
+	go http.Serve(l, mux)
+}
+
+func (s *AgentTestSuite) TearDownTest(c *check.C) {
+	s.listener.Close()
+}
+
+func (s *AgentTestSuite) setVolumes(names ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.volumes = Volume{}
+	for _, name := range names {
+		s.volumes[name] = api.VolumeResponse{Name: name}
+	}
+}
+
+type recordingCattle struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recordingCattle) record(event string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recordingCattle) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := r.events
+	r.events = nil
+	sort.Strings(events)
+	return events
+}
+
+func (r *recordingCattle) CreateVolume(driver string, vol api.VolumeResponse) error {
+	r.record("create " + vol.Name)
+	return nil
+}
+
+func (r *recordingCattle) DeleteVolume(driver string, vol api.VolumeResponse) error {
+	r.record("delete " + vol.Name)
+	return nil
+}
+
+func (r *recordingCattle) SyncStoragePool(pool cattle.StoragePool, hostUuids []string) error {
+	return nil
+}
+
+// failingCattle fails to send creates while fail is set.
+type failingCattle struct {
+	recordingCattle
+	fail bool
+}
+
+func (f *failingCattle) setFailing(fail bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fail = fail
+}
+
+func (f *failingCattle) CreateVolume(driver string, vol api.VolumeResponse) error {
+	f.mu.Lock()
+	fail := f.fail
+	f.mu.Unlock()
+	if fail {
+		return errors.New("connection refused")
+	}
+	return f.recordingCattle.CreateVolume(driver, vol)
+}
+
+type switchableLeadership struct {
+	mu     sync.Mutex
+	leader bool
+}
+
+func (l *switchableLeadership) set(leader bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.leader = leader
+}
+
+func (l *switchableLeadership) IsLeader() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.leader
+}
+
+func (s *AgentTestSuite) TestOnlyReporterSendsEvents(c *check.C) {
+	rc := &recordingCattle{}
+	leadership := &switchableLeadership{}
+	s.setVolumes("vol1", "vol2")
+
+	agent := NewVolumeAgent(s.socket, 20, rc, "nfs")
+	agent.SetReporterElection(leadership, time.Minute)
+	controlChan := make(chan bool, 1)
+	go agent.Run(controlChan)
+	defer func() { controlChan <- true }()
+
+	// As a standby, changes are tracked but not reported.
+	time.Sleep(100 * time.Millisecond)
+	s.setVolumes("vol2", "vol3")
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(rc.take(), check.IsNil)
+
+	// On taking over, every volume is reported along with the delete seen
+	// while standing by.
+	leadership.set(true)
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(rc.take(), check.DeepEquals, []string{"create vol2", "create vol3", "delete vol1"})
+
+	s.setVolumes("vol3")
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(rc.take(), check.DeepEquals, []string{"delete vol2"})
+
+	leadership.set(false)
+	time.Sleep(100 * time.Millisecond)
+	s.setVolumes("vol3", "vol4")
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(rc.take(), check.IsNil)
+}
+
+func (s *AgentTestSuite) TestWithoutElectionEveryAgentReports(c *check.C) {
+	rc := &recordingCattle{}
+	s.setVolumes("vol1")
+
+	agent := NewVolumeAgent(s.socket, 20, rc, "nfs")
+	controlChan := make(chan bool, 1)
+	go agent.Run(controlChan)
+	defer func() { controlChan <- true }()
+
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(rc.take(), check.DeepEquals, []string{"create vol1"})
+}
+
+func (s *AgentTestSuite) TestQuarantinedVolumesNotReported(c *check.C) {
+	rc := &recordingCattle{}
+	quarantined := QuarantinePrefix + "vol2--1"
+	s.setVolumes("vol1", quarantined)
+
+	agent := NewVolumeAgent(s.socket, 20, rc, "nfs")
+	controlChan := make(chan bool, 1)
+	go agent.Run(controlChan)
+	defer func() { controlChan <- true }()
+
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(rc.take(), check.DeepEquals, []string{"create vol1"})
+
+	// Purging the quarantined volume removes a name Cattle never knew.
+	s.setVolumes("vol1")
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(rc.take(), check.IsNil)
+}
+
+func (s *AgentTestSuite) TestMetrics(c *check.C) {
+	rc := &recordingCattle{}
+	s.setVolumes("vol1", "vol2")
+	restarts := convoyRestarts.Value()
+
+	agent := NewVolumeAgent(s.socket, 20, rc, "metrics")
+	controlChan := make(chan bool, 1)
+	go agent.Run(controlChan)
+	defer func() { controlChan <- true }()
+
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(volumeEvents.Value("metrics", "create", "sent"), check.Equals, float64(2))
+	c.Assert(trackedVolumes.Value("metrics"), check.Equals, float64(2))
+
+	// Convoy going away and coming back on its socket counts as a restart.
+	s.listener.Close()
+	time.Sleep(100 * time.Millisecond)
+	s.setVolumes("vol1")
+	s.serve(c)
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(convoyRestarts.Value(), check.Equals, restarts+1)
+	c.Assert(volumeEvents.Value("metrics", "delete", "sent"), check.Equals, float64(1))
+	c.Assert(trackedVolumes.Value("metrics"), check.Equals, float64(1))
+}
+
+func (s *AgentTestSuite) TestConvoyStartingLateIsNotARestart(c *check.C) {
+	s.listener.Close()
+	restarts := convoyRestarts.Value()
+
+	agent := NewVolumeAgent(s.socket, 20, &recordingCattle{}, "late")
+	controlChan := make(chan bool, 1)
+	go agent.Run(controlChan)
+	defer func() { controlChan <- true }()
+
+	time.Sleep(100 * time.Millisecond)
+	s.serve(c)
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(trackedVolumes.Value("late"), check.Equals, float64(0))
+	c.Assert(agent.polls.Status().Healthy, check.Equals, true)
+	c.Assert(convoyRestarts.Value(), check.Equals, restarts)
+}
+
+func (s *AgentTestSuite) TestPendingEvents(c *check.C) {
+	fc := &failingCattle{fail: true}
+	s.setVolumes("vol1")
+
+	agent := NewVolumeAgent(s.socket, 20, fc, "vfs")
+	controlChan := make(chan bool, 1)
+	go agent.Run(controlChan)
+	defer func() { controlChan <- true }()
+
+	time.Sleep(100 * time.Millisecond)
+	pending := agent.PendingEvents()
+	c.Assert(pending, check.HasLen, 1)
+	c.Assert(pending[0].Volume, check.Equals, "vol1")
+	c.Assert(pending[0].Event, check.Equals, "create")
+	c.Assert(pending[0].Attempts > 1, check.Equals, true)
+	c.Assert(pending[0].Error, check.Equals, "connection refused")
+
+	fc.setFailing(false)
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(agent.PendingEvents(), check.HasLen, 0)
+	volumes := agent.Volumes()
+	c.Assert(volumes, check.HasLen, 1)
+	c.Assert(volumes[0].State, check.Equals, "reported")
+	c.Assert(volumes[0].LastEvent, check.Equals, "create")
+}
+
+func (s *AgentTestSuite) TestPauseAndResync(c *check.C) {
+	rc := &recordingCattle{}
+	s.setVolumes("vol1")
+
+	agent := NewVolumeAgent(s.socket, 20, rc, "vfs")
+	controlChan := make(chan bool, 1)
+	go agent.Run(controlChan)
+	defer func() { controlChan <- true }()
+
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(rc.take(), check.DeepEquals, []string{"create vol1"})
+
+	// Changes made while paused are reported on resuming.
+	agent.SetPaused(true)
+	time.Sleep(50 * time.Millisecond)
+	s.setVolumes("vol1", "vol2")
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(rc.take(), check.IsNil)
+	agent.SetPaused(false)
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(rc.take(), check.DeepEquals, []string{"create vol2"})
+
+	agent.Resync()
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(rc.take(), check.DeepEquals, []string{"create vol1", "create vol2"})
+}
+
+func (s *AgentTestSuite) TestReloadPollInterval(c *check.C) {
+	path := filepath.Join(c.MkDir(), "config.json")
+	c.Assert(ioutil.WriteFile(path, []byte(`{"run": {"poll-interval": 500}}`), 0644), check.IsNil)
+
+	agent := NewVolumeAgent(s.socket, 20, &recordingCattle{}, "nfs")
+	app := cli.NewApp()
+	app.Flags = []cli.Flag{cli.StringFlag{Name: "config"}}
+	run := *command("run")
+	run.Action = func(ctx *cli.Context) {
+		agent.SetPollInterval(ctx.Int("poll-interval"))
+		reloadPollInterval(ctx, agent)
+	}
+	app.Commands = []cli.Command{run}
+	config.Wrap(app)
+	c.Assert(app.Run([]string{"convoy-agent", "--config", path, "run"}), check.IsNil)
+	c.Assert(agent.pollInterval(), check.Equals, 500*time.Millisecond)
+
+	c.Assert(ioutil.WriteFile(path, []byte(`{"run": {"poll-interval": 250}}`), 0644), check.IsNil)
+	c.Assert(config.Reload(), check.IsNil)
+	c.Assert(agent.pollInterval(), check.Equals, 250*time.Millisecond)
+}
+
+func (s *AgentTestSuite) TestIsSharedDriver(c *check.C) {
+	c.Assert(IsSharedDriver("glusterfs"), check.Equals, true)
+	c.Assert(IsSharedDriver("vfs"), check.Equals, false)
+	c.Assert(IsSharedDriver("devicemapper"), check.Equals, false)
+	c.Assert(IsSharedDriver("ebs"), check.Equals, false)
+}
@@ -2,6 +2,7 @@ package volume
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"net"
@@ -58,6 +59,11 @@ func (client *ConvoyClient) GetCurrVolumes() (Volume, error) {
 }
 
 func (client *ConvoyClient) DeleteVolume(name string) error {
+	return client.DeleteVolumeContext(context.Background(), name)
+}
+
+// DeleteVolumeContext deletes the named volume, giving up once ctx is done.
+func (client *ConvoyClient) DeleteVolumeContext(ctx context.Context, name string) error {
 	reqBody, err := json.Marshal(api.VolumeDeleteRequest{
 		VolumeName: name,
 	})
@@ -65,13 +71,44 @@ func (client *ConvoyClient) DeleteVolume(name string) error {
 		return err
 	}
 
-	err = client.doRequest("DELETE", "/v1/volumes/", reqBody, nil)
+	err = client.doRequest(ctx, "DELETE", "/v1/volumes/", reqBody, nil)
 	if isNotFoundError(err) {
 		return nil
 	}
 	return err
 }
 
+// ForgetVolumeContext removes the named volume from convoy but leaves its
+// data with the driver, so that it can be adopted again under another name.
+func (client *ConvoyClient) ForgetVolumeContext(ctx context.Context, name string) error {
+	reqBody, err := json.Marshal(api.VolumeDeleteRequest{
+		VolumeName:    name,
+		ReferenceOnly: true,
+	})
+	if err != nil {
+		return err
+	}
+	return client.doRequest(ctx, "DELETE", "/v1/volumes/", reqBody, nil)
+}
+
+// AdoptVolumeContext adds an existing volume of the driver, given by the
+// driver's ID for it, to convoy under name.
+func (client *ConvoyClient) AdoptVolumeContext(ctx context.Context, name, driver, driverVolumeID string) (*api.VolumeResponse, error) {
+	reqBody, err := json.Marshal(api.VolumeCreateRequest{
+		Name:           name,
+		DriverName:     driver,
+		DriverVolumeID: driverVolumeID,
+		Verbose:        true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	vol := &api.VolumeResponse{}
+	err = client.doRequest(ctx, "POST", "/v1/volumes/create", reqBody, vol)
+	return vol, err
+}
+
 // Not really production worthy as it does not support driver options
 func (client *ConvoyClient) CreateVolume(name string) error {
 	reqBody, err := json.Marshal(api.VolumeCreateRequest{
@@ -81,11 +118,76 @@ func (client *ConvoyClient) CreateVolume(name string) error {
 		return err
 	}
 
-	err = client.doRequest("POST", "/v1/volumes/create", reqBody, nil)
+	err = client.doRequest(context.Background(), "POST", "/v1/volumes/create", reqBody, nil)
 	return err
 }
 
+// CreateVolumeRequest creates a volume with the driver options in req and
+// returns it as convoy reports it.
+func (client *ConvoyClient) CreateVolumeRequest(req api.VolumeCreateRequest) (*api.VolumeResponse, error) {
+	req.Verbose = true
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	vol := &api.VolumeResponse{}
+	err = client.doRequest(context.Background(), "POST", "/v1/volumes/create", reqBody, vol)
+	return vol, err
+}
+
+// MountVolume mounts the named volume at mountPoint, or where the driver
+// chooses if it is empty, and returns the mounted volume.
+func (client *ConvoyClient) MountVolume(name, mountPoint string) (*api.VolumeResponse, error) {
+	reqBody, err := json.Marshal(api.VolumeMountRequest{
+		VolumeName: name,
+		MountPoint: mountPoint,
+		Verbose:    true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	vol := &api.VolumeResponse{}
+	err = client.doRequest(context.Background(), "POST", "/v1/volumes/mount", reqBody, vol)
+	return vol, err
+}
+
+func (client *ConvoyClient) UmountVolume(name string) error {
+	reqBody, err := json.Marshal(api.VolumeUmountRequest{
+		VolumeName: name,
+	})
+	if err != nil {
+		return err
+	}
+
+	return client.doRequest(context.Background(), "POST", "/v1/volumes/umount", reqBody, nil)
+}
+
+// CreateSnapshot snapshots the named volume. Convoy picks a name for the
+// snapshot if name is empty.
+func (client *ConvoyClient) CreateSnapshot(volumeName, name string) (*api.SnapshotResponse, error) {
+	reqBody, err := json.Marshal(api.SnapshotCreateRequest{
+		Name:       name,
+		VolumeName: volumeName,
+		Verbose:    true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &api.SnapshotResponse{}
+	err = client.doRequest(context.Background(), "POST", "/v1/snapshots/create", reqBody, snapshot)
+	return snapshot, err
+}
+
 func (client *ConvoyClient) GetVolume(name string) (*api.VolumeResponse, error) {
+	return client.GetVolumeContext(context.Background(), name)
+}
+
+// GetVolumeContext inspects the named volume, giving up once ctx is done. It
+// returns nil if the volume does not exist.
+func (client *ConvoyClient) GetVolumeContext(ctx context.Context, name string) (*api.VolumeResponse, error) {
 	reqBody, err := json.Marshal(api.VolumeInspectRequest{
 		VolumeName: name,
 	})
@@ -94,14 +196,14 @@ func (client *ConvoyClient) GetVolume(name string) (*api.VolumeResponse, error)
 	}
 
 	vol := &api.VolumeResponse{}
-	err = client.doRequest("GET", "/v1/volumes/", reqBody, vol)
+	err = client.doRequest(ctx, "GET", "/v1/volumes/", reqBody, vol)
 	if isNotFoundError(err) {
 		return nil, nil
 	}
 	return vol, err
 }
 
-func (client *ConvoyClient) doRequest(method string, path string, body []byte, respTarget interface{}) error {
+func (client *ConvoyClient) doRequest(ctx context.Context, method string, path string, body []byte, respTarget interface{}) error {
 	bodyBuf := bytes.NewBuffer(nil)
 	if _, err := bodyBuf.Write(body); err != nil {
 		return err
@@ -111,6 +213,7 @@ func (client *ConvoyClient) doRequest(method string, path string, body []byte, r
 	if err != nil {
 		return err
 	}
+	req = req.WithContext(ctx)
 	req.URL.Host = client.Addr
 	req.URL.Scheme = "http"
 	req.Header.Add("Context-Type", "application/json")
@@ -0,0 +1,76 @@
+package volume
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rancher/convoy-agent/health"
+)
+
+// SocketCheck reports whether convoy is accepting connections on its socket.
+func SocketCheck(socket string) health.Check {
+	return func() health.Status {
+		conn, err := net.DialTimeout("unix", socket, 2*time.Second)
+		if err != nil {
+			return health.Failing(err)
+		}
+		conn.Close()
+		return health.OK(socket)
+	}
+}
+
+// childProcess tracks a process the agent runs, such as the convoy daemon.
+type childProcess struct {
+	name string
+
+	mu        sync.Mutex
+	pid       int
+	startedAt time.Time
+	exitedAt  time.Time
+	exitErr   error
+}
+
+func (p *childProcess) started(pid int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pid = pid
+	p.startedAt = time.Now()
+	p.exitedAt = time.Time{}
+	p.exitErr = nil
+}
+
+func (p *childProcess) exited(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.exitedAt = time.Now()
+	p.exitErr = err
+}
+
+// Status reports whether the process is running. It is not ready until the
+// process has started, and unhealthy once it has exited.
+func (p *childProcess) Status() health.Status {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.exitedAt.IsZero() {
+		if p.startedAt.IsZero() {
+			return health.Status{Healthy: true, Message: "starting"}
+		}
+		status := health.OK(fmt.Sprintf("%s running as pid %d", p.name, p.pid))
+		status.Details = map[string]interface{}{
+			"pid":       p.pid,
+			"startedAt": p.startedAt.UTC().Format(time.RFC3339),
+		}
+		return status
+	}
+	message := fmt.Sprintf("%s exited", p.name)
+	if p.exitErr != nil {
+		message = fmt.Sprintf("%s exited: %v", p.name, p.exitErr)
+	}
+	return health.Status{
+		Message: message,
+		Details: map[string]interface{}{"exitedAt": p.exitedAt.UTC().Format(time.RFC3339)},
+	}
+}
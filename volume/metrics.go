@@ -0,0 +1,39 @@
+package volume
+
+import (
+	"net"
+	"net/url"
+
+	"github.com/rancher/convoy-agent/metrics"
+)
+
+var (
+	pollDuration = metrics.NewHistogram("convoy_agent_volume_poll_duration_seconds",
+		"Time taken to list convoy's volumes.", nil)
+	pollErrors = metrics.NewCounter("convoy_agent_volume_poll_errors_total",
+		"Failed attempts to list convoy's volumes.")
+	volumeEvents = metrics.NewCounter("convoy_agent_volume_events_total",
+		"Volume events sent to Cattle, by driver, event (create or delete) and result (sent or failed).", "driver", "event", "result")
+	trackedVolumes = metrics.NewGauge("convoy_agent_tracked_volumes",
+		"Volumes the agent currently tracks in convoy, by driver.", "driver")
+	convoyRestarts = metrics.NewCounter("convoy_agent_convoy_restarts_total",
+		"Times convoy stopped accepting connections on its socket and then came back.")
+)
+
+func recordVolumeEvent(driver, event string, err error) {
+	result := "sent"
+	if err != nil {
+		result = "failed"
+	}
+	volumeEvents.Inc(driver, event, result)
+}
+
+// isConnectError reports whether a request to convoy failed because nothing
+// accepted the connection on its socket, as while convoy restarts.
+func isConnectError(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	opErr, ok := err.(*net.OpError)
+	return ok && opErr.Op == "dial"
+}
@@ -0,0 +1,144 @@
+package volume
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/rancher/convoy/api"
+
+	"github.com/rancher/convoy-agent/cattle"
+	"github.com/rancher/convoy-agent/identity"
+)
+
+var migrateNamesCommand = cli.Command{
+	Name:   "migrate-names",
+	Usage:  "Rename the volumes listed in the volume-owners file to their account scoped names. Volumes must not be mounted, and the volume agents must be stopped on every host that sees the volumes. Remove them from the file once migrated",
+	Action: migrateNames,
+}
+
+// VolumeNamerFromContext builds the namer configured by the global
+// volume-naming and volume-owners flags.
+func VolumeNamerFromContext(c *cli.Context) (cattle.VolumeNamer, error) {
+	owners, err := cattle.LoadVolumeOwners(c.GlobalString("volume-owners"))
+	if err != nil {
+		return nil, err
+	}
+	return cattle.NewVolumeNamer(c.GlobalString("volume-naming"), owners)
+}
+
+func migrateNames(c *cli.Context) {
+	if c.GlobalString("volume-naming") != cattle.AccountNaming {
+		log.Fatalf("volume-naming must be %s to migrate volume names", cattle.AccountNaming)
+	}
+	driver := c.GlobalString("storagepool-driver")
+	if driver == "" {
+		log.Fatal("required field storagepool-driver has not been set")
+	}
+	owners, err := cattle.LoadVolumeOwners(c.GlobalString("volume-owners"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(owners) == 0 {
+		log.Fatal("required field volume-owners has not been set")
+	}
+	// A running agent would report the renames too, so hold its root dir
+	// to make sure the agent on this host is stopped.
+	id, err := identity.Load(c.GlobalString("storagepool-rootdir"))
+	if err != nil {
+		log.Fatalf("Stop the volume agent before migrating volume names. Error: %v", err)
+	}
+	defer id.Release()
+	namer, err := cattle.NewVolumeNamer(cattle.AccountNaming, owners)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cattleClient, err := cattle.NewCattleClient(c.GlobalString("url"), c.GlobalString("access-key"), c.GlobalString("secret-key"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	cattleClient.SetVolumeNamer(namer)
+	convoy, err := NewConvoyClient(c.GlobalString("socket"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = migrateVolumeNames(convoy, cattleClient, driver, namer, owners)
+	if err != nil {
+		id.Release()
+		log.Fatal(err)
+	}
+}
+
+// migrateVolumeNames renames the unprefixed volumes in owners to the names
+// namer gives them in their account, and reports the rename to Cattle. It
+// carries on past volumes it cannot migrate and returns an error naming them.
+func migrateVolumeNames(convoy *ConvoyClient, cattleClient cattle.CattleInterface, driver string, namer cattle.VolumeNamer, owners map[string]int64) error {
+	names := make([]string, 0, len(owners))
+	for name := range owners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	failed := []string{}
+	for _, name := range names {
+		if err := migrateVolumeName(convoy, cattleClient, driver, namer.ConvoyName(owners[name], name), name); err != nil {
+			log.Errorf("Error migrating volume %s [%v]", name, err)
+			failed = append(failed, name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("Cannot migrate volumes %v", failed)
+	}
+	return nil
+}
+
+func migrateVolumeName(convoy *ConvoyClient, cattleClient cattle.CattleInterface, driver, target, name string) error {
+	vol, err := convoy.GetVolume(name)
+	if err != nil {
+		return err
+	}
+	if vol == nil {
+		// An earlier run may have renamed the volume and then failed to
+		// tell Cattle, so the rename is reported again.
+		if migrated, err := convoy.GetVolume(target); err != nil {
+			return err
+		} else if migrated != nil {
+			log.Infof("Volume %s is already renamed to %s, reporting the rename to cattle", name, target)
+			return reportRename(cattleClient, driver, *migrated, api.VolumeResponse{Name: name})
+		}
+		return fmt.Errorf("volume does not exist")
+	}
+	if vol.MountPoint != "" {
+		return fmt.Errorf("volume is mounted at %s", vol.MountPoint)
+	}
+	existing, err := convoy.GetVolume(target)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("a volume named %s exists", target)
+	}
+
+	renamed, err := renameVolume(context.Background(), convoy, vol, target)
+	if err != nil {
+		return err
+	}
+	return reportRename(cattleClient, driver, *renamed, *vol)
+}
+
+// reportRename tells Cattle a volume was renamed from vol to renamed. It is
+// safe to repeat, so that a failed report can be retried by running the
+// migration again.
+func reportRename(cattleClient cattle.CattleInterface, driver string, renamed, vol api.VolumeResponse) error {
+	if err := cattleClient.CreateVolume(driver, renamed); err != nil {
+		return fmt.Errorf("renamed to %s, but cannot report it to cattle, run the migration again: %v", renamed.Name, err)
+	}
+	if err := cattleClient.DeleteVolume(driver, vol); err != nil {
+		return fmt.Errorf("renamed to %s, but cannot report the old name removed to cattle, run the migration again: %v", renamed.Name, err)
+	}
+	log.Infof("Migrated volume %s to %s", vol.Name, renamed.Name)
+	return nil
+}
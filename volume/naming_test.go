@@ -0,0 +1,51 @@
+package volume
+
+import (
+	"github.com/rancher/convoy-agent/cattle"
+	"gopkg.in/check.v1"
+)
+
+// Migrating names renames volumes like quarantining does, so it is tested
+// against the quarantine suite's fake convoy.
+
+func (s *QuarantineTestSuite) TestMigrateVolumeNames(c *check.C) {
+	s.volumes = map[string]string{
+		"data":        "/vfs/data",
+		"logs":        "/vfs/logs",
+		"acct6--done": "/vfs/done",
+	}
+	s.mounted["logs"] = true
+	owners := map[string]int64{"data": 5, "logs": 5, "done": 6, "gone": 7}
+	namer, err := cattle.NewVolumeNamer(cattle.AccountNaming, owners)
+	c.Assert(err, check.IsNil)
+	rc := &recordingCattle{}
+
+	err = migrateVolumeNames(s.convoy, rc, "vfs", namer, owners)
+	c.Assert(err, check.ErrorMatches, `Cannot migrate volumes \[gone logs\]`)
+	c.Assert(s.volumes, check.DeepEquals, map[string]string{
+		"acct5--data": "/vfs/data",
+		"logs":        "/vfs/logs",
+		"acct6--done": "/vfs/done",
+	})
+	c.Assert(s.destroyed, check.HasLen, 0)
+	// The volume already renamed is reported again, in case an earlier run
+	// could not tell Cattle.
+	c.Assert(rc.take(), check.DeepEquals, []string{"create acct5--data", "create acct6--done", "delete data", "delete done"})
+}
+
+func (s *QuarantineTestSuite) TestMigrateVolumeNamesRetriesReport(c *check.C) {
+	s.volumes = map[string]string{"data": "/vfs/data"}
+	owners := map[string]int64{"data": 5}
+	namer, err := cattle.NewVolumeNamer(cattle.AccountNaming, owners)
+	c.Assert(err, check.IsNil)
+	fc := &failingCattle{fail: true}
+
+	err = migrateVolumeNames(s.convoy, fc, "vfs", namer, owners)
+	c.Assert(err, check.ErrorMatches, `Cannot migrate volumes \[data\]`)
+	c.Assert(s.volumes, check.DeepEquals, map[string]string{"acct5--data": "/vfs/data"})
+	c.Assert(fc.take(), check.IsNil)
+
+	fc.setFailing(false)
+	c.Assert(migrateVolumeNames(s.convoy, fc, "vfs", namer, owners), check.IsNil)
+	c.Assert(fc.take(), check.DeepEquals, []string{"create acct5--data", "delete data"})
+}
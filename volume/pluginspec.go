@@ -0,0 +1,141 @@
+package volume
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const pluginDialTimeout = 2 * time.Second
+
+// pluginSpec registers convoy with Docker as a volume plugin, by writing a
+// spec file that points Docker at the convoy socket. Files ending in .json
+// are written in Docker's JSON spec format, others hold just the address.
+type pluginSpec struct {
+	path string
+	addr string
+	// localPrefix is where the agent sees the host's root, for checking
+	// whether the socket of an existing spec is in use.
+	localPrefix string
+}
+
+// pluginSpecFile is Docker's JSON plugin spec format.
+type pluginSpecFile struct {
+	Name string
+	Addr string
+}
+
+// newPluginSpec returns the spec at path for the convoy socket. hostSocket
+// is the path of the socket on the host, which Docker connects to. It must
+// be the end of socket, which is where the agent sees the socket, such as
+// /host/var/run/convoy.sock for /var/run/convoy.sock. It defaults to socket.
+func newPluginSpec(path, socket, hostSocket string) (*pluginSpec, error) {
+	if hostSocket == "" {
+		hostSocket = socket
+	}
+	if !strings.HasSuffix(socket, hostSocket) {
+		return nil, fmt.Errorf("Cannot find plugin socket %v at convoy socket %v", hostSocket, socket)
+	}
+	return &pluginSpec{
+		path:        path,
+		addr:        "unix://" + hostSocket,
+		localPrefix: strings.TrimSuffix(socket, hostSocket),
+	}, nil
+}
+
+// Register writes the spec, replacing a stale spec left behind by an agent
+// that is gone. It refuses to replace the spec of an agent whose socket is
+// still accepting connections.
+func (p *pluginSpec) Register() error {
+	addr, err := p.read()
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Cannot read plugin spec. Path: %v. Error: %v", p.path, err)
+	}
+	if err == nil && addr != p.addr {
+		if p.inUse(addr) {
+			return fmt.Errorf("Plugin spec belongs to another running agent. Path: %v. Address: %v", p.path, addr)
+		}
+		logrus.Warnf("Replacing stale plugin spec %v, nothing is listening on %v", p.path, addr)
+	}
+
+	content := []byte(p.addr + "\n")
+	if filepath.Ext(p.path) == ".json" {
+		name := strings.TrimSuffix(filepath.Base(p.path), ".json")
+		if content, err = json.Marshal(pluginSpecFile{Name: name, Addr: p.addr}); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(p.path), 0755); err != nil {
+		return fmt.Errorf("Cannot create plugin dir. Path: %v. Error: %v", filepath.Dir(p.path), err)
+	}
+	tmp := fmt.Sprintf("%s.%d.tmp", p.path, os.Getpid())
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return fmt.Errorf("Cannot write plugin spec. Path: %v. Error: %v", p.path, err)
+	}
+	if err := os.Rename(tmp, p.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("Cannot write plugin spec. Path: %v. Error: %v", p.path, err)
+	}
+	logrus.Infof("Registered volume plugin %v at %v", p.addr, p.path)
+	return nil
+}
+
+// Unregister removes the spec, unless another agent has replaced it since.
+func (p *pluginSpec) Unregister() error {
+	addr, err := p.read()
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot read plugin spec. Path: %v. Error: %v", p.path, err)
+	}
+	if addr != p.addr {
+		logrus.Infof("Leaving plugin spec %v alone, it now points at %v", p.path, addr)
+		return nil
+	}
+	if err := os.Remove(p.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Cannot remove plugin spec. Path: %v. Error: %v", p.path, err)
+	}
+	logrus.Infof("Unregistered volume plugin %v", p.path)
+	return nil
+}
+
+// read returns the address in the existing spec.
+func (p *pluginSpec) read() (string, error) {
+	content, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return "", err
+	}
+	if filepath.Ext(p.path) == ".json" {
+		spec := pluginSpecFile{}
+		if err := json.Unmarshal(content, &spec); err != nil {
+			return "", err
+		}
+		return spec.Addr, nil
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// inUse reports whether something accepts connections at addr.
+func (p *pluginSpec) inUse(addr string) bool {
+	network, address := "unix", addr
+	if i := strings.Index(addr, "://"); i >= 0 {
+		network, address = addr[:i], addr[i+3:]
+	}
+	if network == "unix" {
+		address = p.localPrefix + address
+	}
+	conn, err := net.DialTimeout(network, address, pluginDialTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
@@ -0,0 +1,85 @@
+package volume
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/check.v1"
+)
+
+type PluginSpecTestSuite struct {
+	dir string
+}
+
+var _ = check.Suite(&PluginSpecTestSuite{})
+
+func (s *PluginSpecTestSuite) SetUpTest(c *check.C) {
+	s.dir = c.MkDir()
+}
+
+func (s *PluginSpecTestSuite) read(c *check.C, name string) string {
+	content, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+	c.Assert(err, check.IsNil)
+	return string(content)
+}
+
+func (s *PluginSpecTestSuite) TestRegister(c *check.C) {
+	path := filepath.Join(s.dir, "plugins", "convoy-nfs.spec")
+	spec, err := newPluginSpec(path, "/host/var/run/convoy-nfs.sock", "/var/run/convoy-nfs.sock")
+	c.Assert(err, check.IsNil)
+	c.Assert(spec.localPrefix, check.Equals, "/host")
+
+	c.Assert(spec.Register(), check.IsNil)
+	c.Assert(s.read(c, "plugins/convoy-nfs.spec"), check.Equals, "unix:///var/run/convoy-nfs.sock\n")
+	files, err := ioutil.ReadDir(filepath.Dir(path))
+	c.Assert(err, check.IsNil)
+	c.Assert(files, check.HasLen, 1)
+
+	// Registering again, as a restarted agent does, keeps the spec.
+	c.Assert(spec.Register(), check.IsNil)
+
+	c.Assert(spec.Unregister(), check.IsNil)
+	_, err = os.Stat(path)
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+	c.Assert(spec.Unregister(), check.IsNil)
+}
+
+func (s *PluginSpecTestSuite) TestJSON(c *check.C) {
+	spec, err := newPluginSpec(filepath.Join(s.dir, "convoy.json"), "/var/run/convoy.sock", "")
+	c.Assert(err, check.IsNil)
+	c.Assert(spec.Register(), check.IsNil)
+	c.Assert(s.read(c, "convoy.json"), check.Equals, `{"Name":"convoy","Addr":"unix:///var/run/convoy.sock"}`)
+	addr, err := spec.read()
+	c.Assert(err, check.IsNil)
+	c.Assert(addr, check.Equals, "unix:///var/run/convoy.sock")
+}
+
+func (s *PluginSpecTestSuite) TestSocketOutsidePrefix(c *check.C) {
+	_, err := newPluginSpec(filepath.Join(s.dir, "convoy.spec"), "/host/var/run/convoy.sock", "/var/run/other.sock")
+	c.Assert(err, check.ErrorMatches, "Cannot find plugin socket /var/run/other.sock at convoy socket /host/var/run/convoy.sock")
+}
+
+func (s *PluginSpecTestSuite) TestStaleAndLiveSpecs(c *check.C) {
+	path := filepath.Join(s.dir, "convoy.spec")
+	// The agent sees the host's root at s.dir.
+	spec, err := newPluginSpec(path, s.dir+"/convoy.sock", "/convoy.sock")
+	c.Assert(err, check.IsNil)
+
+	// Nothing listens on the socket of a spec left behind by a dead agent.
+	c.Assert(ioutil.WriteFile(path, []byte("unix:///old.sock\n"), 0644), check.IsNil)
+	c.Assert(spec.Register(), check.IsNil)
+	c.Assert(s.read(c, "convoy.spec"), check.Equals, "unix:///convoy.sock\n")
+
+	listener, err := net.Listen("unix", filepath.Join(s.dir, "live.sock"))
+	c.Assert(err, check.IsNil)
+	defer listener.Close()
+	c.Assert(ioutil.WriteFile(path, []byte("unix:///live.sock\n"), 0644), check.IsNil)
+	c.Assert(spec.Register(), check.ErrorMatches, "Plugin spec belongs to another running agent.*")
+	c.Assert(s.read(c, "convoy.spec"), check.Equals, "unix:///live.sock\n")
+
+	// Unregistering leaves the other agent's spec alone.
+	c.Assert(spec.Unregister(), check.IsNil)
+	c.Assert(s.read(c, "convoy.spec"), check.Equals, "unix:///live.sock\n")
+}
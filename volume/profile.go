@@ -0,0 +1,557 @@
+package volume
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/rancher/go-rancher-metadata/metadata"
+
+	"github.com/rancher/convoy-agent/config"
+)
+
+const (
+	convoyRootBase    = "/var/lib/rancher/convoy"
+	hostSocketFmt     = "/var/run/convoy-%s.sock"
+	containerRootPath = "/host"
+	shareMntCommand   = "share-mnt"
+	sharedMountEnv    = "CONVOY_AGENT_SHARED_MOUNT"
+	targetPidEnv      = "CONVOY_AGENT_TARGET_PID"
+)
+
+var (
+	// pluginDir is where Docker looks for volume plugin specs.
+	pluginDir = "/etc/docker/plugins"
+	// ec2MetadataUrl serves the EC2 instance metadata EFS mounts are found with.
+	ec2MetadataUrl = "http://169.254.169.254/latest/meta-data"
+	fuseDevice     = "/dev/fuse"
+)
+
+var runProfileCommand = cli.Command{
+	Name:   "run",
+	Usage:  "Start convoy-agent as a volume agent set up for a storage driver: run --profile=nfs|efs|glusterfs|longhorn",
+	Action: runProfile,
+}
+
+var profileFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "profile",
+		Usage: "the storage driver to set up: nfs, efs, glusterfs or longhorn",
+	},
+	cli.StringFlag{
+		Name:  "metadata-url",
+		Value: defaultMetadataUrl,
+		Usage: "rancher-metadata URL to read the stack and the service's driver settings from",
+	},
+	cli.BoolFlag{
+		Name:   "shared-mount",
+		Usage:  "set when the agent already runs under share-mnt. Profiles that mount storage re-run the agent under share-mnt otherwise",
+		EnvVar: sharedMountEnv,
+	},
+	cli.IntFlag{
+		Name:   "target-pid",
+		Usage:  "pid of a process in the network namespace to mount NFS from. Defaults to the agent's parent",
+		EnvVar: targetPidEnv,
+	},
+}
+
+// profile sets up the agent and convoy for a storage driver.
+type profile interface {
+	// resolve fills in the driver's convoy flags and mounts from metadata
+	// and the environment.
+	resolve(env *profileEnv, l *launch) error
+	// prepare sets up the host for convoy. It runs under share-mnt for
+	// profiles that ask for a shared mount.
+	prepare(l *launch) error
+}
+
+var profiles = map[string]profile{
+	"nfs":       nfsProfile{},
+	"efs":       efsProfile{},
+	"glusterfs": glusterfsProfile{},
+	"longhorn":  longhornProfile{},
+}
+
+func profileNames() []string {
+	names := []string{}
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// launch is what a profile resolved the agent to run with.
+type launch struct {
+	stackName  string
+	stackUUID  string
+	driver     string
+	socket     string
+	hostSocket string
+	pluginSpec string
+	convoyRoot string
+	// rootFromFlag is set when the convoy root was given on the command
+	// line, which profiles leave alone.
+	rootFromFlag bool
+	components   string
+	cattleUrl    string
+	accessKey    string
+	secretKey    string
+	// sharedMount runs the agent under share-mnt, so that what it mounts
+	// under the convoy root is visible on the host.
+	sharedMount bool
+	// sharedStorage is set when every host sees the volumes.
+	sharedStorage bool
+	targetPid     int
+	nfs           *nfsMount
+	convoyArgs    map[string][]string
+}
+
+func (l *launch) hasComponent(component string) bool {
+	return strings.Contains(l.components, component)
+}
+
+func (l *launch) settings() agentSettings {
+	return agentSettings{
+		socket:         l.socket,
+		driver:         l.driver,
+		components:     l.components,
+		pluginSpec:     l.pluginSpec,
+		pluginSocket:   l.hostSocket,
+		sharedStorage:  l.sharedStorage,
+		convoyDefaults: l.convoyArgs,
+	}
+}
+
+// profileEnv looks up a profile's settings in environment variables, and
+// falls back to the metadata of the agent's service. It connects to
+// rancher-metadata once, the first time a setting is not in the environment.
+type profileEnv struct {
+	metadataUrl string
+	getenv      func(string) string
+	client      *metadata.Client
+	service     *metadata.Service
+}
+
+func newProfileEnv(metadataUrl string) *profileEnv {
+	return &profileEnv{metadataUrl: metadataUrl, getenv: os.Getenv}
+}
+
+func (e *profileEnv) metadataClient() (*metadata.Client, error) {
+	if e.client == nil {
+		logrus.Infof("Waiting for metadata at %v", e.metadataUrl)
+		client, err := metadata.NewClientAndWait(e.metadataUrl)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot reach metadata. Url: %v. Error: %v", e.metadataUrl, err)
+		}
+		e.client = client
+	}
+	return e.client, nil
+}
+
+// stackValue returns a setting of the agent's stack, such as its name.
+func (e *profileEnv) stackValue(envVar, key string) (string, error) {
+	if value := e.getenv(envVar); value != "" {
+		return value, nil
+	}
+	client, err := e.metadataClient()
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.SendRequest("/self/stack/" + key)
+	if err != nil {
+		return "", fmt.Errorf("Cannot get stack %s from metadata. Error: %v", key, err)
+	}
+	var value string
+	if err := json.Unmarshal(resp, &value); err != nil {
+		return "", fmt.Errorf("Cannot parse stack %s from metadata. Error: %v", key, err)
+	}
+	if value == "" {
+		return "", fmt.Errorf("Stack %s is empty in metadata", key)
+	}
+	return value, nil
+}
+
+// serviceValue returns a setting from envVar, or else from the agent's
+// service metadata. Missing required settings are an error.
+func (e *profileEnv) serviceValue(envVar, key string, required bool) (string, error) {
+	if envVar != "" {
+		if value := e.getenv(envVar); value != "" {
+			return value, nil
+		}
+	}
+	if e.service == nil {
+		client, err := e.metadataClient()
+		if err != nil {
+			return "", err
+		}
+		service, err := client.GetSelfService()
+		if err != nil {
+			return "", fmt.Errorf("Cannot get service metadata. Error: %v", err)
+		}
+		e.service = &service
+	}
+
+	value := ""
+	switch v := e.service.Metadata[key].(type) {
+	case nil:
+	case string:
+		value = v
+	case []interface{}:
+		items := []string{}
+		for _, item := range v {
+			items = append(items, fmt.Sprint(item))
+		}
+		value = strings.Join(items, ",")
+	default:
+		value = fmt.Sprint(v)
+	}
+	if value == "" && required {
+		if envVar != "" {
+			return "", fmt.Errorf("Required setting is missing. Set %s or the service metadata key %s", envVar, key)
+		}
+		return "", fmt.Errorf("Required setting is missing. Set the service metadata key %s", key)
+	}
+	return value, nil
+}
+
+func runProfile(c *cli.Context) {
+	if c.GlobalBool("debug") {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+
+	name := c.String("profile")
+	p, ok := profiles[name]
+	if !ok {
+		logrus.Fatalf("Unknown profile %q, expected one of %s", name, strings.Join(profileNames(), ", "))
+	}
+	l, err := resolveLaunch(c, p, newProfileEnv(c.String("metadata-url")))
+	if err != nil {
+		logrus.Fatalf("Cannot set up %s profile. Error: %v", name, err)
+	}
+
+	if l.sharedMount && !c.Bool("shared-mount") {
+		err := execSharedMount(l)
+		logrus.Fatalf("Cannot run agent under %s. Error: %v", shareMntCommand, err)
+	}
+
+	if err := p.prepare(l); err != nil {
+		logrus.Fatalf("Cannot set up %s profile. Error: %v", name, err)
+	}
+	runVolumeAgent(c, l.settings())
+}
+
+// resolveLaunch works out the settings shared by every profile from the
+// agent's stack, then lets the profile fill in its own. Flags given on the
+// command line take precedence over what the profile picks.
+func resolveLaunch(c *cli.Context, p profile, env *profileEnv) (*launch, error) {
+	stackName, err := env.stackValue("STACK_NAME", "name")
+	if err != nil {
+		return nil, err
+	}
+	stackUUID, err := env.stackValue("STACK_UUID", "uuid")
+	if err != nil {
+		return nil, err
+	}
+
+	hostSocket := fmt.Sprintf(hostSocketFmt, stackName)
+	l := &launch{
+		stackName:  stackName,
+		stackUUID:  stackUUID,
+		driver:     stackName,
+		socket:     containerRootPath + hostSocket,
+		hostSocket: hostSocket,
+		pluginSpec: filepath.Join(pluginDir, stackName+".spec"),
+		convoyRoot: filepath.Join(convoyRootBase, stackName+"-"+stackUUID),
+		components: c.String("components"),
+		cattleUrl:  c.GlobalString("url"),
+		accessKey:  c.GlobalString("access-key"),
+		secretKey:  c.GlobalString("secret-key"),
+		targetPid:  c.Int("target-pid"),
+		convoyArgs: map[string][]string{},
+
+		sharedStorage: c.Bool("shared-storage"),
+	}
+	if driver := c.GlobalString("storagepool-driver"); driver != "" {
+		l.driver = driver
+	}
+	if c.GlobalIsSet("socket") {
+		l.socket = c.GlobalString("socket")
+		l.hostSocket = l.socket
+	}
+	if config.IsSet(c, "plugin-spec") {
+		l.pluginSpec = c.String("plugin-spec")
+	}
+	if config.IsSet(c, "plugin-socket") {
+		l.hostSocket = c.String("plugin-socket")
+	}
+	if config.IsSet(c, convoyFlagNamePrefix+"root") {
+		l.convoyRoot = c.String(convoyFlagNamePrefix + "root")
+		l.rootFromFlag = true
+	}
+
+	if err := p.resolve(env, l); err != nil {
+		return nil, err
+	}
+	l.convoyArgs["root"] = []string{l.convoyRoot}
+	return l, nil
+}
+
+// execSharedMount replaces the agent with itself running under share-mnt,
+// which makes mounts under the convoy root propagate to the host.
+func execSharedMount(l *launch) error {
+	shareMnt, err := exec.LookPath(shareMntCommand)
+	if err != nil {
+		return err
+	}
+	self, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(l.convoyRoot, 0755); err != nil {
+		return err
+	}
+
+	env := append(os.Environ(), sharedMountEnv+"=true")
+	if l.targetPid == 0 {
+		env = append(env, fmt.Sprintf("%s=%d", targetPidEnv, os.Getppid()))
+	}
+	args := append([]string{shareMnt, l.convoyRoot, "--", self}, os.Args[1:]...)
+	logrus.Infof("Running agent under %s with args: %s", shareMntCommand, args[1:])
+	return syscall.Exec(shareMnt, args, env)
+}
+
+// execCommand runs a command the profiles set the host up with.
+var execCommand = func(name string, args ...string) error {
+	logrus.Infof("Running: %s %s", name, strings.Join(args, " "))
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Command failed. Command: %s %s. Error: %v. Output: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// nfsMount is an NFS export convoy keeps its volumes in.
+type nfsMount struct {
+	host       string
+	dir        string
+	options    string
+	mountPoint string
+}
+
+func (m *nfsMount) source() string {
+	return m.host + ":" + m.dir
+}
+
+// vfsOnNFS has convoy keep its volumes as directories on the NFS mount.
+func vfsOnNFS(l *launch, m *nfsMount) {
+	m.mountPoint = filepath.Join(l.convoyRoot, "mnt")
+	l.nfs = m
+	l.sharedMount = true
+	l.sharedStorage = true
+	l.convoyArgs["drivers"] = []string{"vfs"}
+	l.convoyArgs["driver-opts"] = []string{"vfs.path=" + m.mountPoint}
+	l.convoyArgs["ignore-docker-delete"] = []string{"true"}
+	l.convoyArgs["create-on-docker-mount"] = []string{"true"}
+}
+
+// mountNFS mounts the export from the network namespace of the target
+// process, starting the RPC services NFS needs there first. It leaves an
+// existing mount alone.
+func mountNFS(l *launch) error {
+	if l.targetPid == 0 {
+		return fmt.Errorf("Cannot mount NFS without a target pid")
+	}
+	m := l.nfs
+	nsenter := []string{"-t", strconv.Itoa(l.targetPid), "-n"}
+	for _, service := range []string{"rpcbind", "rpc.statd"} {
+		if err := execCommand("nsenter", append(nsenter, service)...); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(m.mountPoint, 0755); err != nil {
+		return fmt.Errorf("Cannot create mount point. Path: %v. Error: %v", m.mountPoint, err)
+	}
+	if execCommand("mountpoint", "-q", m.mountPoint) == nil {
+		logrus.Infof("%v is already mounted", m.mountPoint)
+		return nil
+	}
+
+	args := append(nsenter, "mount", "-t", "nfs")
+	if m.options != "" {
+		args = append(args, "-o", m.options)
+	}
+	args = append(args, m.source(), m.mountPoint)
+	return execCommand("nsenter", args...)
+}
+
+type nfsProfile struct{}
+
+func (nfsProfile) resolve(env *profileEnv, l *launch) error {
+	m := &nfsMount{}
+	var err error
+	if m.host, err = env.serviceValue("MNT_HOST", "nfs_server", true); err != nil {
+		return err
+	}
+	if m.dir, err = env.serviceValue("MNT_DIR", "mount_dir", true); err != nil {
+		return err
+	}
+	if m.options, err = env.serviceValue("MNT_OPTS", "mount_opts", false); err != nil {
+		return err
+	}
+	vfsOnNFS(l, m)
+	return nil
+}
+
+func (nfsProfile) prepare(l *launch) error {
+	return mountNFS(l)
+}
+
+// efsProfile mounts an EFS file system from the mount target in the host's
+// availability zone.
+type efsProfile struct{}
+
+func (efsProfile) resolve(env *profileEnv, l *launch) error {
+	id, err := env.serviceValue("MNT_HOST", "efs_id", true)
+	if err != nil {
+		return err
+	}
+	region, err := env.serviceValue("AWS_REGION", "aws_region", true)
+	if err != nil {
+		return err
+	}
+	dir, err := env.serviceValue("MNT_DIR", "mount_dir", true)
+	if err != nil {
+		return err
+	}
+	zone, err := availabilityZone()
+	if err != nil {
+		return err
+	}
+	vfsOnNFS(l, &nfsMount{
+		host:    fmt.Sprintf("%s.%s.efs.%s.amazonaws.com", zone, id, region),
+		dir:     dir,
+		options: "nfsvers=4.1",
+	})
+	return nil
+}
+
+func (efsProfile) prepare(l *launch) error {
+	return mountNFS(l)
+}
+
+func availabilityZone() (string, error) {
+	url := ec2MetadataUrl + "/placement/availability-zone"
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", fmt.Errorf("Cannot get availability zone. Url: %v. Error: %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("Cannot get availability zone. Url: %v. Error: %v", url, err)
+	}
+	zone := strings.TrimSpace(string(body))
+	if resp.StatusCode != http.StatusOK || zone == "" {
+		return "", fmt.Errorf("Cannot get availability zone. Url: %v. Status: %v", url, resp.Status)
+	}
+	return zone, nil
+}
+
+type glusterfsProfile struct{}
+
+func (glusterfsProfile) resolve(env *profileEnv, l *launch) error {
+	servers, err := env.serviceValue("", "servers", true)
+	if err != nil {
+		return err
+	}
+	pool, err := env.serviceValue("", "volume_pool", true)
+	if err != nil {
+		return err
+	}
+	l.sharedMount = true
+	l.convoyArgs["drivers"] = []string{"glusterfs"}
+	l.convoyArgs["driver-opts"] = []string{
+		"glusterfs.defaultvolumepool=" + pool,
+		"glusterfs.servers=" + servers,
+	}
+	return nil
+}
+
+func (glusterfsProfile) prepare(l *launch) error {
+	if _, err := os.Stat(fuseDevice); err == nil {
+		return nil
+	}
+	logrus.Warnf("Cannot find %v, loading the fuse module", fuseDevice)
+	if err := execCommand("modprobe", "fuse"); err != nil {
+		return err
+	}
+	if _, err := os.Stat(fuseDevice); err != nil {
+		return fmt.Errorf("Cannot find %v after loading the fuse module", fuseDevice)
+	}
+	return nil
+}
+
+// longhornProfile shares one convoy root between the stack's driver and
+// agent containers. Only the driver needs a shared mount.
+type longhornProfile struct{}
+
+func (longhornProfile) resolve(env *profileEnv, l *launch) error {
+	if l.cattleUrl == "" || l.accessKey == "" || l.secretKey == "" {
+		return fmt.Errorf("The longhorn driver needs the url, access-key and secret-key of cattle")
+	}
+	if !l.rootFromFlag {
+		l.convoyRoot = filepath.Join(convoyRootBase, "longhorn")
+	}
+	l.convoyArgs["drivers"] = []string{"longhorn"}
+	l.convoyArgs["driver-opts"] = []string{
+		"lh.rancherurl=" + l.cattleUrl,
+		"lh.rancheraccesskey=" + l.accessKey,
+		"lh.ranchersecretkey=" + l.secretKey,
+	}
+	if l.hasComponent("driver") {
+		l.sharedMount = true
+		l.convoyArgs["ignore-docker-delete"] = []string{"true"}
+	}
+	return nil
+}
+
+func (longhornProfile) prepare(l *launch) error {
+	if !l.hasComponent("driver") {
+		return nil
+	}
+	if err := execCommand("mount", "--rbind", containerRootPath+"/dev", "/dev"); err != nil {
+		return err
+	}
+	return removeStaleSocket(l.socket)
+}
+
+// removeStaleSocket removes a convoy socket left behind by a previous run,
+// which would stop convoy from listening on it.
+func removeStaleSocket(socket string) error {
+	if _, err := os.Stat(socket); err != nil {
+		return nil
+	}
+	if conn, err := net.Dial("unix", socket); err == nil {
+		conn.Close()
+		return fmt.Errorf("Convoy socket is in use by another process. Path: %v", socket)
+	}
+	logrus.Infof("Removing stale convoy socket %v", socket)
+	if err := os.Remove(socket); err != nil {
+		return fmt.Errorf("Cannot remove stale convoy socket. Path: %v. Error: %v", socket, err)
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+package volume
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"gopkg.in/check.v1"
+)
+
+type ProfileTestSuite struct {
+	metadata *httptest.Server
+	ec2      *httptest.Server
+	service  map[string]interface{}
+	requests []string
+	env      map[string]string
+	commands []string
+	// mounted is the mount point mountpoint reports as mounted.
+	mounted string
+
+	execCommand    func(string, ...string) error
+	ec2MetadataUrl string
+}
+
+var _ = check.Suite(&ProfileTestSuite{})
+
+func (s *ProfileTestSuite) SetUpTest(c *check.C) {
+	s.requests = nil
+	s.commands = nil
+	s.mounted = ""
+	s.env = map[string]string{}
+	s.service = map[string]interface{}{}
+
+	s.metadata = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests = append(s.requests, r.URL.Path)
+		switch r.URL.Path {
+		case "/version":
+			w.Write([]byte("2015-12-19"))
+		case "/self/stack/name":
+			json.NewEncoder(w).Encode("nfs")
+		case "/self/stack/uuid":
+			json.NewEncoder(w).Encode("1234")
+		case "/self/service":
+			json.NewEncoder(w).Encode(map[string]interface{}{"name": "convoy-nfs", "metadata": s.service})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	s.ec2 = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/placement/availability-zone" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("us-west-2a"))
+	}))
+	s.ec2MetadataUrl, ec2MetadataUrl = ec2MetadataUrl, s.ec2.URL
+
+	s.execCommand = execCommand
+	execCommand = func(name string, args ...string) error {
+		command := strings.Join(append([]string{name}, args...), " ")
+		s.commands = append(s.commands, command)
+		if name == "mountpoint" && args[len(args)-1] != s.mounted {
+			return fmt.Errorf("exit status 1")
+		}
+		return nil
+	}
+}
+
+func (s *ProfileTestSuite) TearDownTest(c *check.C) {
+	s.metadata.Close()
+	s.ec2.Close()
+	ec2MetadataUrl = s.ec2MetadataUrl
+	execCommand = s.execCommand
+}
+
+func (s *ProfileTestSuite) profileEnv() *profileEnv {
+	env := newProfileEnv(s.metadata.URL)
+	env.getenv = func(name string) string {
+		return s.env[name]
+	}
+	return env
+}
+
+// resolve runs the run command with args far enough to resolve its profile,
+// and returns the launch and the convoy daemon's arguments.
+func (s *ProfileTestSuite) resolve(c *check.C, args ...string) (*launch, []string, error) {
+	var l *launch
+	var convoyArgs []string
+	var err error
+
+	app := cli.NewApp()
+	app.Flags = []cli.Flag{
+		cli.StringFlag{Name: "url"},
+		cli.StringFlag{Name: "access-key"},
+		cli.StringFlag{Name: "secret-key"},
+		cli.StringFlag{Name: "storagepool-driver"},
+		cli.StringFlag{Name: "socket, s", Value: "/var/run/convoy/convoy.sock"},
+	}
+	run := *command("run")
+	run.Action = func(ctx *cli.Context) {
+		p, ok := profiles[ctx.String("profile")]
+		c.Assert(ok, check.Equals, true)
+		l, err = resolveLaunch(ctx, p, s.profileEnv())
+		if err == nil {
+			convoyArgs = buildConvoyCmdArgs(ctx, l.socket, l.convoyArgs)
+		}
+	}
+	app.Commands = []cli.Command{run}
+	c.Assert(app.Run(append([]string{"convoy-agent"}, args...)), check.IsNil)
+	return l, convoyArgs, err
+}
+
+func (s *ProfileTestSuite) TestNFS(c *check.C) {
+	s.service["nfs_server"] = "10.0.0.5"
+	s.service["mount_dir"] = "/exports"
+	s.service["mount_opts"] = "vers=4"
+
+	l, args, err := s.resolve(c, "run", "--profile", "nfs")
+	c.Assert(err, check.IsNil)
+	c.Assert(l.driver, check.Equals, "nfs")
+	c.Assert(l.hostSocket, check.Equals, "/var/run/convoy-nfs.sock")
+	c.Assert(l.pluginSpec, check.Equals, "/etc/docker/plugins/nfs.spec")
+	c.Assert(l.sharedMount, check.Equals, true)
+	c.Assert(l.sharedStorage, check.Equals, true)
+	c.Assert(*l.nfs, check.DeepEquals, nfsMount{
+		host:       "10.0.0.5",
+		dir:        "/exports",
+		options:    "vers=4",
+		mountPoint: "/var/lib/rancher/convoy/nfs-1234/mnt",
+	})
+	c.Assert(args, check.DeepEquals, []string{
+		"--socket=/host/var/run/convoy-nfs.sock",
+		"daemon",
+		"--root=/var/lib/rancher/convoy/nfs-1234",
+		"--drivers=vfs",
+		"--driver-opts=vfs.path=/var/lib/rancher/convoy/nfs-1234/mnt",
+		"--ignore-docker-delete=true",
+		"--create-on-docker-mount=true",
+	})
+
+	// The metadata client is reused for every setting.
+	c.Assert(s.requests, check.DeepEquals, []string{
+		"/version",
+		"/self/stack/name",
+		"/self/stack/uuid",
+		"/self/service",
+	})
+}
+
+func (s *ProfileTestSuite) TestFlagsOverrideProfile(c *check.C) {
+	s.service["nfs_server"] = "10.0.0.5"
+	s.service["mount_dir"] = "/exports"
+
+	l, args, err := s.resolve(c, "--socket", "/run/convoy.sock", "--storagepool-driver", "shared",
+		"run", "--profile", "nfs", "--convoy-root", "/data/convoy", "--convoy-create-on-docker-mount=false",
+		"--plugin-spec", "/etc/docker/plugins/shared.json")
+	c.Assert(err, check.IsNil)
+	c.Assert(l.driver, check.Equals, "shared")
+	c.Assert(l.pluginSpec, check.Equals, "/etc/docker/plugins/shared.json")
+	c.Assert(l.hostSocket, check.Equals, "/run/convoy.sock")
+	c.Assert(l.nfs.mountPoint, check.Equals, "/data/convoy/mnt")
+	c.Assert(args, check.DeepEquals, []string{
+		"--socket=/run/convoy.sock",
+		"daemon",
+		"--root=/data/convoy",
+		"--drivers=vfs",
+		"--driver-opts=vfs.path=/data/convoy/mnt",
+		"--ignore-docker-delete=true",
+		"--create-on-docker-mount=false",
+	})
+}
+
+func (s *ProfileTestSuite) TestEnvironmentBeforeMetadata(c *check.C) {
+	s.env = map[string]string{
+		"STACK_NAME": "storage",
+		"STACK_UUID": "abcd",
+		"MNT_HOST":   "nfs.example.com",
+		"MNT_DIR":    "/vol",
+	}
+	s.service["mount_opts"] = "soft"
+
+	l, _, err := s.resolve(c, "run", "--profile", "nfs")
+	c.Assert(err, check.IsNil)
+	c.Assert(l.convoyRoot, check.Equals, "/var/lib/rancher/convoy/storage-abcd")
+	c.Assert(l.nfs.source(), check.Equals, "nfs.example.com:/vol")
+	c.Assert(l.nfs.options, check.Equals, "soft")
+	c.Assert(s.requests, check.DeepEquals, []string{"/version", "/self/service"})
+
+	s.requests = nil
+	s.env["MNT_OPTS"] = "hard"
+	l, _, err = s.resolve(c, "run", "--profile", "nfs")
+	c.Assert(err, check.IsNil)
+	c.Assert(l.nfs.options, check.Equals, "hard")
+	c.Assert(s.requests, check.HasLen, 0)
+}
+
+func (s *ProfileTestSuite) TestMissingSetting(c *check.C) {
+	s.service["nfs_server"] = "10.0.0.5"
+	_, _, err := s.resolve(c, "run", "--profile", "nfs")
+	c.Assert(err, check.ErrorMatches, "Required setting is missing. Set MNT_DIR or the service metadata key mount_dir")
+
+	_, _, err = s.resolve(c, "run", "--profile", "glusterfs")
+	c.Assert(err, check.ErrorMatches, "Required setting is missing. Set the service metadata key servers")
+}
+
+func (s *ProfileTestSuite) TestEFS(c *check.C) {
+	s.service["efs_id"] = "fs-12345678"
+	s.service["aws_region"] = "us-west-2"
+	s.service["mount_dir"] = "/"
+
+	l, _, err := s.resolve(c, "run", "--profile", "efs")
+	c.Assert(err, check.IsNil)
+	c.Assert(l.nfs.source(), check.Equals, "us-west-2a.fs-12345678.efs.us-west-2.amazonaws.com:/")
+	c.Assert(l.nfs.options, check.Equals, "nfsvers=4.1")
+	c.Assert(l.convoyArgs["drivers"], check.DeepEquals, []string{"vfs"})
+}
+
+func (s *ProfileTestSuite) TestGlusterfs(c *check.C) {
+	s.service["servers"] = []interface{}{"gluster1", "gluster2"}
+	s.service["volume_pool"] = "pool"
+
+	l, args, err := s.resolve(c, "run", "--profile", "glusterfs")
+	c.Assert(err, check.IsNil)
+	c.Assert(l.sharedMount, check.Equals, true)
+	c.Assert(args[2:], check.DeepEquals, []string{
+		"--root=/var/lib/rancher/convoy/nfs-1234",
+		"--drivers=glusterfs",
+		"--driver-opts=glusterfs.defaultvolumepool=pool",
+		"--driver-opts=glusterfs.servers=gluster1,gluster2",
+	})
+}
+
+func (s *ProfileTestSuite) TestLonghorn(c *check.C) {
+	_, _, err := s.resolve(c, "run", "--profile", "longhorn")
+	c.Assert(err, check.ErrorMatches, "The longhorn driver needs the url, access-key and secret-key of cattle")
+
+	cattle := []string{"--url", "http://cattle/v1", "--access-key", "key", "--secret-key", "secret"}
+	l, args, err := s.resolve(c, append(cattle, "run", "--profile", "longhorn", "--components", "agent")...)
+	c.Assert(err, check.IsNil)
+	c.Assert(l.sharedMount, check.Equals, false)
+	c.Assert(args[2:], check.DeepEquals, []string{
+		"--root=/var/lib/rancher/convoy/longhorn",
+		"--drivers=longhorn",
+		"--driver-opts=lh.rancherurl=http://cattle/v1",
+		"--driver-opts=lh.rancheraccesskey=key",
+		"--driver-opts=lh.ranchersecretkey=secret",
+	})
+
+	l, args, err = s.resolve(c, append(cattle, "run", "--profile", "longhorn", "--components", "driver")...)
+	c.Assert(err, check.IsNil)
+	c.Assert(l.sharedMount, check.Equals, true)
+	c.Assert(args[len(args)-1], check.Equals, "--ignore-docker-delete=true")
+}
+
+func (s *ProfileTestSuite) TestMountNFS(c *check.C) {
+	l := &launch{convoyRoot: c.MkDir(), convoyArgs: map[string][]string{}}
+	vfsOnNFS(l, &nfsMount{host: "10.0.0.5", dir: "/exports", options: "vers=4"})
+	c.Assert(mountNFS(l), check.ErrorMatches, "Cannot mount NFS without a target pid")
+
+	l.targetPid = 42
+	c.Assert(mountNFS(l), check.IsNil)
+	_, err := os.Stat(l.nfs.mountPoint)
+	c.Assert(err, check.IsNil)
+	c.Assert(s.commands, check.DeepEquals, []string{
+		"nsenter -t 42 -n rpcbind",
+		"nsenter -t 42 -n rpc.statd",
+		"mountpoint -q " + l.nfs.mountPoint,
+		"nsenter -t 42 -n mount -t nfs -o vers=4 10.0.0.5:/exports " + l.nfs.mountPoint,
+	})
+
+	// An existing mount is left alone.
+	s.commands = nil
+	s.mounted = l.nfs.mountPoint
+	c.Assert(mountNFS(l), check.IsNil)
+	c.Assert(s.commands, check.HasLen, 3)
+}
+
+func (s *ProfileTestSuite) TestRemoveStaleSocket(c *check.C) {
+	socket := filepath.Join(c.MkDir(), "convoy.sock")
+	c.Assert(removeStaleSocket(socket), check.IsNil)
+
+	listener, err := net.Listen("unix", socket)
+	c.Assert(err, check.IsNil)
+	c.Assert(removeStaleSocket(socket), check.ErrorMatches, "Convoy socket is in use by another process.*")
+
+	// Closing a unix listener removes its socket, so leave a file behind the
+	// way a killed convoy does.
+	listener.Close()
+	f, err := os.Create(socket)
+	c.Assert(err, check.IsNil)
+	f.Close()
+	c.Assert(removeStaleSocket(socket), check.IsNil)
+	_, err = os.Stat(socket)
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+}
@@ -0,0 +1,422 @@
+package volume
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/rancher/convoy/api"
+
+	"github.com/rancher/convoy-agent/cattle"
+)
+
+const quarantineFileSuffix = ".json"
+
+// QuarantinePrefix starts the convoy names of quarantined volumes. While a
+// volume is quarantined its original name is free, so a new volume created
+// with that name starts empty instead of picking up the quarantined data.
+const QuarantinePrefix = "quarantined--"
+
+// driverVolumeIDKeys are the keys of a volume's driver info that convoy
+// drivers report the driver's own ID for the volume under. It is what a
+// volume is adopted by when it is renamed.
+var driverVolumeIDKeys = []string{"VolumeDriverID", "DriverVolumeID", "Path"}
+
+// QuarantinedVolume records a volume that Cattle has removed but whose data
+// is being retained until PurgeAfter. The volume is held in convoy under
+// QuarantineName, and identified by the driver's ID for it.
+type QuarantinedVolume struct {
+	Name           string
+	QuarantineName string
+	Driver         string
+	DriverVolumeID string
+	QuarantinedAt  time.Time
+	PurgeAfter     time.Time
+}
+
+// Quarantine is a soft-delete area for volumes. Quarantining a volume renames
+// it in convoy into the reserved QuarantinePrefix namespace, and tracks it
+// with a record file in dir until it is either restored or purged.
+type Quarantine struct {
+	dir       string
+	retention time.Duration
+}
+
+func NewQuarantine(dir string, retention time.Duration) (*Quarantine, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("quarantine dir is empty")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Quarantine{
+		dir:       dir,
+		retention: retention,
+	}, nil
+}
+
+// IsQuarantineName reports whether name is the convoy name of a quarantined
+// volume.
+func IsQuarantineName(name string) bool {
+	return strings.HasPrefix(name, QuarantinePrefix)
+}
+
+// Add quarantines the named convoy volume by renaming it into the quarantine
+// namespace. A volume that cannot be renamed is left as it was.
+func (q *Quarantine) Add(ctx context.Context, convoy *ConvoyClient, name string) (*QuarantinedVolume, error) {
+	vol, err := convoy.GetVolumeContext(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if vol == nil {
+		return nil, fmt.Errorf("Volume %s does not exist in convoy", name)
+	}
+
+	now := time.Now().UTC()
+	qv := &QuarantinedVolume{
+		Name:           name,
+		QuarantineName: fmt.Sprintf("%s%s--%d", QuarantinePrefix, name, now.UnixNano()),
+		Driver:         vol.Driver,
+		DriverVolumeID: driverVolumeID(vol),
+		QuarantinedAt:  now,
+		PurgeAfter:     now.Add(q.retention),
+	}
+	if _, err := renameVolume(ctx, convoy, vol, qv.QuarantineName); err != nil {
+		return nil, err
+	}
+	if err := q.write(qv); err != nil {
+		if _, restoreErr := q.rename(convoy, qv, qv.QuarantineName, name); restoreErr != nil {
+			log.Errorf("Cannot move volume %s back out of quarantine [%v]", name, restoreErr)
+		}
+		return nil, err
+	}
+	log.Infof("Quarantined volume %s as %s until %s", name, qv.QuarantineName, qv.PurgeAfter.Format(time.RFC3339))
+	return qv, nil
+}
+
+// Restore moves a quarantined volume back to its original name and drops
+// its record. The original name must not have been reused meanwhile.
+func (q *Quarantine) Restore(convoy *ConvoyClient, qv *QuarantinedVolume) (*api.VolumeResponse, error) {
+	existing, err := convoy.GetVolume(qv.Name)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("Cannot restore volume %s, a volume with that name exists", qv.Name)
+	}
+	vol, err := q.rename(convoy, qv, qv.QuarantineName, qv.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err := q.Remove(qv.QuarantineName); err != nil {
+		return nil, err
+	}
+	return vol, nil
+}
+
+// rename moves the quarantined volume from one name to another, checking
+// that the volume at from is still the one that was quarantined.
+func (q *Quarantine) rename(convoy *ConvoyClient, qv *QuarantinedVolume, from, to string) (*api.VolumeResponse, error) {
+	vol, err := q.quarantinedVolume(convoy, qv, from)
+	if err != nil {
+		return nil, err
+	}
+	return renameVolume(context.Background(), convoy, vol, to)
+}
+
+// quarantinedVolume returns the volume named name in convoy, if it is the
+// one the record was made for.
+func (q *Quarantine) quarantinedVolume(convoy *ConvoyClient, qv *QuarantinedVolume, name string) (*api.VolumeResponse, error) {
+	vol, err := convoy.GetVolume(name)
+	if err != nil {
+		return nil, err
+	}
+	if vol == nil {
+		return nil, fmt.Errorf("Quarantined volume %s no longer exists in convoy", name)
+	}
+	if id := driverVolumeID(vol); id != qv.DriverVolumeID {
+		return nil, fmt.Errorf("Volume %s is not the quarantined volume. Driver volume ID: %s. Expected: %s", name, id, qv.DriverVolumeID)
+	}
+	return vol, nil
+}
+
+// renameVolume renames a convoy volume by removing it from convoy and adopting
+// its data under the new name. If the driver does not adopt the same data,
+// the volume is put back under its old name.
+func renameVolume(ctx context.Context, convoy *ConvoyClient, vol *api.VolumeResponse, name string) (*api.VolumeResponse, error) {
+	id := driverVolumeID(vol)
+	if id == "" {
+		return nil, fmt.Errorf("Cannot rename volume %s. Driver %s does not report an ID for it", vol.Name, vol.Driver)
+	}
+	if err := convoy.ForgetVolumeContext(ctx, vol.Name); err != nil {
+		return nil, fmt.Errorf("Cannot rename volume %s. Error: %v", vol.Name, err)
+	}
+
+	renamed, err := convoy.AdoptVolumeContext(ctx, name, vol.Driver, id)
+	if err == nil && driverVolumeID(renamed) != id {
+		// The driver made a new, empty volume instead.
+		if delErr := convoy.DeleteVolume(name); delErr != nil {
+			log.Errorf("Cannot delete volume %s created while renaming %s [%v]", name, vol.Name, delErr)
+		}
+		err = fmt.Errorf("driver %s created a new volume instead of adopting %s", vol.Driver, id)
+	}
+	if err != nil {
+		if _, restoreErr := convoy.AdoptVolumeContext(context.Background(), vol.Name, vol.Driver, id); restoreErr != nil {
+			return nil, fmt.Errorf("Cannot rename volume %s to %s, and cannot add it back. Driver volume ID: %s. Error: %v. Restore error: %v", vol.Name, name, id, err, restoreErr)
+		}
+		return nil, fmt.Errorf("Cannot rename volume %s to %s. Error: %v", vol.Name, name, err)
+	}
+	return renamed, nil
+}
+
+func driverVolumeID(vol *api.VolumeResponse) string {
+	for _, key := range driverVolumeIDKeys {
+		if id := vol.DriverInfo[key]; id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+func (q *Quarantine) write(qv *QuarantinedVolume) error {
+	content, err := json.Marshal(qv)
+	if err != nil {
+		return err
+	}
+	path := q.path(qv.QuarantineName)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Get returns the record of the volume quarantined as quarantineName, or nil
+// if there is none.
+func (q *Quarantine) Get(quarantineName string) (*QuarantinedVolume, error) {
+	content, err := ioutil.ReadFile(q.path(quarantineName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	qv := &QuarantinedVolume{}
+	if err := json.Unmarshal(content, qv); err != nil {
+		return nil, fmt.Errorf("Cannot parse quarantine record for %s. Error: %v", quarantineName, err)
+	}
+	return qv, nil
+}
+
+// Find returns the record of the volume quarantined as name, or else the
+// latest record of a volume originally called name. It returns nil if there
+// is neither.
+func (q *Quarantine) Find(name string) (*QuarantinedVolume, error) {
+	if IsQuarantineName(name) {
+		return q.Get(name)
+	}
+	vols, err := q.List()
+	if err != nil {
+		return nil, err
+	}
+	var found *QuarantinedVolume
+	for i := range vols {
+		if vols[i].Name == name && (found == nil || vols[i].QuarantinedAt.After(found.QuarantinedAt)) {
+			found = &vols[i]
+		}
+	}
+	return found, nil
+}
+
+// Remove drops the record of the volume quarantined as quarantineName.
+func (q *Quarantine) Remove(quarantineName string) error {
+	err := os.Remove(q.path(quarantineName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// List returns all quarantined volumes ordered by the time they are due to be
+// purged.
+func (q *Quarantine) List() ([]QuarantinedVolume, error) {
+	files, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	vols := []QuarantinedVolume{}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), quarantineFileSuffix) {
+			continue
+		}
+		name, err := url.QueryUnescape(strings.TrimSuffix(f.Name(), quarantineFileSuffix))
+		if err != nil || !IsQuarantineName(name) {
+			log.Warnf("Ignoring unrecognized quarantine file %s", f.Name())
+			continue
+		}
+		qv, err := q.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		if qv != nil {
+			vols = append(vols, *qv)
+		}
+	}
+	sort.Sort(byPurgeAfter(vols))
+	return vols, nil
+}
+
+// Purge destroys every quarantined volume whose retention period has expired.
+// Only the quarantined volume itself is deleted: a volume under its
+// quarantine name that the driver knows by another ID is left alone.
+func (q *Quarantine) Purge(convoy *ConvoyClient) error {
+	vols, err := q.List()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i := range vols {
+		qv := &vols[i]
+		if now.Before(qv.PurgeAfter) {
+			continue
+		}
+		log.Infof("Purging quarantined volume %s (%s)", qv.QuarantineName, qv.Name)
+		vol, err := convoy.GetVolume(qv.QuarantineName)
+		if err != nil {
+			log.Errorf("Error purging quarantined volume name=[%s] err=[%v]", qv.QuarantineName, err)
+			continue
+		}
+		if vol != nil {
+			if id := driverVolumeID(vol); id != qv.DriverVolumeID {
+				log.Errorf("Not purging volume name=[%s], its driver volume ID %s is not the quarantined %s", qv.QuarantineName, id, qv.DriverVolumeID)
+				continue
+			}
+			if err := convoy.DeleteVolume(qv.QuarantineName); err != nil {
+				log.Errorf("Error purging quarantined volume name=[%s] err=[%v]", qv.QuarantineName, err)
+				continue
+			}
+		}
+		if err := q.Remove(qv.QuarantineName); err != nil {
+			log.Errorf("Error removing quarantine record for volume name=[%s] err=[%v]", qv.QuarantineName, err)
+		}
+	}
+	return nil
+}
+
+func (q *Quarantine) Run(convoy *ConvoyClient, purgeInterval time.Duration, controlChan chan bool) error {
+	for {
+		select {
+		case <-controlChan:
+			controlChan <- true
+			return nil
+		case <-time.After(purgeInterval):
+		}
+
+		if err := q.Purge(convoy); err != nil {
+			log.Errorf("Error purging quarantined volumes [%v]", err)
+		}
+	}
+}
+
+// QuarantineFromContext builds the quarantine configured by the global
+// quarantine flags. It returns nil if quarantining is not enabled.
+func QuarantineFromContext(c *cli.Context) (*Quarantine, error) {
+	dir := c.GlobalString("quarantine-dir")
+	if dir == "" {
+		return nil, nil
+	}
+	return NewQuarantine(dir, c.GlobalDuration("quarantine-retention"))
+}
+
+func listQuarantine(c *cli.Context) {
+	quarantine, err := QuarantineFromContext(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if quarantine == nil {
+		log.Fatal("required field quarantine-dir has not been set")
+	}
+
+	vols, err := quarantine.List()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tQUARANTINED AS\tQUARANTINED\tPURGE AFTER")
+	for _, qv := range vols {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", qv.Name, qv.QuarantineName, qv.QuarantinedAt.Format(time.RFC3339), qv.PurgeAfter.Format(time.RFC3339))
+	}
+	w.Flush()
+}
+
+func restoreQuarantine(c *cli.Context) {
+	name := c.Args().First()
+	if name == "" {
+		log.Fatal("volume name is required")
+	}
+	driver := c.GlobalString("storagepool-driver")
+	if driver == "" {
+		log.Fatal("required field storagepool-driver has not been set")
+	}
+
+	quarantine, err := QuarantineFromContext(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if quarantine == nil {
+		log.Fatal("required field quarantine-dir has not been set")
+	}
+	qv, err := quarantine.Find(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if qv == nil {
+		log.Fatalf("volume %s is not quarantined", name)
+	}
+
+	namer, err := VolumeNamerFromContext(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cattleClient, err := cattle.NewCattleClient(c.GlobalString("url"), c.GlobalString("access-key"), c.GlobalString("secret-key"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	cattleClient.SetVolumeNamer(namer)
+
+	convoy, err := NewConvoyClient(c.GlobalString("socket"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	vol, err := quarantine.Restore(convoy, qv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := cattleClient.CreateVolume(driver, *vol); err != nil {
+		log.Fatalf("Error registering restored volume %s with cattle: %v", qv.Name, err)
+	}
+	log.Infof("Restored volume %s from %s", qv.Name, qv.QuarantineName)
+}
+
+func (q *Quarantine) path(name string) string {
+	return filepath.Join(q.dir, url.QueryEscape(name)+quarantineFileSuffix)
+}
+
+type byPurgeAfter []QuarantinedVolume
+
+func (b byPurgeAfter) Len() int           { return len(b) }
+func (b byPurgeAfter) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byPurgeAfter) Less(i, j int) bool { return b[i].PurgeAfter.Before(b[j].PurgeAfter) }
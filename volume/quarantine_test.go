@@ -0,0 +1,199 @@
+package volume
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rancher/convoy/api"
+	"gopkg.in/check.v1"
+)
+
+type QuarantineTestSuite struct {
+	listener net.Listener
+	convoy   *ConvoyClient
+
+	mu sync.Mutex
+	// volumes maps convoy names to the driver's volume IDs, and destroyed
+	// lists the driver volumes whose data was deleted.
+	volumes   map[string]string
+	destroyed []string
+	// mounted lists the volumes reported as mounted.
+	mounted map[string]bool
+	// ignoreID makes the fake driver keep volumes at a path named after the
+	// volume, like vfs, instead of adopting the driver volume ID it is given.
+	ignoreID bool
+}
+
+var _ = check.Suite(&QuarantineTestSuite{})
+
+// SetUpTest serves a fake convoy whose driver adopts existing volumes by ID
+// and reports the ID in the volume's driver info.
+func (s *QuarantineTestSuite) SetUpTest(c *check.C) {
+	s.volumes = map[string]string{"data": "/vfs/data"}
+	s.destroyed = nil
+	s.mounted = map[string]bool{}
+	s.ignoreID = false
+
+	socket := filepath.Join(c.MkDir(), "convoy.sock")
+	l, err := net.Listen("unix", socket)
+	c.Assert(err, check.IsNil)
+	s.listener = l
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/volumes/", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch r.Method {
+		case "GET":
+			req := api.VolumeInspectRequest{}
+			c.Check(json.NewDecoder(r.Body).Decode(&req), check.IsNil)
+			id, ok := s.volumes[req.VolumeName]
+			if !ok {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(s.response(req.VolumeName, id))
+		case "DELETE":
+			req := api.VolumeDeleteRequest{}
+			c.Check(json.NewDecoder(r.Body).Decode(&req), check.IsNil)
+			id, ok := s.volumes[req.VolumeName]
+			if !ok {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			delete(s.volumes, req.VolumeName)
+			if !req.ReferenceOnly {
+				s.destroyed = append(s.destroyed, id)
+			}
+		}
+	})
+	mux.HandleFunc("/v1/volumes/create", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		req := api.VolumeCreateRequest{}
+		c.Check(json.NewDecoder(r.Body).Decode(&req), check.IsNil)
+		id := req.DriverVolumeID
+		if s.ignoreID {
+			id = "/vfs/" + req.Name
+		} else if id == "" {
+			id = "/vfs/new-" + req.Name
+		}
+		s.volumes[req.Name] = id
+		json.NewEncoder(w).Encode(s.response(req.Name, id))
+	})
+	go http.Serve(l, mux)
+
+	s.convoy, err = NewConvoyClient(socket)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *QuarantineTestSuite) TearDownTest(c *check.C) {
+	s.listener.Close()
+}
+
+func (s *QuarantineTestSuite) response(name, id string) api.VolumeResponse {
+	vol := api.VolumeResponse{Name: name, Driver: "vfs", DriverInfo: map[string]string{"Path": id}}
+	if s.mounted[name] {
+		vol.MountPoint = "/mnt/" + name
+	}
+	return vol
+}
+
+func (s *QuarantineTestSuite) TestQuarantineAndPurge(c *check.C) {
+	q, err := NewQuarantine(c.MkDir(), time.Hour)
+	c.Assert(err, check.IsNil)
+
+	qv, err := q.Add(context.Background(), s.convoy, "data")
+	c.Assert(err, check.IsNil)
+	c.Assert(qv.Name, check.Equals, "data")
+	c.Assert(IsQuarantineName(qv.QuarantineName), check.Equals, true)
+	c.Assert(qv.DriverVolumeID, check.Equals, "/vfs/data")
+	c.Assert(qv.PurgeAfter.Sub(qv.QuarantinedAt), check.Equals, time.Hour)
+	c.Assert(s.volumes, check.DeepEquals, map[string]string{qv.QuarantineName: "/vfs/data"})
+
+	// A new volume with the original name starts empty.
+	vol, err := s.convoy.CreateVolumeRequest(api.VolumeCreateRequest{Name: "data"})
+	c.Assert(err, check.IsNil)
+	c.Assert(vol.DriverInfo["Path"], check.Equals, "/vfs/new-data")
+
+	found, err := q.Find("data")
+	c.Assert(err, check.IsNil)
+	c.Assert(found.QuarantineName, check.Equals, qv.QuarantineName)
+
+	// Purging before the retention period is over keeps the volume.
+	c.Assert(q.Purge(s.convoy), check.IsNil)
+	c.Assert(s.destroyed, check.HasLen, 0)
+
+	expired, err := NewQuarantine(q.dir, 0)
+	c.Assert(err, check.IsNil)
+	qv.PurgeAfter = time.Now().Add(-time.Second)
+	c.Assert(expired.write(qv), check.IsNil)
+	c.Assert(expired.Purge(s.convoy), check.IsNil)
+	c.Assert(s.destroyed, check.DeepEquals, []string{"/vfs/data"})
+	c.Assert(s.volumes, check.DeepEquals, map[string]string{"data": "/vfs/new-data"})
+
+	vols, err := q.List()
+	c.Assert(err, check.IsNil)
+	c.Assert(vols, check.HasLen, 0)
+}
+
+func (s *QuarantineTestSuite) TestPurgeChecksIdentity(c *check.C) {
+	q, err := NewQuarantine(c.MkDir(), 0)
+	c.Assert(err, check.IsNil)
+	qv, err := q.Add(context.Background(), s.convoy, "data")
+	c.Assert(err, check.IsNil)
+
+	// Something else now holds the quarantine name.
+	s.volumes[qv.QuarantineName] = "/vfs/other"
+	c.Assert(q.Purge(s.convoy), check.IsNil)
+	c.Assert(s.destroyed, check.HasLen, 0)
+	found, err := q.Get(qv.QuarantineName)
+	c.Assert(err, check.IsNil)
+	c.Assert(found, check.NotNil)
+}
+
+func (s *QuarantineTestSuite) TestRestore(c *check.C) {
+	q, err := NewQuarantine(c.MkDir(), time.Hour)
+	c.Assert(err, check.IsNil)
+	qv, err := q.Add(context.Background(), s.convoy, "data")
+	c.Assert(err, check.IsNil)
+
+	s.volumes["data"] = "/vfs/data-2"
+	_, err = q.Restore(s.convoy, qv)
+	c.Assert(err, check.ErrorMatches, "Cannot restore volume data, a volume with that name exists")
+
+	delete(s.volumes, "data")
+	vol, err := q.Restore(s.convoy, qv)
+	c.Assert(err, check.IsNil)
+	c.Assert(vol.Name, check.Equals, "data")
+	c.Assert(s.volumes, check.DeepEquals, map[string]string{"data": "/vfs/data"})
+	found, err := q.Find("data")
+	c.Assert(err, check.IsNil)
+	c.Assert(found, check.IsNil)
+}
+
+func (s *QuarantineTestSuite) TestDriverWithoutAdoption(c *check.C) {
+	q, err := NewQuarantine(c.MkDir(), time.Hour)
+	c.Assert(err, check.IsNil)
+
+	s.ignoreID = true
+	_, err = q.Add(context.Background(), s.convoy, "data")
+	c.Assert(err, check.ErrorMatches, "Cannot rename volume data to quarantined--data--.*created a new volume.*")
+	c.Assert(s.volumes, check.DeepEquals, map[string]string{"data": "/vfs/data"})
+	c.Assert(s.destroyed, check.HasLen, 1)
+	c.Assert(s.destroyed[0], check.Matches, "/vfs/quarantined--data--.*")
+
+	vols, err := q.List()
+	c.Assert(err, check.IsNil)
+	c.Assert(vols, check.HasLen, 0)
+}
+
+func (s *QuarantineTestSuite) TestNewQuarantineRequiresDir(c *check.C) {
+	_, err := NewQuarantine("", time.Hour)
+	c.Assert(err, check.NotNil)
+}
@@ -0,0 +1,15 @@
+package volume
+
+// sharedDrivers are the convoy drivers whose volumes are visible from every
+// host using the same backend. vfs is shared only when its path is on a
+// network filesystem, which operators say with the shared-storage flag.
+var sharedDrivers = map[string]bool{
+	"glusterfs": true,
+	"longhorn":  true,
+}
+
+// IsSharedDriver reports whether every host sees the same volumes through the
+// convoy driver, as opposed to each host having its own.
+func IsSharedDriver(driver string) bool {
+	return sharedDrivers[driver]
+}
@@ -0,0 +1,33 @@
+package volume
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ParseSize parses a size in bytes, optionally with a K, M, G or T suffix for
+// the binary multiples, written as in 10G, 10GB, 10Gi or 10GiB, in any case.
+func ParseSize(value string) (int64, error) {
+	s := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(value)), "b")
+	binary := strings.HasSuffix(s, "i")
+	s = strings.TrimSuffix(s, "i")
+	multiplier := int64(1)
+	if n := len(s); n > 0 {
+		if i := strings.IndexByte("kmgt", s[n-1]); i >= 0 {
+			multiplier = int64(1) << (10 * uint(i+1))
+			s = s[:n-1]
+		} else if binary {
+			return 0, fmt.Errorf("Invalid size %q", value)
+		}
+	}
+	size, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("Invalid size %q", value)
+	}
+	if size > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("Invalid size %q, larger than %d bytes", value, int64(math.MaxInt64))
+	}
+	return size * multiplier, nil
+}
@@ -0,0 +1,36 @@
+package volume
+
+import (
+	"gopkg.in/check.v1"
+)
+
+type SizeTestSuite struct {
+}
+
+var _ = check.Suite(&SizeTestSuite{})
+
+func (s *SizeTestSuite) TestParseSize(c *check.C) {
+	for in, expected := range map[string]int64{
+		"512":      512,
+		"512b":     512,
+		"10k":      10 << 10,
+		"10K":      10 << 10,
+		"10M":      10 << 20,
+		"3MiB":     3 << 20,
+		"2g":       2 << 30,
+		"2GB":      2 << 30,
+		"10Gi":     10 << 30,
+		"10GiB":    10 << 30,
+		" 1t ":     1 << 40,
+		"8388607t": 8388607 << 40,
+	} {
+		size, err := ParseSize(in)
+		c.Assert(err, check.IsNil, check.Commentf("size %q", in))
+		c.Assert(size, check.Equals, expected, check.Commentf("size %q", in))
+	}
+
+	for _, in := range []string{"", "g", "gib", "-1g", "ten", "1.5g", "10i", "10ib", "8388608t", "9999999t", "99999999999999999999"} {
+		_, err := ParseSize(in)
+		c.Assert(err, check.ErrorMatches, "Invalid size .*", check.Commentf("size %q", in))
+	}
+}
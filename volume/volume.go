@@ -5,23 +5,37 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
 
 	convoyflags "github.com/rancher/convoy/client/flags"
+	"github.com/rancher/go-rancher-metadata/metadata"
 
+	"github.com/rancher/convoy-agent/admin"
 	"github.com/rancher/convoy-agent/cattle"
+	"github.com/rancher/convoy-agent/config"
+	"github.com/rancher/convoy-agent/health"
+	"github.com/rancher/convoy-agent/heartbeat"
+	"github.com/rancher/convoy-agent/identity"
+	"github.com/rancher/convoy-agent/leader"
 )
 
+const defaultMetadataUrl = "http://rancher-metadata/2015-12-19"
+
+// cattleCheckTTL is how often the health endpoints may call Cattle's API.
+const cattleCheckTTL = 10 * time.Second
+
 const convoyFlagNamePrefix string = "convoy-"
 const convoyFlagUsagePrefix string = "Passed to convoy. "
 const flagFmt string = "--%s=%s"
 
 var convoyFlagNames = []string{}
 var convoyFlags = map[string]string{}
-var rootUuidFileName = "UUID"
 var Commands = []cli.Command{
 	{
 		Name:      "volume",
@@ -29,6 +43,25 @@ var Commands = []cli.Command{
 		Action:    volumeAgent,
 		ShortName: "v",
 	},
+	runProfileCommand,
+	{
+		Name:  "quarantine",
+		Usage: "Inspect and restore quarantined volumes",
+		Subcommands: []cli.Command{
+			{
+				Name:   "list",
+				Usage:  "List quarantined volumes and when they will be purged",
+				Action: listQuarantine,
+			},
+			{
+				Name:   "restore",
+				Usage:  "Move a quarantined volume back to its name: restore VOLUME_NAME. Takes the original name, which restores the latest volume quarantined from it, or the quarantined name",
+				Action: restoreQuarantine,
+			},
+		},
+	},
+	migrateNamesCommand,
+	volumeCtlCommand,
 }
 
 func init() {
@@ -38,6 +71,32 @@ func init() {
 			Usage: "Which components to run: driver or agent",
 			Value: "driver,agent",
 		},
+		cli.IntFlag{
+			Name:  "poll-interval",
+			Value: 1000,
+			Usage: "how often to poll convoy for volume changes, in milliseconds",
+		},
+		cli.StringFlag{
+			Name:  "reporter-lock-dir",
+			Usage: "for drivers where every host sees the same volumes, elect one agent to report volume events using a lease file in this directory on the shared storage. Host-local drivers report from every host",
+		},
+		cli.BoolFlag{
+			Name:  "shared-storage",
+			Usage: "the convoy driver keeps its volumes on storage every host sees, such as vfs on a network filesystem, so one elected agent reports volume events. glusterfs and longhorn are always shared",
+		},
+		cli.DurationFlag{
+			Name:  "reporter-lease",
+			Value: 30 * time.Second,
+			Usage: "how long the volume event reporter may go without renewing its lease before another agent takes over",
+		},
+		cli.StringFlag{
+			Name:  "plugin-spec",
+			Usage: "register convoy with Docker as a volume plugin by writing this spec file, such as /etc/docker/plugins/convoy.spec, or .json for Docker's JSON format. It is removed when the agent shuts down",
+		},
+		cli.StringFlag{
+			Name:  "plugin-socket",
+			Usage: "path of the convoy socket on the host, for the plugin spec, when the agent sees the host under a prefix. Defaults to --socket",
+		},
 	}
 
 	for _, f := range convoyflags.DaemonFlags {
@@ -65,34 +124,156 @@ func init() {
 			logrus.Fatalf("Unknown type. Can't use convoy flag: %#v", f)
 		}
 	}
-	Commands[0].Flags = flags
+	command("volume").Flags = flags
+	command(runProfileCommand.Name).Flags = append(append([]cli.Flag{}, profileFlags...), flags...)
+}
+
+// command returns the command in Commands with the given name.
+func command(name string) *cli.Command {
+	for i := range Commands {
+		if Commands[i].Name == name {
+			return &Commands[i]
+		}
+	}
+	panic("no volume command named " + name)
+}
+
+// reloadPollInterval applies poll-interval to volAgent when it changes in the
+// config file. The option is read from the section of the command the agent
+// was started with, volume or run.
+func reloadPollInterval(c *cli.Context, volAgent *VolumeAgent) {
+	config.OnReload(c.Command.Name, []string{"poll-interval"}, func(v config.Values) {
+		volAgent.SetPollInterval(v.Int("poll-interval"))
+	})
+}
+
+// agentSettings are what the volume agent runs with. The volume command takes
+// them from its flags, the run command from a profile.
+type agentSettings struct {
+	socket     string
+	driver     string
+	components string
+	// pluginSpec is the Docker plugin spec to register the driver with, and
+	// pluginSocket the convoy socket as the host sees it.
+	pluginSpec   string
+	pluginSocket string
+	// sharedStorage is set when every host sees the driver's volumes,
+	// whatever the driver.
+	sharedStorage bool
+	// convoyDefaults are the values of the convoy flags not given on the
+	// command line, keyed by convoy flag name.
+	convoyDefaults map[string][]string
 }
 
 func volumeAgent(c *cli.Context) {
-	socket := c.GlobalString("socket")
-	components := c.String("components")
-	cattleUrl := c.GlobalString("url")
-	cattleAccessKey := c.GlobalString("access-key")
-	cattleSecretKey := c.GlobalString("secret-key")
 	if c.GlobalBool("debug") {
 		logrus.SetLevel(logrus.DebugLevel)
 	}
+	runVolumeAgent(c, agentSettings{
+		socket:        c.GlobalString("socket"),
+		driver:        c.GlobalString("storagepool-driver"),
+		components:    c.String("components"),
+		pluginSpec:    c.String("plugin-spec"),
+		pluginSocket:  c.String("plugin-socket"),
+		sharedStorage: c.Bool("shared-storage"),
+	})
+}
+
+func runVolumeAgent(c *cli.Context, settings agentSettings) {
+	socket := settings.socket
+	components := settings.components
+	cattleUrl := c.GlobalString("url")
+	cattleAccessKey := c.GlobalString("access-key")
+	cattleSecretKey := c.GlobalString("secret-key")
 
-	driver := c.GlobalString("storagepool-driver")
+	driver := settings.driver
 	if driver == "" {
 		logrus.Fatal("required field storagepool-driver has not been set")
 	}
 
+	namer, err := VolumeNamerFromContext(c)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	// Only the agent component reports to Cattle, and it may share the root
+	// dir with a driver-only instance on the same host.
+	var reporter string
+	if strings.Contains(components, "agent") {
+		id, err := identity.Load(c.GlobalString("storagepool-rootdir"))
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		defer id.Release()
+		logrus.AddHook(id.LogHook())
+		logrus.Infof("Starting volume agent %s", id.UUID)
+		reporter = id.UUID
+	}
+
+	health.Serve(c.GlobalString("health-bind"), c.GlobalInt("health-port"))
+	config.Watch()
 	resultChan := make(chan error)
 
+	adminServer := startAdminServer(c.GlobalString("admin-socket"), reporter)
+
+	var elector *leader.Elector
+	if lockDir := c.String("reporter-lock-dir"); lockDir != "" && reporter != "" {
+		drivers := convoyFlagValues(c, "drivers", settings.convoyDefaults)
+		if len(drivers) == 0 {
+			logrus.Warn("No convoy driver is configured, reporting volume events from every host")
+		} else if !settings.sharedStorage && !IsSharedDriver(drivers[0]) {
+			logrus.Warnf("Convoy driver %s is host-local, reporting volume events from every host. Set shared-storage if every host sees its volumes", drivers[0])
+		} else {
+			elector, err = leader.NewElector(lockDir, reporter, c.Duration("reporter-lease"))
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			health.Register("reporter", func() health.Status {
+				if elector.IsLeader() {
+					return health.OK("reporting volume events")
+				}
+				return health.OK(fmt.Sprintf("standby, reporter is %s", elector.Holder()))
+			})
+			go func(rc chan<- error) {
+				err := elector.Run(make(chan bool, 1))
+				logrus.Errorf("Volume event reporter election exited with error: %v", err)
+				rc <- err
+			}(resultChan)
+		}
+	}
+
+	health.Register("convoy", SocketCheck(socket))
+
+	if strings.Contains(components, "driver") && settings.pluginSpec != "" {
+		spec, err := newPluginSpec(settings.pluginSpec, socket, settings.pluginSocket)
+		if err == nil {
+			err = spec.Register()
+		}
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		defer func() {
+			if err := spec.Unregister(); err != nil {
+				logrus.Error(err)
+			}
+		}()
+	}
+
 	if strings.Contains(components, "driver") {
+		daemon := &childProcess{name: "convoy"}
+		health.Register("convoy-daemon", daemon.Status)
 		go func(rc chan<- error) {
-			cmdArgs := buildConvoyCmdArgs(c, socket)
+			cmdArgs := buildConvoyCmdArgs(c, socket, settings.convoyDefaults)
 			cmd := exec.Command("convoy", cmdArgs...)
 			logrus.Infof("Launching convoy with args: %s", cmdArgs)
 			cmd.Stdout = os.Stdout
 			cmd.Stderr = os.Stderr
-			err := cmd.Run()
+			err := cmd.Start()
+			if err == nil {
+				daemon.started(cmd.Process.Pid)
+				err = cmd.Wait()
+			}
+			daemon.exited(err)
 			logrus.Infof("convoy exited with error: %v", err)
 			rc <- err
 		}(resultChan)
@@ -104,39 +285,121 @@ func volumeAgent(c *cli.Context) {
 			cattleClient, err := cattle.NewCattleClient(cattleUrl, cattleAccessKey, cattleSecretKey)
 			if err != nil {
 				rc <- fmt.Errorf("Error getting cattle client: %v", err)
+				return
+			}
+			cattleClient.SetVolumeNamer(namer)
+			cattleClient.SetReporter(reporter)
+			health.Register("cattle", health.Cached(cattleClient.Check, cattleCheckTTL))
+			volAgent := NewVolumeAgent(socket, c.Int("poll-interval"), cattleClient, driver)
+			health.Register("volumes", volAgent.PollHealth)
+			if adminServer != nil {
+				adminServer.SetVolumeTracker(volAgent)
+				adminServer.AddSyncer("volumes", volAgent)
+			}
+			reloadPollInterval(c, volAgent)
+			if elector != nil {
+				volAgent.SetReporterElection(elector, 2*c.Duration("reporter-lease"))
 			}
-			volAgent := NewVolumeAgent(socket, 1000, cattleClient, driver)
 			err = volAgent.Run(controlChan)
 			logrus.Infof("volume-agent exited with error: %v", err)
 			rc <- err
 		}(resultChan)
 	}
 
-	<-resultChan
+	if c.GlobalString("healthcheck-type") == heartbeat.CheckType {
+		go func(rc chan<- error) {
+			uuid, err := hostUUID()
+			if err != nil {
+				rc <- fmt.Errorf("Error getting host uuid for heartbeats: %v", err)
+				return
+			}
+			interval := time.Duration(c.GlobalInt("healthcheck-interval")) * time.Millisecond
+			writer, err := heartbeat.NewWriter(c.GlobalString("healthcheck-basedir"), uuid, interval)
+			if err != nil {
+				rc <- err
+				return
+			}
+			logrus.Infof("Writing heartbeats for host %s", uuid)
+			err = writer.Run(make(chan bool, 1))
+			logrus.Infof("heartbeat writer exited with error: %v", err)
+			rc <- err
+		}(resultChan)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case <-resultChan:
+	case sig := <-signals:
+		logrus.Infof("Received %v, shutting down", sig)
+	}
 	logrus.Info("Exiting.")
 }
 
-func buildConvoyCmdArgs(c *cli.Context, socket string) []string {
+// startAdminServer serves the admin API on socket in the background, or
+// returns nil if socket is empty. The agent keeps running without the admin
+// API if it cannot be served.
+func startAdminServer(socket, agentID string) *admin.Server {
+	if socket == "" {
+		return nil
+	}
+	server := admin.NewServer(socket)
+	server.SetAgentID(agentID)
+	go func() {
+		err := server.Run(make(chan bool, 1))
+		logrus.Errorf("Admin API exited with error: %v", err)
+	}()
+	return server
+}
+
+// hostUUID asks rancher-metadata for the UUID of the host the agent runs on,
+// which Cattle knows the host by. The UUID in the agent's root dir identifies
+// the agent, not the host.
+func hostUUID() (string, error) {
+	container, err := metadata.NewClient(defaultMetadataUrl).GetSelfContainer()
+	if err != nil {
+		return "", err
+	}
+	return container.HostUUID, nil
+}
+
+// buildConvoyCmdArgs returns the arguments to start the convoy daemon with,
+// from the convoy flags given on the command line or in the config file.
+// Flags given neither way take their value from defaults, keyed by convoy
+// flag name.
+func buildConvoyCmdArgs(c *cli.Context, socket string, defaults map[string][]string) []string {
 	convoyCmd := []string{fmt.Sprintf(flagFmt, "socket", socket), "daemon"}
-	for flagName, flagType := range convoyFlags {
-		if !c.IsSet(flagName) {
+	for _, flagName := range convoyFlagNames {
+		name := flagName[len(convoyFlagNamePrefix):]
+		if !config.IsSet(c, flagName) {
+			for _, val := range defaults[name] {
+				convoyCmd = append(convoyCmd, fmt.Sprintf(flagFmt, name, val))
+			}
 			continue
 		}
 		f := c.Generic(flagName)
-		flagName = flagName[len(convoyFlagNamePrefix):]
-		logrus.Infof("Got: %s %v", flagName, f)
-		switch flagType {
+		logrus.Infof("Got: %s %v", name, f)
+		switch convoyFlags[flagName] {
 		case "string":
 			fallthrough
 		case "bool":
 			fl := f.(flag.Getter)
-			convoyCmd = append(convoyCmd, fmt.Sprintf(flagFmt, flagName, fl.String()))
+			convoyCmd = append(convoyCmd, fmt.Sprintf(flagFmt, name, fl.String()))
 		case "stringslice":
 			fl := f.(*cli.StringSlice)
 			for _, val := range fl.Value() {
-				convoyCmd = append(convoyCmd, fmt.Sprintf(flagFmt, flagName, val))
+				convoyCmd = append(convoyCmd, fmt.Sprintf(flagFmt, name, val))
 			}
 		}
 	}
 	return convoyCmd
 }
+
+// convoyFlagValues returns the values of a convoy flag, given by its convoy
+// name, the way buildConvoyCmdArgs passes them to convoy.
+func convoyFlagValues(c *cli.Context, name string, defaults map[string][]string) []string {
+	if config.IsSet(c, convoyFlagNamePrefix+name) {
+		return c.StringSlice(convoyFlagNamePrefix + name)
+	}
+	return defaults[name]
+}
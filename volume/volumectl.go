@@ -0,0 +1,396 @@
+package volume
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/rancher/convoy/api"
+
+	"github.com/rancher/convoy-agent/admin"
+)
+
+var selectorFlag = cli.StringFlag{
+	Name:  "selector, l",
+	Usage: "act on every volume whose name matches this glob, e.g. 'ci-*', instead of the named volumes",
+}
+
+var volumeCtlCommand = cli.Command{
+	Name:  "volume-ctl",
+	Usage: "Manage volumes through the convoy daemon listening on --socket",
+	Subcommands: []cli.Command{
+		{
+			Name:   "list",
+			Usage:  "List volumes: list [--selector GLOB]",
+			Flags:  []cli.Flag{admin.OutputFlag, selectorFlag},
+			Action: listVolumes,
+		},
+		{
+			Name:   "inspect",
+			Usage:  "Show volumes with their snapshots: inspect VOLUME_NAME... | --selector GLOB",
+			Flags:  []cli.Flag{admin.OutputFlag, selectorFlag},
+			Action: inspectVolumes,
+		},
+		{
+			Name:  "create",
+			Usage: "Create a volume: create VOLUME_NAME",
+			Flags: []cli.Flag{
+				admin.OutputFlag,
+				cli.StringFlag{
+					Name:  "driver",
+					Usage: "the convoy driver to create the volume with. Defaults to convoy's default driver",
+				},
+				cli.StringFlag{
+					Name:  "size",
+					Usage: "size of the volume in bytes, or with a k, m, g or t suffix",
+				},
+				cli.StringFlag{
+					Name:  "backup",
+					Usage: "create the volume from this backup URL",
+				},
+				cli.StringFlag{
+					Name:  "id",
+					Usage: "use this existing volume of the driver, e.g. an EBS volume ID",
+				},
+				cli.StringFlag{
+					Name:  "type",
+					Usage: "driver specific volume type, e.g. an EBS volume type",
+				},
+				cli.IntFlag{
+					Name:  "iops",
+					Usage: "driver specific IOPS to provision",
+				},
+			},
+			Action: createVolume,
+		},
+		{
+			Name:  "delete",
+			Usage: "Delete volumes and their data after confirming: delete VOLUME_NAME... | --selector GLOB",
+			Flags: []cli.Flag{
+				selectorFlag,
+				cli.BoolFlag{
+					Name:  "yes, y",
+					Usage: "do not ask for confirmation",
+				},
+			},
+			Action: deleteVolumes,
+		},
+		{
+			Name:  "mount",
+			Usage: "Mount a volume: mount VOLUME_NAME",
+			Flags: []cli.Flag{
+				admin.OutputFlag,
+				cli.StringFlag{
+					Name:  "mountpoint",
+					Usage: "where to mount the volume. Defaults to a path chosen by the driver",
+				},
+			},
+			Action: mountVolume,
+		},
+		{
+			Name:   "umount",
+			Usage:  "Unmount volumes: umount VOLUME_NAME... | --selector GLOB",
+			Flags:  []cli.Flag{selectorFlag},
+			Action: umountVolumes,
+		},
+		{
+			Name:  "snapshot",
+			Usage: "Snapshot volumes: snapshot VOLUME_NAME... | --selector GLOB",
+			Flags: []cli.Flag{
+				admin.OutputFlag,
+				selectorFlag,
+				cli.StringFlag{
+					Name:  "name",
+					Usage: "name of the snapshot. Only allowed when snapshotting a single volume",
+				},
+			},
+			Action: snapshotVolumes,
+		},
+	},
+}
+
+func convoyFromContext(c *cli.Context) *ConvoyClient {
+	socket := c.GlobalString("socket")
+	if socket == "" {
+		log.Fatal("required field socket has not been set")
+	}
+	convoy, err := NewConvoyClient(socket)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return convoy
+}
+
+// selectVolumes returns the names of the volumes matching the glob, sorted.
+func selectVolumes(vols Volume, glob string) ([]string, error) {
+	names := []string{}
+	for _, vol := range vols {
+		matched, err := path.Match(glob, vol.Name)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid selector %q: %v", glob, err)
+		}
+		if matched {
+			names = append(names, vol.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// targetVolumes returns the volumes a command acts on: the volumes named as
+// arguments, or those matching --selector.
+func targetVolumes(c *cli.Context, convoy *ConvoyClient) []string {
+	glob := c.String("selector")
+	if glob == "" {
+		if len(c.Args()) == 0 {
+			log.Fatal("volume name or selector is required")
+		}
+		return c.Args()
+	}
+	if len(c.Args()) > 0 {
+		log.Fatal("volume names cannot be combined with selector")
+	}
+
+	vols, err := convoy.GetCurrVolumes()
+	if err != nil {
+		log.Fatal(err)
+	}
+	names, err := selectVolumes(vols, glob)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(names) == 0 {
+		log.Fatalf("no volumes match selector %s", glob)
+	}
+	return names
+}
+
+// confirm asks on out whether to go ahead with action on the named volumes,
+// reading the answer from in. Anything but yes is a no.
+func confirm(in io.Reader, out io.Writer, action string, names []string) bool {
+	fmt.Fprintf(out, "About to %s %d volume(s):\n", action, len(names))
+	for _, name := range names {
+		fmt.Fprintf(out, "  %s\n", name)
+	}
+	fmt.Fprint(out, "Continue? [y/N] ")
+
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// forEachVolume runs op on every volume, logging each failure, and returns an
+// error if any failed.
+func forEachVolume(names []string, action string, op func(name string) error) error {
+	failed := 0
+	for _, name := range names {
+		if err := op(name); err != nil {
+			log.Errorf("Cannot %s volume. Name: %v. Error: %v", action, name, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("Failed to %s %d of %d volume(s)", action, failed, len(names))
+	}
+	return nil
+}
+
+func output(c *cli.Context, v interface{}, table func(w io.Writer)) {
+	if err := admin.WriteOutput(os.Stdout, c.String("output"), v, table); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func writeVolumes(w io.Writer, vols []api.VolumeResponse) {
+	fmt.Fprintln(w, "NAME\tDRIVER\tMOUNTPOINT\tCREATED\tSNAPSHOTS")
+	for _, vol := range vols {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", vol.Name, vol.Driver, vol.MountPoint, vol.CreatedTime, len(vol.Snapshots))
+	}
+}
+
+func writeSnapshots(w io.Writer, snapshots []api.SnapshotResponse) {
+	fmt.Fprintln(w, "SNAPSHOT\tVOLUME\tCREATED")
+	for _, snapshot := range snapshots {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", snapshot.Name, snapshot.VolumeName, snapshot.CreatedTime)
+	}
+}
+
+func listVolumes(c *cli.Context) {
+	convoy := convoyFromContext(c)
+	vols, err := convoy.GetCurrVolumes()
+	if err != nil {
+		log.Fatal(err)
+	}
+	glob := c.String("selector")
+	if glob == "" {
+		glob = "*"
+	}
+	names, err := selectVolumes(vols, glob)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	byName := map[string]api.VolumeResponse{}
+	for _, vol := range vols {
+		byName[vol.Name] = vol
+	}
+	list := []api.VolumeResponse{}
+	for _, name := range names {
+		list = append(list, byName[name])
+	}
+	output(c, list, func(w io.Writer) {
+		writeVolumes(w, list)
+	})
+}
+
+func inspectVolumes(c *cli.Context) {
+	convoy := convoyFromContext(c)
+	vols := []api.VolumeResponse{}
+	for _, name := range targetVolumes(c, convoy) {
+		vol, err := convoy.GetVolume(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if vol == nil {
+			log.Fatalf("volume %s does not exist", name)
+		}
+		vols = append(vols, *vol)
+	}
+
+	output(c, vols, func(w io.Writer) {
+		writeVolumes(w, vols)
+		snapshots := []api.SnapshotResponse{}
+		for _, vol := range vols {
+			names := []string{}
+			for name := range vol.Snapshots {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				snapshot := vol.Snapshots[name]
+				if snapshot.VolumeName == "" {
+					snapshot.VolumeName = vol.Name
+				}
+				snapshots = append(snapshots, snapshot)
+			}
+		}
+		if len(snapshots) > 0 {
+			fmt.Fprintln(w)
+			writeSnapshots(w, snapshots)
+		}
+	})
+}
+
+func createVolume(c *cli.Context) {
+	name := c.Args().First()
+	if name == "" {
+		log.Fatal("volume name is required")
+	}
+	var size int64
+	if value := c.String("size"); value != "" {
+		var err error
+		if size, err = ParseSize(value); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	vol, err := convoyFromContext(c).CreateVolumeRequest(api.VolumeCreateRequest{
+		Name:           name,
+		DriverName:     c.String("driver"),
+		Size:           size,
+		BackupURL:      c.String("backup"),
+		DriverVolumeID: c.String("id"),
+		Type:           c.String("type"),
+		IOPS:           int64(c.Int("iops")),
+	})
+	if err != nil {
+		log.Fatalf("Cannot create volume. Name: %v. Error: %v", name, err)
+	}
+	output(c, vol, func(w io.Writer) {
+		writeVolumes(w, []api.VolumeResponse{*vol})
+	})
+}
+
+func deleteVolumes(c *cli.Context) {
+	convoy := convoyFromContext(c)
+	names := targetVolumes(c, convoy)
+	if !c.Bool("yes") && !confirm(os.Stdin, os.Stdout, "delete", names) {
+		log.Fatal("Aborted, no volumes were deleted")
+	}
+
+	err := forEachVolume(names, "delete", func(name string) error {
+		if err := convoy.DeleteVolume(name); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted %s\n", name)
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func mountVolume(c *cli.Context) {
+	name := c.Args().First()
+	if name == "" {
+		log.Fatal("volume name is required")
+	}
+	vol, err := convoyFromContext(c).MountVolume(name, c.String("mountpoint"))
+	if err != nil {
+		log.Fatalf("Cannot mount volume. Name: %v. Error: %v", name, err)
+	}
+	output(c, vol, func(w io.Writer) {
+		writeVolumes(w, []api.VolumeResponse{*vol})
+	})
+}
+
+func umountVolumes(c *cli.Context) {
+	convoy := convoyFromContext(c)
+	err := forEachVolume(targetVolumes(c, convoy), "unmount", func(name string) error {
+		if err := convoy.UmountVolume(name); err != nil {
+			return err
+		}
+		fmt.Printf("Unmounted %s\n", name)
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func snapshotVolumes(c *cli.Context) {
+	convoy := convoyFromContext(c)
+	names := targetVolumes(c, convoy)
+	snapshotName := c.String("name")
+	if snapshotName != "" && len(names) > 1 {
+		log.Fatal("name can only be given when snapshotting a single volume")
+	}
+
+	snapshots := []api.SnapshotResponse{}
+	err := forEachVolume(names, "snapshot", func(name string) error {
+		snapshot, err := convoy.CreateSnapshot(name, snapshotName)
+		if err != nil {
+			return err
+		}
+		if snapshot.VolumeName == "" {
+			snapshot.VolumeName = name
+		}
+		snapshots = append(snapshots, *snapshot)
+		return nil
+	})
+	output(c, snapshots, func(w io.Writer) {
+		writeSnapshots(w, snapshots)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
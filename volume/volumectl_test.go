@@ -0,0 +1,133 @@
+package volume
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/convoy/api"
+	"gopkg.in/check.v1"
+)
+
+type VolumeCtlTestSuite struct {
+	listener net.Listener
+	convoy   *ConvoyClient
+	requests []string
+}
+
+var _ = check.Suite(&VolumeCtlTestSuite{})
+
+// SetUpTest serves a fake convoy that answers volume and snapshot requests
+// the way convoy does for verbose requests.
+func (s *VolumeCtlTestSuite) SetUpTest(c *check.C) {
+	s.requests = nil
+	socket := filepath.Join(c.MkDir(), "convoy.sock")
+	l, err := net.Listen("unix", socket)
+	c.Assert(err, check.IsNil)
+	s.listener = l
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/volumes/create", func(w http.ResponseWriter, r *http.Request) {
+		req := api.VolumeCreateRequest{}
+		c.Check(json.NewDecoder(r.Body).Decode(&req), check.IsNil)
+		c.Check(req.Verbose, check.Equals, true)
+		s.requests = append(s.requests, "create "+req.Name+" "+req.DriverName)
+		json.NewEncoder(w).Encode(api.VolumeResponse{Name: req.Name, Driver: req.DriverName})
+	})
+	mux.HandleFunc("/v1/volumes/mount", func(w http.ResponseWriter, r *http.Request) {
+		req := api.VolumeMountRequest{}
+		c.Check(json.NewDecoder(r.Body).Decode(&req), check.IsNil)
+		s.requests = append(s.requests, "mount "+req.VolumeName+" "+req.MountPoint)
+		json.NewEncoder(w).Encode(api.VolumeResponse{Name: req.VolumeName, MountPoint: req.MountPoint})
+	})
+	mux.HandleFunc("/v1/volumes/umount", func(w http.ResponseWriter, r *http.Request) {
+		req := api.VolumeUmountRequest{}
+		c.Check(json.NewDecoder(r.Body).Decode(&req), check.IsNil)
+		if req.VolumeName == "missing" {
+			http.Error(w, "volume missing does not exist", http.StatusNotFound)
+			return
+		}
+		s.requests = append(s.requests, "umount "+req.VolumeName)
+	})
+	mux.HandleFunc("/v1/snapshots/create", func(w http.ResponseWriter, r *http.Request) {
+		req := api.SnapshotCreateRequest{}
+		c.Check(json.NewDecoder(r.Body).Decode(&req), check.IsNil)
+		s.requests = append(s.requests, "snapshot "+req.VolumeName+" "+req.Name)
+		json.NewEncoder(w).Encode(api.SnapshotResponse{Name: req.Name, VolumeName: req.VolumeName})
+	})
+	go http.Serve(l, mux)
+
+	s.convoy, err = NewConvoyClient(socket)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *VolumeCtlTestSuite) TearDownTest(c *check.C) {
+	s.listener.Close()
+}
+
+func (s *VolumeCtlTestSuite) TestClient(c *check.C) {
+	vol, err := s.convoy.CreateVolumeRequest(api.VolumeCreateRequest{Name: "foo", DriverName: "glusterfs"})
+	c.Assert(err, check.IsNil)
+	c.Assert(vol.Name, check.Equals, "foo")
+	c.Assert(vol.Driver, check.Equals, "glusterfs")
+
+	vol, err = s.convoy.MountVolume("foo", "/mnt/foo")
+	c.Assert(err, check.IsNil)
+	c.Assert(vol.MountPoint, check.Equals, "/mnt/foo")
+
+	snapshot, err := s.convoy.CreateSnapshot("foo", "snap1")
+	c.Assert(err, check.IsNil)
+	c.Assert(snapshot.Name, check.Equals, "snap1")
+	c.Assert(snapshot.VolumeName, check.Equals, "foo")
+
+	c.Assert(s.convoy.UmountVolume("foo"), check.IsNil)
+	c.Assert(s.convoy.UmountVolume("missing"), check.ErrorMatches, "volume missing does not exist\n")
+
+	c.Assert(s.requests, check.DeepEquals, []string{
+		"create foo glusterfs",
+		"mount foo /mnt/foo",
+		"snapshot foo snap1",
+		"umount foo",
+	})
+}
+
+func (s *VolumeCtlTestSuite) TestForEachVolume(c *check.C) {
+	err := forEachVolume([]string{"foo", "missing", "bar"}, "unmount", s.convoy.UmountVolume)
+	c.Assert(err, check.ErrorMatches, "Failed to unmount 1 of 3 volume\\(s\\)")
+	c.Assert(s.requests, check.DeepEquals, []string{"umount foo", "umount bar"})
+}
+
+func (s *VolumeCtlTestSuite) TestSelectVolumes(c *check.C) {
+	vols := Volume{
+		"ci-2":  api.VolumeResponse{Name: "ci-2"},
+		"ci-1":  api.VolumeResponse{Name: "ci-1"},
+		"db":    api.VolumeResponse{Name: "db"},
+		"ci":    api.VolumeResponse{Name: "ci"},
+		"xci-1": api.VolumeResponse{Name: "xci-1"},
+	}
+	names, err := selectVolumes(vols, "ci-*")
+	c.Assert(err, check.IsNil)
+	c.Assert(names, check.DeepEquals, []string{"ci-1", "ci-2"})
+
+	names, err = selectVolumes(vols, "nothing*")
+	c.Assert(err, check.IsNil)
+	c.Assert(names, check.HasLen, 0)
+
+	_, err = selectVolumes(vols, "ci-[")
+	c.Assert(err, check.ErrorMatches, "Invalid selector .*")
+}
+
+func (s *VolumeCtlTestSuite) TestConfirm(c *check.C) {
+	out := &bytes.Buffer{}
+	c.Assert(confirm(strings.NewReader("y\n"), out, "delete", []string{"ci-1", "ci-2"}), check.Equals, true)
+	c.Assert(out.String(), check.Equals, "About to delete 2 volume(s):\n  ci-1\n  ci-2\nContinue? [y/N] ")
+
+	c.Assert(confirm(strings.NewReader("YES\n"), out, "delete", []string{"ci-1"}), check.Equals, true)
+	c.Assert(confirm(strings.NewReader("\n"), out, "delete", []string{"ci-1"}), check.Equals, false)
+	c.Assert(confirm(strings.NewReader("nope\n"), out, "delete", []string{"ci-1"}), check.Equals, false)
+	// No answer at all, as when stdin is closed, is a no.
+	c.Assert(confirm(strings.NewReader(""), out, "delete", []string{"ci-1"}), check.Equals, false)
+}